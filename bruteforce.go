@@ -0,0 +1,178 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// BruteForceIndex does exact nearest neighbor search by scanning all the stored vectors. It is much slower than
+// HnswIndex on large data sets, but gives the ground truth needed to measure the recall of HNSW parameters.
+// It shares the SpaceType and SearchResult types with HnswIndex, so results of both can be compared directly.
+//
+// Like HnswIndex, a BruteForceIndex should be closed with Close when it is no longer used.
+type BruteForceIndex struct {
+	index *C.BruteForceIndex
+}
+
+// NewBruteForce creates a new brute force index holding at most maxElements vectors of dimension dim.
+func NewBruteForce(dim int, maxElements uint64, spaceType SpaceType) (*BruteForceIndex, error) {
+	if err := cpuFeatureCheck(); err != nil {
+		return nil, err
+	}
+
+	var cindex *C.BruteForceIndex
+	msg := cCall(func() { cindex = C.newBruteForce(cSpaceType(spaceType), C.int(dim), C.size_t(maxElements)) })
+	if cindex == nil {
+		return nil, cError("failed to create index", msg)
+	}
+
+	idx := &BruteForceIndex{index: cindex}
+	runtime.SetFinalizer(idx, (*BruteForceIndex).Close)
+	return idx, nil
+}
+
+// AddPoints adds points. Updates the point if it is already in the index.
+func (idx *BruteForceIndex) AddPoints(vectors [][]float32, labels []uint64) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 || len(labels) <= 0 {
+		return errNoVectorData
+	}
+
+	if len(labels) != len(vectors) {
+		return fmt.Errorf("%w: unmatched vectors size and labels size", ErrInvalidInput)
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return err
+	}
+
+	flatVectors := flatten2DArray(vectors)
+	if err := idx.checkInput(flatVectors); err != nil {
+		return err
+	}
+
+	var errCode C.int
+	msg := cCall(func() {
+		errCode = C.bruteForceAddPoints(idx.index,
+			(*C.float)(unsafe.Pointer(&flatVectors[0])),
+			C.int(len(vectors)),
+			(*C.size_t)(unsafe.Pointer(&labels[0])))
+	})
+
+	if int(errCode) != 0 {
+		return cError("add point failed", msg)
+	}
+
+	return nil
+}
+
+// checkInput returns an error for the first row of flat which cannot be inserted in or searched with the index,
+// see checkCosineInput.
+func (idx *BruteForceIndex) checkInput(flat []float32) error {
+	if idx.index.space_type == C.cosine {
+		return checkCosineInput(flat, int(idx.index.dim))
+	}
+	return nil
+}
+
+// SearchKNN returns the exact topK nearest neighbors of each of the queried vectors, ordered by ascending distance.
+// concurrency set the threads to use for searching, 0 meaning 1 and a negative value runtime.GOMAXPROCS(0).
+// Like HnswIndex.SearchKNN, a row holds fewer than topK results when the index has fewer than topK elements.
+func (idx *BruteForceIndex) SearchKNN(vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return nil, err
+	}
+
+	if uint64(topK) > uint64(C.bruteForceGetMaxElements(idx.index)) {
+		return nil, fmt.Errorf("%w: topK is larger than maxElements", ErrInvalidInput)
+	}
+
+	if err := checkResultSize(len(vectors), topK); err != nil {
+		return nil, err
+	}
+
+	rows := len(vectors)
+	flatVectors := flatten2DArray(vectors)
+	if err := idx.checkInput(flatVectors); err != nil {
+		return nil, err
+	}
+
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.bruteForceSearchKnn(idx.index,
+			(*C.float)(unsafe.Pointer(&flatVectors[0])),
+			C.int(rows),
+			C.int(topK),
+			C.int(resolveConcurrency(concurrency, 0)),
+		)
+	})
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, rows, topK), nil
+}
+
+// Dim returns the dimension of the vectors. Returns 0 if the index is closed.
+func (idx *BruteForceIndex) Dim() int {
+	if idx.index == nil {
+		return 0
+	}
+
+	return int(idx.index.dim)
+}
+
+// SpaceType returns the space type the index was created with. Returns L2 if the index is closed.
+func (idx *BruteForceIndex) SpaceType() SpaceType {
+	if idx.index == nil {
+		return L2
+	}
+
+	return goSpaceType(idx.index.space_type)
+}
+
+// GetMaxElements returns the maximum number of elements the index can hold. Returns 0 if the index is closed.
+func (idx *BruteForceIndex) GetMaxElements() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.bruteForceGetMaxElements(idx.index))
+}
+
+// GetCurrentCount returns the number of elements in the index. Returns 0 if the index is closed.
+func (idx *BruteForceIndex) GetCurrentCount() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.bruteForceGetCurrentCount(idx.index))
+}
+
+// Close frees resources bound to the index. Subsequent calls return an error.
+func (idx *BruteForceIndex) Close() error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	C.freeBruteForce(idx.index)
+	idx.index = nil
+	runtime.SetFinalizer(idx, nil)
+	return nil
+}
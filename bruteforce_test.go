@@ -0,0 +1,95 @@
+package hnswgo
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestBruteForceIndex(t *testing.T) {
+	const n = 500
+
+	bf, err := NewBruteForce(dim, n, L2)
+	if err != nil {
+		t.Fatalf("NewBruteForce failed: %v", err)
+	}
+	defer bf.Close()
+
+	if bf.Dim() != dim || bf.GetMaxElements() != n || bf.SpaceType() != L2 {
+		t.Errorf("unexpected parameters: dim %d, maxElements %d, space %v", bf.Dim(), bf.GetMaxElements(), bf.SpaceType())
+	}
+
+	points, labels := randomPoints(dim, 0, n)
+	if err := bf.AddPoints(points, labels); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+	if bf.GetCurrentCount() != n {
+		t.Errorf("expected %d elements, got %d", n, bf.GetCurrentCount())
+	}
+
+	queries := genQuery(dim, 5)
+	results, err := bf.SearchKNN(queries, 10, 2)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+
+	for i, query := range queries {
+		// compute the expected neighbors by hand.
+		dists := make([]float32, n)
+		order := make([]int, n)
+		for j, p := range points {
+			for k := range p {
+				d := p[k] - query[k]
+				dists[j] += d * d
+			}
+			order[j] = j
+		}
+		sort.Slice(order, func(a, b int) bool { return dists[order[a]] < dists[order[b]] })
+
+		if len(results[i]) != 10 {
+			t.Fatalf("query %d: expected 10 results, got %d", i, len(results[i]))
+		}
+		// hnswlib computes the distances with SIMD, so near ties may come in another order: compare the distances
+		// with a tolerance, and the labels with the distance computed here.
+		for j, r := range results[i] {
+			if math.Abs(float64(r.Distance-dists[order[j]])) > 1e-4 {
+				t.Errorf("query %d: expected distance %v at %d, got %v", i, dists[order[j]], j, r.Distance)
+			}
+			if r.Label >= n || math.Abs(float64(r.Distance-dists[r.Label])) > 1e-4 {
+				t.Errorf("query %d: distance %v does not match label %d", i, r.Distance, r.Label)
+			}
+		}
+	}
+
+	t.Run("fewer elements than topK", func(t *testing.T) {
+		small, err := NewBruteForce(dim, 10, Cosine)
+		if err != nil {
+			t.Fatalf("NewBruteForce failed: %v", err)
+		}
+		defer small.Close()
+
+		small.AddPoints(points[:3], labels[:3])
+		results, err := small.SearchKNN([][]float32{points[1]}, 10, 1)
+		if err != nil {
+			t.Fatalf("SearchKNN failed: %v", err)
+		}
+		if len(results[0]) != 3 || results[0][0].Label != 1 {
+			t.Errorf("expected 3 results starting with label 1, got %v", results[0])
+		}
+	})
+
+	t.Run("closed index", func(t *testing.T) {
+		closed, _ := NewBruteForce(dim, 10, L2)
+		closed.Close()
+
+		if err := closed.AddPoints(points[:1], labels[:1]); err == nil {
+			t.Error("expected error adding to a closed index")
+		}
+		if _, err := closed.SearchKNN(queries, 1, 1); err == nil {
+			t.Error("expected error searching a closed index")
+		}
+		if closed.Close() == nil {
+			t.Error("expected error closing twice")
+		}
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !hnswgo_portable && !hnswgo_nomarch
+
+package hnswgo
+
+// By default the C++ code is optimized for the CPU of the build machine, so the binary may crash with SIGILL
+// on older CPUs. Build with the hnswgo_portable tag to target a baseline instruction set instead, or with the
+// hnswgo_nomarch tag to pick the target with CGO_CXXFLAGS.
+
+// #cgo CXXFLAGS: -march=native
+import "C"
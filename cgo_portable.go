@@ -0,0 +1,11 @@
+//go:build hnswgo_portable && !hnswgo_nomarch
+
+package hnswgo
+
+// The hnswgo_portable build tag targets a baseline instruction set instead of the CPU of the build machine, so
+// that binaries built in CI run on any CPU of the architecture. x86-64-v2 still enables the SSE4.2 code paths of
+// hnswlib, while AVX ones are left out.
+
+// #cgo amd64 CXXFLAGS: -march=x86-64-v2
+// #cgo arm64 CXXFLAGS: -march=armv8-a
+import "C"
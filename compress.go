@@ -0,0 +1,93 @@
+package hnswgo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+)
+
+// gzipMagic starts every gzip stream, see RFC 1952. hnswlib index files start with the offset of the level 0
+// data, which is always 0, so a compressed file cannot be confused with an uncompressed one.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// SaveCompressed writes the index to location like WriteTo, compressed with gzip at level, which is one of the
+// compress/gzip levels from gzip.BestSpeed to gzip.BestCompression, or gzip.DefaultCompression. Higher levels
+// produce smaller files but take more CPU to save; loading takes about the same time whatever the level. Vectors
+// of random floats hardly compress, while the graph links and vectors with repeated or rounded values do.
+//
+// The file starts with the gzip magic bytes 0x1f 0x8b, which LoadCompressed uses to detect compressed files.
+// As with WriteTo, the metadata file written by Save is not written.
+func (idx *HnswIndex) SaveCompressed(location string, level int) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	// write to a temporary file first so that a failed save does not corrupt the previous file.
+	tmpName := location + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	err = writeCompressed(f, idx, level)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("save index failed: %w", err)
+	}
+
+	return os.Rename(tmpName, location)
+}
+
+func writeCompressed(f *os.File, idx *HnswIndex, level int) error {
+	buf := bufio.NewWriter(f)
+	zw, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err := idx.WriteTo(zw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// LoadCompressed loads an index written by SaveCompressed. Files written by Save are detected by the absence of
+// the gzip magic bytes and loaded as is, so LoadCompressed can replace Load when both kinds of files are stored.
+// The parameters have the same meaning as in Load. The decompressed index is buffered in a temporary file, as
+// done by LoadFrom.
+func LoadCompressed(location string, spaceType SpaceType, dim int, maxElements uint64, allowReplaceDeleted bool) (*HnswIndex, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(len(gzipMagic))
+	if err != nil || !bytes.Equal(magic, gzipMagic) {
+		return Load(location, spaceType, dim, maxElements, allowReplaceDeleted)
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed index %s: %w", location, err)
+	}
+	defer zr.Close()
+
+	idx, err := LoadFrom(zr, spaceType, dim, maxElements, allowReplaceDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed index %s: %w", location, err)
+	}
+	return idx, nil
+}
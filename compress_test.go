@@ -0,0 +1,55 @@
+package hnswgo
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSaveCompressed(t *testing.T) {
+	dir := t.TempDir()
+	compressed := filepath.Join(dir, "index.bin.gz")
+	plain := filepath.Join(dir, "index.bin")
+
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	if err := index.SaveCompressed(compressed, gzip.BestSpeed); err != nil {
+		t.Fatalf("SaveCompressed failed: %v", err)
+	}
+	if err := index.Save(plain); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if pathExists(compressed + ".tmp") {
+		t.Error("temporary file was left behind")
+	}
+
+	stat, _ := os.Stat(compressed)
+	if stat.Size() >= int64(index.IndexFileSize()) {
+		t.Errorf("expected the compressed file to be smaller than %d bytes, got %d", index.IndexFileSize(), stat.Size())
+	}
+
+	query := genQuery(dim, 1)
+	expected, _ := index.SearchKNN(query, 5, 1)
+	for _, location := range []string{compressed, plain} {
+		loaded, err := LoadCompressed(location, Cosine, dim, batchSize, false)
+		if err != nil {
+			t.Fatalf("LoadCompressed of %s failed: %v", location, err)
+		}
+
+		if loaded.GetCurrentCount() != index.GetCurrentCount() {
+			t.Errorf("%s: expected %d elements, got %d", location, index.GetCurrentCount(), loaded.GetCurrentCount())
+		}
+		results, _ := loaded.SearchKNN(query, 5, 1)
+		if !slices.EqualFunc(results[0], expected[0], func(a, b *SearchResult) bool { return *a == *b }) {
+			t.Errorf("%s: loaded index returns different results", location)
+		}
+		loaded.Close()
+	}
+
+	if err := index.SaveCompressed(compressed, 42); err == nil {
+		t.Error("expected error for an invalid compression level")
+	}
+}
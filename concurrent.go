@@ -0,0 +1,689 @@
+package hnswgo
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ConcurrentIndex wraps a HnswIndex with a sync.RWMutex so that it can be shared freely between goroutines.
+// Methods modifying the index (adding points, marking deletions, resizing, closing, setting ef) and the saves to
+// disk take the write lock, while searches and other read-only methods take the read lock and run concurrently
+// with each other.
+//
+// ConcurrentIndex exposes the same methods as HnswIndex so it can be used as a drop-in replacement.
+type ConcurrentIndex struct {
+	mu  sync.RWMutex
+	idx *HnswIndex
+}
+
+// NewConcurrentIndex wraps idx. idx must not be used directly afterwards, or the locking is bypassed.
+func NewConcurrentIndex(idx *HnswIndex) *ConcurrentIndex {
+	return &ConcurrentIndex{idx: idx}
+}
+
+// SetEf sets the query time ef parameter, see HnswIndex.SetEf. ef is read by every search so this takes the write lock.
+func (c *ConcurrentIndex) SetEf(ef int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SetEf(ef)
+}
+
+// SetEfConstruction sets the efConstruction of subsequent insertions under the write lock, see
+// HnswIndex.SetEfConstruction.
+func (c *ConcurrentIndex) SetEfConstruction(ef int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SetEfConstruction(ef)
+}
+
+// SetDefaultConcurrency sets the default number of threads under the write lock, see HnswIndex.SetDefaultConcurrency.
+func (c *ConcurrentIndex) SetDefaultConcurrency(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idx.SetDefaultConcurrency(n)
+}
+
+// SetDeterministic enables or disables single threaded insertion under the write lock, see HnswIndex.SetDeterministic.
+func (c *ConcurrentIndex) SetDeterministic(enable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idx.SetDeterministic(enable)
+}
+
+// SetStrictInput enables or disables the check of non-finite values under the write lock, see
+// HnswIndex.SetStrictInput.
+func (c *ConcurrentIndex) SetStrictInput(enable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idx.SetStrictInput(enable)
+}
+
+// SetAutoGrow enables or disables auto growth under the write lock, see HnswIndex.SetAutoGrow.
+func (c *ConcurrentIndex) SetAutoGrow(factor float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SetAutoGrow(factor)
+}
+
+// SetVisitedListPoolSize preallocates the visited lists of searches under the write lock, see
+// HnswIndex.SetVisitedListPoolSize.
+func (c *ConcurrentIndex) SetVisitedListPoolSize(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SetVisitedListPoolSize(n)
+}
+
+// GetEf returns the query time ef parameter, see HnswIndex.GetEf.
+func (c *ConcurrentIndex) GetEf() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetEf()
+}
+
+// GetVisitedListPoolSize returns the number of preallocated visited lists, see HnswIndex.GetVisitedListPoolSize.
+func (c *ConcurrentIndex) GetVisitedListPoolSize() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetVisitedListPoolSize()
+}
+
+// RemainingCapacity returns the number of new elements which fit in the index, see HnswIndex.RemainingCapacity.
+func (c *ConcurrentIndex) RemainingCapacity() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.RemainingCapacity()
+}
+
+// IsFull reports whether no new element fits in the index, see HnswIndex.IsFull.
+func (c *ConcurrentIndex) IsFull() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.IsFull()
+}
+
+// Config returns the parameters of the index, see HnswIndex.Config.
+func (c *ConcurrentIndex) Config() Options {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Config()
+}
+
+// IndexFileSize returns the index file size in bytes, see HnswIndex.IndexFileSize.
+func (c *ConcurrentIndex) IndexFileSize() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.IndexFileSize()
+}
+
+// Save writes index data to disk under the write lock, as concurrent saves to the same location would share their
+// temporary files, see HnswIndex.Save.
+func (c *ConcurrentIndex) Save(location string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.Save(location)
+}
+
+// SaveSubset saves the elements accepted by keep as a new index under the write lock, see HnswIndex.SaveSubset.
+func (c *ConcurrentIndex) SaveSubset(location string, keep func(label uint64) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SaveSubset(location, keep)
+}
+
+// SaveDelta writes the changes since the last full save under the write lock, see HnswIndex.SaveDelta.
+func (c *ConcurrentIndex) SaveDelta(location string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SaveDelta(location)
+}
+
+// SaveCompressed writes the gzip compressed index to disk under the write lock, see HnswIndex.SaveCompressed.
+func (c *ConcurrentIndex) SaveCompressed(location string, level int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.SaveCompressed(location, level)
+}
+
+// AddPointsWithOptions adds points under the write lock, see HnswIndex.AddPointsWithOptions.
+func (c *ConcurrentIndex) AddPointsWithOptions(vectors [][]float32, labels []uint64, opts AddPointsOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPointsWithOptions(vectors, labels, opts)
+}
+
+// AddPointsFlat adds the contiguous vectors of flat under the write lock, see HnswIndex.AddPointsFlat.
+func (c *ConcurrentIndex) AddPointsFlat(flat []float32, labels []uint64, concurrency int, replaceDeleted bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPointsFlat(flat, labels, concurrency, replaceDeleted)
+}
+
+// AddPoints adds points under the write lock, see HnswIndex.AddPoints.
+func (c *ConcurrentIndex) AddPoints(vectors [][]float32, labels []uint64, concurrency int, replaceDeleted bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPoints(vectors, labels, concurrency, replaceDeleted)
+}
+
+// AddPointsAutoLabel adds points with sequential labels under the write lock, see HnswIndex.AddPointsAutoLabel.
+func (c *ConcurrentIndex) AddPointsAutoLabel(vectors [][]float32, concurrency int) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPointsAutoLabel(vectors, concurrency)
+}
+
+// AddPointsProgress adds points under the write lock, see HnswIndex.AddPointsProgress.
+// progress must not call other methods of c as the lock is held while it runs.
+func (c *ConcurrentIndex) AddPointsProgress(vectors [][]float32, labels []uint64, concurrency int, replaceDeleted bool, progress func(done, total int)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPointsProgress(vectors, labels, concurrency, replaceDeleted, progress)
+}
+
+// AddPoints64 adds float64 points under the write lock, see HnswIndex.AddPoints64.
+func (c *ConcurrentIndex) AddPoints64(vectors [][]float64, labels []uint64, concurrency int, replaceDeleted bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPoints64(vectors, labels, concurrency, replaceDeleted)
+}
+
+// AddPoint adds a single point under the write lock, see HnswIndex.AddPoint.
+func (c *ConcurrentIndex) AddPoint(vector []float32, label uint64, replaceDeleted bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.AddPoint(vector, label, replaceDeleted)
+}
+
+// UpdatePoint replaces the vector of an element under the write lock, see HnswIndex.UpdatePoint.
+func (c *ConcurrentIndex) UpdatePoint(vector []float32, label uint64, updateNeighborList bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.UpdatePoint(vector, label, updateNeighborList)
+}
+
+// CheckVector validates vec against the index under the read lock, see HnswIndex.CheckVector.
+func (c *ConcurrentIndex) CheckVector(vec []float32) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.CheckVector(vec)
+}
+
+// SearchKNN does a batch query under the read lock, see HnswIndex.SearchKNN.
+func (c *ConcurrentIndex) SearchKNN(vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNN(vectors, topK, concurrency)
+}
+
+// SearchKNN64 does a batch query with float64 vectors under the read lock, see HnswIndex.SearchKNN64.
+func (c *ConcurrentIndex) SearchKNN64(vectors [][]float64, topK int, concurrency int) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNN64(vectors, topK, concurrency)
+}
+
+// SearchKNNWithEf does a batch query with a per call ef under the read lock, see HnswIndex.SearchKNNWithEf.
+func (c *ConcurrentIndex) SearchKNNWithEf(vectors [][]float32, topK, ef, concurrency int) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNWithEf(vectors, topK, ef, concurrency)
+}
+
+// SearchKNNValues does a batch query returning values under the read lock, see HnswIndex.SearchKNNValues.
+func (c *ConcurrentIndex) SearchKNNValues(vectors [][]float32, topK int, concurrency int) ([][]SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNValues(vectors, topK, concurrency)
+}
+
+// SearchKNNInto does a batch query into a reused buffer under the read lock, see HnswIndex.SearchKNNInto.
+func (c *ConcurrentIndex) SearchKNNInto(dst [][]SearchResult, vectors [][]float32, topK int, concurrency int) ([][]SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNInto(dst, vectors, topK, concurrency)
+}
+
+// SearchKNNContext does a cancellable batch query under the read lock, see HnswIndex.SearchKNNContext.
+func (c *ConcurrentIndex) SearchKNNContext(ctx context.Context, vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNContext(ctx, vectors, topK, concurrency)
+}
+
+// SearchKNNStream does a batch query passing the results of each row to fn under the read lock, see
+// HnswIndex.SearchKNNStream. fn must not call methods of c taking the write lock, which would deadlock.
+func (c *ConcurrentIndex) SearchKNNStream(vectors [][]float32, topK, concurrency int, fn func(rowIndex int, results []*SearchResult) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNStream(vectors, topK, concurrency, fn)
+}
+
+// SearchKNNVariadic does a batch query with a topK per query under the read lock, see HnswIndex.SearchKNNVariadic.
+func (c *ConcurrentIndex) SearchKNNVariadic(queries []Query) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNVariadic(queries)
+}
+
+// KthDistance returns the distance to the k-th nearest neighbor under the read lock, see HnswIndex.KthDistance.
+func (c *ConcurrentIndex) KthDistance(vector []float32, k int) (float32, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.KthDistance(vector, k)
+}
+
+// SearchKNNSimilarity queries the vectors returning similarity scores under the read lock, see
+// HnswIndex.SearchKNNSimilarity.
+func (c *ConcurrentIndex) SearchKNNSimilarity(vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNSimilarity(vectors, topK, concurrency)
+}
+
+// SearchKNNSingle queries a single vector under the read lock, see HnswIndex.SearchKNNSingle.
+func (c *ConcurrentIndex) SearchKNNSingle(vector []float32, topK int, concurrency int) ([]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNSingle(vector, topK, concurrency)
+}
+
+// SearchKNNMultiK queries a single vector for several k under the read lock, see HnswIndex.SearchKNNMultiK.
+func (c *ConcurrentIndex) SearchKNNMultiK(vector []float32, ks []int) (map[int][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNMultiK(vector, ks)
+}
+
+// SearchKNNRescore queries a single vector and rescores the candidates under the read lock, see
+// HnswIndex.SearchKNNRescore.
+func (c *ConcurrentIndex) SearchKNNRescore(vector []float32, topK, candidateK, concurrency int) ([]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNRescore(vector, topK, candidateK, concurrency)
+}
+
+// SearchKNNDedup queries a single vector keeping the closest neighbor per key under the read lock, see
+// HnswIndex.SearchKNNDedup. keyFn must not call other methods of c that take the write lock.
+func (c *ConcurrentIndex) SearchKNNDedup(vector []float32, topK, candidateK int, keyFn func(label uint64) uint64) ([]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNDedup(vector, topK, candidateK, keyFn)
+}
+
+// SearchKNNFlat queries the contiguous vectors of flat under the read lock, see HnswIndex.SearchKNNFlat.
+func (c *ConcurrentIndex) SearchKNNFlat(flat []float32, numVectors, topK, concurrency int) ([][]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNFlat(flat, numVectors, topK, concurrency)
+}
+
+// SearchKNNWithVectors queries a single vector and returns the neighbor vectors under the read lock,
+// see HnswIndex.SearchKNNWithVectors.
+func (c *ConcurrentIndex) SearchKNNWithVectors(vector []float32, topK, concurrency int) ([]*SearchResultWithVector, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNWithVectors(vector, topK, concurrency)
+}
+
+// SearchKNNFiltered does a filtered query under the read lock, see HnswIndex.SearchKNNFiltered.
+// filter must not call other methods of c that take the write lock.
+func (c *ConcurrentIndex) SearchKNNFiltered(vector []float32, topK int, filter func(label uint64) bool) ([]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNFiltered(vector, topK, filter)
+}
+
+// SearchKNNExcluding does a query skipping the excluded labels under the read lock, see
+// HnswIndex.SearchKNNExcluding.
+func (c *ConcurrentIndex) SearchKNNExcluding(vector []float32, topK int, exclude []uint64) ([]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchKNNExcluding(vector, topK, exclude)
+}
+
+// SearchRange does a range query under the read lock, see HnswIndex.SearchRange.
+func (c *ConcurrentIndex) SearchRange(vector []float32, radius float32, maxResults int) ([]*SearchResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SearchRange(vector, radius, maxResults)
+}
+
+// GetDataByLabel returns a copy of the stored vector under the read lock, see HnswIndex.GetDataByLabel.
+func (c *ConcurrentIndex) GetDataByLabel(label uint64) ([]float32, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetDataByLabel(label)
+}
+
+// GetDataByLabelInto copies the stored vector into dst under the read lock, see HnswIndex.GetDataByLabelInto.
+func (c *ConcurrentIndex) GetDataByLabelInto(label uint64, dst []float32) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetDataByLabelInto(label, dst)
+}
+
+// GetDataByLabels returns copies of the stored vectors under the read lock, see HnswIndex.GetDataByLabels.
+func (c *ConcurrentIndex) GetDataByLabels(labels []uint64) ([][]float32, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetDataByLabels(labels)
+}
+
+// DistanceBetween computes the distance between two stored elements, see HnswIndex.DistanceBetween.
+func (c *ConcurrentIndex) DistanceBetween(labelA, labelB uint64) (float32, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.DistanceBetween(labelA, labelB)
+}
+
+// DistanceToLabel computes the distance between vector and a stored element, see HnswIndex.DistanceToLabel.
+func (c *ConcurrentIndex) DistanceToLabel(vector []float32, label uint64) (float32, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.DistanceToLabel(vector, label)
+}
+
+// SetAllowReplaceDeleted enables or disables the replacement of deleted elements under the write lock, see
+// HnswIndex.SetAllowReplaceDeleted.
+func (c *ConcurrentIndex) SetAllowReplaceDeleted(allow bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idx.SetAllowReplaceDeleted(allow)
+}
+
+// ReadOnly reports whether the index was loaded by LoadReadOnly, see HnswIndex.ReadOnly.
+func (c *ConcurrentIndex) ReadOnly() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.ReadOnly()
+}
+
+// GetAllowReplaceDeleted reports whether deleted elements can be replaced, see HnswIndex.GetAllowReplaceDeleted.
+func (c *ConcurrentIndex) GetAllowReplaceDeleted() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetAllowReplaceDeleted()
+}
+
+// Dim returns the dimension of the vectors, see HnswIndex.Dim.
+func (c *ConcurrentIndex) Dim() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Dim()
+}
+
+// M returns the M parameter, see HnswIndex.M.
+func (c *ConcurrentIndex) M() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.M()
+}
+
+// EfConstruction returns the efConstruction parameter, see HnswIndex.EfConstruction.
+func (c *ConcurrentIndex) EfConstruction() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.EfConstruction()
+}
+
+// SpaceType returns the space type of the index, see HnswIndex.SpaceType.
+func (c *ConcurrentIndex) SpaceType() SpaceType {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.SpaceType()
+}
+
+// ContainsLabel reports whether label is in the index and not deleted, see HnswIndex.ContainsLabel.
+func (c *ConcurrentIndex) ContainsLabel(label uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.ContainsLabel(label)
+}
+
+// InternalID returns the hnswlib internal id of label, see HnswIndex.InternalID.
+func (c *ConcurrentIndex) InternalID(label uint64) (uint32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.InternalID(label)
+}
+
+// LabelForInternalID returns the label of an hnswlib internal id, see HnswIndex.LabelForInternalID.
+func (c *ConcurrentIndex) LabelForInternalID(id uint32) (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.LabelForInternalID(id)
+}
+
+// MaxLevel returns the top layer of the graph under the read lock, see HnswIndex.MaxLevel.
+func (c *ConcurrentIndex) MaxLevel() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.MaxLevel()
+}
+
+// LevelOf returns the top layer of the element with the label under the read lock, see HnswIndex.LevelOf.
+func (c *ConcurrentIndex) LevelOf(label uint64) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.LevelOf(label)
+}
+
+// Neighbors returns the neighbors of the element with the label under the read lock, see HnswIndex.Neighbors.
+func (c *ConcurrentIndex) Neighbors(label uint64, level int) ([]uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Neighbors(label, level)
+}
+
+// LabelStates returns the state of each label under the read lock, see HnswIndex.LabelStates.
+func (c *ConcurrentIndex) LabelStates(labels []uint64) ([]LabelState, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.LabelStates(labels)
+}
+
+// IsMarkedDeleted reports whether label is marked as deleted, see HnswIndex.IsMarkedDeleted.
+func (c *ConcurrentIndex) IsMarkedDeleted(label uint64) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.IsMarkedDeleted(label)
+}
+
+// MarkDeleted marks label as deleted under the write lock, see HnswIndex.MarkDeleted.
+func (c *ConcurrentIndex) MarkDeleted(label uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.MarkDeleted(label)
+}
+
+// UnmarkDeleted unmarks label under the write lock, see HnswIndex.UnmarkDeleted.
+func (c *ConcurrentIndex) UnmarkDeleted(label uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.UnmarkDeleted(label)
+}
+
+// MarkDeletedBatch marks labels as deleted under the write lock, see HnswIndex.MarkDeletedBatch.
+func (c *ConcurrentIndex) MarkDeletedBatch(labels []uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.MarkDeletedBatch(labels)
+}
+
+// UnmarkDeletedBatch unmarks labels under the write lock, see HnswIndex.UnmarkDeletedBatch.
+func (c *ConcurrentIndex) UnmarkDeletedBatch(labels []uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.UnmarkDeletedBatch(labels)
+}
+
+// Clear removes all the elements under the write lock, see HnswIndex.Clear.
+func (c *ConcurrentIndex) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.Clear()
+}
+
+// Compact rebuilds the index without its deleted elements under the write lock, see HnswIndex.Compact.
+func (c *ConcurrentIndex) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.Compact()
+}
+
+// Merge adds the live elements of other under the write lock, see HnswIndex.Merge. other is not locked, so it
+// must not be modified while it is merged.
+func (c *ConcurrentIndex) Merge(other *HnswIndex, policy MergePolicy) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.Merge(other, policy)
+}
+
+// ResizeIndex changes the capacity of the index under the write lock, see HnswIndex.ResizeIndex.
+func (c *ConcurrentIndex) ResizeIndex(newSize uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.ResizeIndex(newSize)
+}
+
+// GetMaxElements returns the capacity of the index, see HnswIndex.GetMaxElements.
+func (c *ConcurrentIndex) GetMaxElements() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetMaxElements()
+}
+
+// GetCurrentCount returns the number of elements in the index, see HnswIndex.GetCurrentCount.
+func (c *ConcurrentIndex) GetCurrentCount() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetCurrentCount()
+}
+
+// GetDeletedCount returns the number of elements marked as deleted, see HnswIndex.GetDeletedCount.
+func (c *ConcurrentIndex) GetDeletedCount() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetDeletedCount()
+}
+
+// GetLiveCount returns the number of elements not marked as deleted, see HnswIndex.GetLiveCount.
+func (c *ConcurrentIndex) GetLiveCount() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.GetLiveCount()
+}
+
+// EnableSearchMetrics enables or disables search metrics under the write lock, see HnswIndex.EnableSearchMetrics.
+func (c *ConcurrentIndex) EnableSearchMetrics(enable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idx.EnableSearchMetrics(enable)
+}
+
+// LastSearchStats returns the statistics of the last search under the read lock, see HnswIndex.LastSearchStats.
+func (c *ConcurrentIndex) LastSearchStats() SearchStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.LastSearchStats()
+}
+
+// Stats returns the metadata of the index under the read lock, see HnswIndex.Stats.
+func (c *ConcurrentIndex) Stats() IndexStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Stats()
+}
+
+// Verify runs sanity checks on the index under the read lock, see HnswIndex.Verify.
+func (c *ConcurrentIndex) Verify() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Verify()
+}
+
+// MemoryUsageBytes returns an estimate of the memory used by the index, see HnswIndex.MemoryUsageBytes.
+func (c *ConcurrentIndex) MemoryUsageBytes() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.MemoryUsageBytes()
+}
+
+// Prewarm reads the memory of the index and runs a few searches under the read lock, see HnswIndex.Prewarm.
+func (c *ConcurrentIndex) Prewarm(concurrency int) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Prewarm(concurrency)
+}
+
+// TuneEf finds the smallest ef reaching a target recall under the write lock, which keeps other searches from
+// running with the ef being tried, see HnswIndex.TuneEf.
+func (c *ConcurrentIndex) TuneEf(queries [][]float32, groundTruth [][]uint64, targetRecall float64, topK int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.TuneEf(queries, groundTruth, targetRecall, topK)
+}
+
+// Labels returns the labels of the elements not marked as deleted, see HnswIndex.Labels.
+func (c *ConcurrentIndex) Labels() ([]uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.Labels()
+}
+
+// ForEachLabel calls fn with each live label, see HnswIndex.ForEachLabel. The labels are copied in chunks under
+// the read lock, which is not held while fn runs, so fn may call other methods of c.
+func (c *ConcurrentIndex) ForEachLabel(fn func(label uint64) bool) error {
+	return c.idx.forEachLabel(c.mu.RLock, c.mu.RUnlock, fn)
+}
+
+// ExportVectors writes all the live vectors to w under the read lock, so the index is not modified during the
+// export, see HnswIndex.ExportVectors.
+func (c *ConcurrentIndex) ExportVectors(w io.Writer, format ExportFormat) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.ExportVectors(w, format)
+}
+
+// ImportVectors adds the rows read from r under the write lock, see HnswIndex.ImportVectors.
+func (c *ConcurrentIndex) ImportVectors(r io.Reader, format ExportFormat, concurrency int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.ImportVectors(r, format, concurrency)
+}
+
+// WriteTo serializes the index into w, see HnswIndex.WriteTo.
+func (c *ConcurrentIndex) WriteTo(w io.Writer) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.WriteTo(w)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, see HnswIndex.MarshalBinary.
+func (c *ConcurrentIndex) MarshalBinary() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.MarshalBinary()
+}
+
+// UnmarshalIndex replaces the index under the write lock, see HnswIndex.UnmarshalIndex.
+func (c *ConcurrentIndex) UnmarshalIndex(data []byte, spaceType SpaceType, dim int, maxElements uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.UnmarshalIndex(data, spaceType, dim, maxElements)
+}
+
+// Close frees the wrapped index once all in-flight calls have returned, see HnswIndex.Close.
+func (c *ConcurrentIndex) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idx.Close()
+}
+
+// Free resources bound to the index. Safe to call multiple times.
+//
+// Deprecated: use Close instead.
+func (c *ConcurrentIndex) Free() {
+	c.Close()
+}
@@ -0,0 +1,113 @@
+package hnswgo
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// run with -race to catch unsynchronized accesses.
+func TestConcurrentIndex(t *testing.T) {
+	const writers, readers, rounds = 4, 4, 20
+
+	index, err := New(dim, M, efConstruction, 55, uint64(writers*rounds*10), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	c := NewConcurrentIndex(index)
+	defer c.Close()
+
+	// make sure readers always have something to find.
+	points, labels := randomPoints(dim, 0, 10)
+	if err := c.AddPoints(points, labels, 1, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 1; r < rounds; r++ {
+				points, labels := randomPoints(dim, (w*rounds+r)*10, 10)
+				if err := c.AddPoints(points, labels, 2, false); err != nil {
+					t.Errorf("AddPoints failed: %v", err)
+					return
+				}
+				if err := c.MarkDeleted(labels[0]); err != nil {
+					t.Errorf("MarkDeleted failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				results, err := c.SearchKNN([][]float32{randomPoint(dim)}, 5, 1)
+				if err != nil {
+					t.Errorf("SearchKNN failed: %v", err)
+					return
+				}
+				if len(results[0]) == 0 {
+					t.Error("expected search results")
+					return
+				}
+				// the first 10 labels are never deleted by the writers.
+				if _, err := c.GetDataByLabel(uint64(i % 10)); err != nil {
+					t.Errorf("GetDataByLabel failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	expected := uint64(10 + writers*(rounds-1)*10)
+	if c.GetCurrentCount() != expected {
+		t.Errorf("expected %d elements, got %d", expected, c.GetCurrentCount())
+	}
+	if c.GetDeletedCount() != uint64(writers*(rounds-1)) {
+		t.Errorf("expected %d deleted elements, got %d", writers*(rounds-1), c.GetDeletedCount())
+	}
+}
+
+// run with -race: concurrent saves to the same location must not mix their temporary files.
+func TestConcurrentIndexSave(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, 100, Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	c := NewConcurrentIndex(index)
+	defer c.Close()
+
+	points, labels := randomPoints(dim, 0, 100)
+	if err := c.AddPoints(points, labels, 1, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+
+	location := filepath.Join(t.TempDir(), "index.bin")
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Save(location); err != nil {
+				t.Errorf("Save failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	loaded, err := Load(location, Cosine, dim, 100, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer loaded.Close()
+	if loaded.GetCurrentCount() != 100 {
+		t.Errorf("expected 100 elements, got %d", loaded.GetCurrentCount())
+	}
+}
@@ -0,0 +1,28 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"fmt"
+	"sync"
+)
+
+// The distance functions of hnswlib already pick at run time the fastest SIMD implementation among the ones
+// compiled in, but -march=native lets the compiler use the instruction set of the build machine anywhere in the
+// C++ code, see cgo_native.go. cpuFeatureCheck reports an unsupported instruction set as an error from the
+// constructors instead of a SIGILL at the first search. It cannot prevent a crash if the compiler used such
+// instructions before, e.g. while loading the library.
+
+var cpuCheckOnce = sync.OnceValue(func() error {
+	if feature := C.missingCpuFeature(); feature != nil {
+		return fmt.Errorf("hnswgo was compiled for the %s instruction set, which this CPU does not support: "+
+			"rebuild with the hnswgo_portable build tag", C.GoString(feature))
+	}
+	return nil
+})
+
+// cpuFeatureCheck returns an error if the C++ code was compiled for an instruction set extension the CPU does
+// not support. The check is only done once.
+func cpuFeatureCheck() error {
+	return cpuCheckOnce()
+}
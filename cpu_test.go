@@ -0,0 +1,10 @@
+package hnswgo
+
+import "testing"
+
+func TestCPUFeatureCheck(t *testing.T) {
+	// the tests run on the machine the package was compiled for.
+	if err := cpuFeatureCheck(); err != nil {
+		t.Errorf("expected the CPU to support the compiled instruction set, got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package hnswgo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// The hnswlib file format is a dump of the graph, and inserting an element rewires the neighbor lists of existing
+// elements, so a save limited to the new elements is not possible. Instead SaveDelta writes a changelog of the
+// labels changed since the last full save, which LoadWithDelta replays on top of the saved index.
+
+// deltaMagic starts every delta file written by SaveDelta.
+const deltaMagic = "hnswgo-delta"
+
+// deltaVersion is the version of the delta file format written by SaveDelta.
+const deltaVersion = 1
+
+// delta record operations.
+const (
+	// the label holds the vector following the record.
+	deltaSet byte = 1
+	// the label is deleted or absent.
+	deltaDelete byte = 2
+)
+
+// changeLog tracks the labels changed since the index was last saved, or loaded, with Save.
+type changeLog struct {
+	mu sync.Mutex
+	// identifies the save the changes are relative to, 0 if changes are not tracked.
+	saveID  uint64
+	labels  map[uint64]struct{}
+	cleared bool
+}
+
+// reset starts tracking the changes relative to the save identified by saveID. Changes are not tracked if
+// saveID is 0.
+func (l *changeLog) reset(saveID uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.saveID = saveID
+	l.labels = nil
+	l.cleared = false
+	if saveID != 0 {
+		l.labels = make(map[uint64]struct{})
+	}
+}
+
+// record adds the labels to the changed labels, if changes are tracked.
+func (l *changeLog) record(labels ...uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.labels == nil {
+		return
+	}
+	for _, label := range labels {
+		l.labels[label] = struct{}{}
+	}
+}
+
+// clear records that all the elements were removed, which a delta cannot represent.
+func (l *changeLog) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleared = true
+}
+
+// snapshot returns the save id and the labels changed since, sorted.
+func (l *changeLog) snapshot() (uint64, []uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.saveID == 0 {
+		return 0, nil, errors.New("no full save to take a delta from, call Save first")
+	}
+	if l.cleared {
+		return 0, nil, errors.New("the index was cleared since the last Save, a full Save is required")
+	}
+
+	labels := make([]uint64, 0, len(l.labels))
+	for label := range l.labels {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+	return l.saveID, labels, nil
+}
+
+// SaveDelta writes to location the changes made to the index since it was last saved by Save, or loaded from a
+// location saved by Save. Each call writes all the changes since that full save, replacing the previous delta,
+// so its size grows with the number of changed labels and a full Save should be done once it gets large.
+// LoadWithDelta restores the index from the full save and the delta.
+//
+// The delta holds the current vector of every added or updated label and the labels deleted since the full
+// save. An error is returned if there was no full save or if the index was cleared since.
+func (idx *HnswIndex) SaveDelta(location string) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	saveID, labels, err := idx.changes.snapshot()
+	if err != nil {
+		return err
+	}
+
+	// labels marked deleted, or replaced since, are written as deleted.
+	var live, deleted []uint64
+	for _, label := range labels {
+		if isDeleted, err := idx.IsMarkedDeleted(label); err == nil && !isDeleted {
+			live = append(live, label)
+		} else {
+			deleted = append(deleted, label)
+		}
+	}
+
+	var vectors [][]float32
+	if len(live) > 0 {
+		if vectors, err = idx.GetDataByLabels(live); err != nil {
+			return err
+		}
+	}
+
+	// write to a temporary file first so that a failed save does not corrupt the previous delta.
+	tmpName := location + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	w := bufio.NewWriter(f)
+	err = writeDelta(w, saveID, idx.Dim(), live, vectors, deleted)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, location)
+}
+
+func writeDelta(w io.Writer, saveID uint64, dim int, live []uint64, vectors [][]float32, deleted []uint64) error {
+	header := struct {
+		Version uint32
+		Dim     uint32
+		SaveID  uint64
+		Count   uint64
+	}{deltaVersion, uint32(dim), saveID, uint64(len(live) + len(deleted))}
+
+	if _, err := io.WriteString(w, deltaMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	for i, label := range live {
+		if err := binary.Write(w, binary.LittleEndian, deltaSet); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, label); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, vectors[i]); err != nil {
+			return err
+		}
+	}
+
+	for _, label := range deleted {
+		if err := binary.Write(w, binary.LittleEndian, deltaDelete); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, label); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadWithDelta loads the index saved by Save at base, like Load, and replays the delta written at delta by
+// SaveDelta. An error is returned if the delta was not taken from that save, e.g. because base was saved again
+// since. The metadata file written by Save is required to check it. maxElements is raised if the replayed
+// elements do not fit.
+//
+// Changes keep being tracked relative to base, so SaveDelta can be called again on the loaded index.
+func LoadWithDelta(base, delta string, spaceType SpaceType, dim int, maxElements uint64, allowReplaceDeleted bool) (*HnswIndex, error) {
+	meta, err := loadMetadata(metadataPath(base))
+	if err != nil {
+		return nil, fmt.Errorf("index metadata of %s is required to apply a delta: %w", base, err)
+	}
+
+	f, err := os.Open(delta)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	labels, flat, deleted, err := readDelta(bufio.NewReader(f), meta.SaveID, dim)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta %s: %w", delta, err)
+	}
+
+	idx, err := Load(base, spaceType, dim, maxElements, allowReplaceDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := idx.applyDelta(labels, flat, deleted); err != nil {
+		idx.Close()
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+func readDelta(r io.Reader, saveID uint64, dim int) (labels []uint64, flat []float32, deleted []uint64, err error) {
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != deltaMagic {
+		return nil, nil, nil, errors.New("not a delta file")
+	}
+
+	var header struct {
+		Version uint32
+		Dim     uint32
+		SaveID  uint64
+		Count   uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, nil, nil, err
+	}
+	if header.Version != deltaVersion {
+		return nil, nil, nil, fmt.Errorf("unsupported delta version %d", header.Version)
+	}
+	if int(header.Dim) != dim {
+		return nil, nil, nil, fmt.Errorf("%w: delta dimension %d does not match %d", ErrDimMismatch, header.Dim, dim)
+	}
+	if header.SaveID != saveID {
+		return nil, nil, nil, errors.New("delta was not taken from the saved index")
+	}
+
+	vector := make([]float32, dim)
+	for i := uint64(0); i < header.Count; i++ {
+		var record struct {
+			Op    byte
+			Label uint64
+		}
+		if err := binary.Read(r, binary.LittleEndian, &record); err != nil {
+			return nil, nil, nil, err
+		}
+
+		switch record.Op {
+		case deltaSet:
+			if err := binary.Read(r, binary.LittleEndian, vector); err != nil {
+				return nil, nil, nil, err
+			}
+			labels = append(labels, record.Label)
+			flat = append(flat, vector...)
+		case deltaDelete:
+			deleted = append(deleted, record.Label)
+		default:
+			return nil, nil, nil, fmt.Errorf("unknown delta record %d", record.Op)
+		}
+	}
+
+	return labels, flat, deleted, nil
+}
+
+// applyDelta adds or updates the labels with the rows of flat and marks the deleted labels.
+func (idx *HnswIndex) applyDelta(labels []uint64, flat []float32, deleted []uint64) error {
+	if len(labels) > 0 {
+		if err := idx.unmarkDeletedLabels(labels); err != nil {
+			return err
+		}
+
+		if required := idx.GetCurrentCount() + uint64(len(labels)); required > idx.GetMaxElements() {
+			if err := idx.ResizeIndex(required); err != nil {
+				return err
+			}
+		}
+
+		if err := idx.addFlat(flat, labels, 0, false, 0); err != nil {
+			return err
+		}
+	}
+
+	for _, label := range deleted {
+		if isDeleted, err := idx.IsMarkedDeleted(label); err == nil && !isDeleted {
+			if err := idx.MarkDeleted(label); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
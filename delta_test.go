@@ -0,0 +1,80 @@
+package hnswgo
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSaveDelta(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "index.bin")
+	delta := filepath.Join(dir, "index.delta")
+
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	if err := index.SaveDelta(delta); err == nil {
+		t.Error("expected error for a delta without full save")
+	}
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+	if err := index.Save(base); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	index.SetAutoGrow(DefaultGrowFactor)
+	added, addedLabels := randomPoints(dim, batchSize, 20)
+	if err := index.AddPoints(added, addedLabels, 1, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+	updated := randomPoint(dim)
+	index.UpdatePoint(updated, 3, false)
+	index.MarkDeleted(5)
+	index.MarkDeleted(addedLabels[0])
+
+	if err := index.SaveDelta(delta); err != nil {
+		t.Fatalf("SaveDelta failed: %v", err)
+	}
+
+	loaded, err := LoadWithDelta(base, delta, L2, dim, uint64(batchSize), false)
+	if err != nil {
+		t.Fatalf("LoadWithDelta failed: %v", err)
+	}
+	defer loaded.Close()
+
+	if loaded.GetLiveCount() != index.GetLiveCount() {
+		t.Errorf("expected %d live elements, got %d", index.GetLiveCount(), loaded.GetLiveCount())
+	}
+	for _, label := range []uint64{5, addedLabels[0]} {
+		if loaded.ContainsLabel(label) {
+			t.Errorf("label %d should be deleted", label)
+		}
+	}
+	if v, _ := loaded.GetDataByLabel(3); !slices.Equal(v, updated) {
+		t.Error("updated vector was not replayed")
+	}
+	if v, _ := loaded.GetDataByLabel(addedLabels[5]); !slices.Equal(v, added[5]) {
+		t.Error("added vector was not replayed")
+	}
+
+	t.Run("stale delta", func(t *testing.T) {
+		if err := index.Save(base); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		if _, err := LoadWithDelta(base, delta, L2, dim, uint64(batchSize), false); err == nil {
+			t.Error("expected error for a delta taken from a previous save")
+		}
+	})
+
+	t.Run("cleared", func(t *testing.T) {
+		index.Clear()
+		if err := index.SaveDelta(delta); err == nil {
+			t.Error("expected error for a delta of a cleared index")
+		}
+	})
+}
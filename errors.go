@@ -0,0 +1,76 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Errors returned by the package wrap one of the following errors when they fall in its category, so they can
+// be told apart with errors.Is. Errors of hnswlib and of IO are returned with their own message.
+var (
+	// ErrIndexClosed is returned by the methods of an index which was closed.
+	ErrIndexClosed = errors.New("index already closed")
+	// ErrReadOnly is returned by the methods modifying an index loaded with LoadReadOnly.
+	ErrReadOnly = errors.New("index is read-only, see LoadReadOnly")
+	// ErrInvalidInput is wrapped by the errors of arguments out of their valid range, e.g. a non positive topK.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrDimMismatch is wrapped by the errors of vectors whose dimension is not the one of the index.
+	ErrDimMismatch = errors.New("unmatched dimensions of vector and index")
+	// ErrLabelNotFound is wrapped by the errors of labels not stored in the index or marked deleted.
+	ErrLabelNotFound = errors.New("label not found")
+	// ErrAlreadyDeleted is returned when marking deleted a label which is already marked deleted.
+	ErrAlreadyDeleted = errors.New("label is already marked deleted")
+	// ErrNotDeleted is returned when unmarking a label which is not marked deleted.
+	ErrNotDeleted = errors.New("label is not marked deleted")
+	// ErrNotNormalized is wrapped by the error returned by CheckVector for a vector of Cosine space which is not of
+	// unit length.
+	ErrNotNormalized = errors.New("vector is not normalized")
+)
+
+// errNoVectorData is returned for an empty vector or batch of vectors.
+var errNoVectorData = fmt.Errorf("%w: no vector data", ErrInvalidInput)
+
+// The wrapper catches the C++ exceptions thrown by hnswlib, which would abort the process if they reached Go,
+// and keeps the message in a thread local variable. cCall runs a wrapper call and reads the message back from
+// the same OS thread, so that it can be returned in a Go error.
+
+// lastError returns the message of the last exception caught by the wrapper on the current OS thread, or "" if
+// none was caught. The goroutine must stay locked to its thread since the failed call, see cCall.
+func lastError() string {
+	msg := C.hnswLastError()
+	if msg == nil {
+		return ""
+	}
+	return strings.TrimSpace(C.GoString(msg))
+}
+
+// cCall runs fn, which calls into the wrapper, and returns the message of the exception caught during the
+// call, or "" if there was none.
+func cCall(fn func()) string {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	C.hnswClearLastError()
+	fn()
+	return lastError()
+}
+
+// searchError returns the error of a search which returned no result.
+func searchError(msg string) error {
+	if msg == "" {
+		return errors.New("search failed: internal error")
+	}
+	return cError("search failed", msg)
+}
+
+// cError returns the error of a failed wrapper call, with the message of the caught exception if any.
+func cError(op string, msg string) error {
+	if msg == "" {
+		return errors.New(op)
+	}
+	return fmt.Errorf("%s: %s", op, msg)
+}
@@ -0,0 +1,98 @@
+package hnswgo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExceptionToError(t *testing.T) {
+	t.Run("Load", func(t *testing.T) {
+		location := filepath.Join(t.TempDir(), "index.bin")
+		index := newTestIndex(t, 1, false)
+		defer index.Close()
+
+		if err := index.Save(location); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		// hnswlib checks that the link lists end the file.
+		f, err := os.OpenFile(location, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("trailing"))
+		f.Close()
+
+		_, err = Load(location, Cosine, dim, batchSize, false)
+		if err == nil || !strings.Contains(err.Error(), "Index seems to be corrupted") {
+			t.Errorf("expected the hnswlib exception message, got %v", err)
+		}
+	})
+
+	t.Run("BruteForce", func(t *testing.T) {
+		bf, err := NewBruteForce(dim, 1, L2)
+		if err != nil {
+			t.Fatalf("NewBruteForce failed: %v", err)
+		}
+		defer bf.Close()
+
+		points, labels := randomPoints(dim, 0, 2)
+		err = bf.AddPoints(points, labels)
+		if err == nil || !strings.HasSuffix(err.Error(), "The number of elements exceeds the specified limit") {
+			t.Errorf("expected the hnswlib exception message, got %v", err)
+		}
+	})
+
+	t.Run("Cleared", func(t *testing.T) {
+		bf, err := NewBruteForce(dim, 1, L2)
+		if err != nil {
+			t.Fatalf("NewBruteForce failed: %v", err)
+		}
+		defer bf.Close()
+
+		points, labels := randomPoints(dim, 0, 2)
+		bf.AddPoints(points, labels)
+
+		// the message of a previous failure is not returned by a call which succeeds.
+		if msg := cCall(func() { bf.GetCurrentCount() }); msg != "" {
+			t.Errorf("expected no exception message, got %q", msg)
+		}
+	})
+}
+
+func TestSentinelErrors(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+
+	_, errDim := index.SearchKNN([][]float32{make([]float32, dim-1)}, 10, 1)
+	_, errTopK := index.SearchKNN(genQuery(dim, 1), batchSize+1, 1)
+	_, errLabel := index.GetDataByLabels([]uint64{batchSize})
+	index.MarkDeleted(0)
+	errDeleted := index.MarkDeleted(0)
+	errNotDeleted := index.UnmarkDeleted(1)
+	index.Close()
+	_, errClosed := index.SearchKNN(genQuery(dim, 1), 10, 1)
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"dimension", errDim, ErrDimMismatch},
+		{"topK", errTopK, ErrInvalidInput},
+		{"label", errLabel, ErrLabelNotFound},
+		{"deleted", errDeleted, ErrAlreadyDeleted},
+		{"not deleted", errNotDeleted, ErrNotDeleted},
+		{"closed", errClosed, ErrIndexClosed},
+	} {
+		if !errors.Is(tc.err, tc.expected) {
+			t.Errorf("%s: expected an error wrapping %q, got %v", tc.name, tc.expected, tc.err)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.bin"), Cosine, dim, batchSize, false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected an error wrapping os.ErrNotExist, got %v", err)
+	}
+}
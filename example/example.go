@@ -21,14 +21,22 @@ func main() {
 
 	var index *hnswgo.HnswIndex
 	if PathExists("./example.data") {
-		index = hnswgo.Load("./example.data", hnswgo.Cosine, dim, uint64(maxElements), true)
+		var err error
+		index, err = hnswgo.Load("./example.data", hnswgo.Cosine, dim, uint64(maxElements), true)
+		if err != nil {
+			panic(err)
+		}
 		index.SetEf(efConstruction)
-		defer index.Free()
+		defer index.Close()
 
 	} else {
 		start := time.Now()
-		index = hnswgo.New(dim, M, efConstruction, 432, uint64(maxElements), hnswgo.Cosine, true)
-		defer index.Free()
+		var err error
+		index, err = hnswgo.New(dim, M, efConstruction, 432, uint64(maxElements), hnswgo.Cosine, true)
+		if err != nil {
+			panic(err)
+		}
+		defer index.Close()
 
 		for i := 0; i < 100; i++ {
 			points, labels := randomPoints(dim, i*batchSize, batchSize)
@@ -0,0 +1,230 @@
+package hnswgo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ExportFormat is the row format written by ExportVectors and read by ImportVectors.
+type ExportFormat int
+
+const (
+	// ExportRaw writes each row as the label, a little-endian uint64, followed by the Dim components of the vector
+	// as little-endian float32, without any header or separator.
+	ExportRaw ExportFormat = iota
+	// ExportCSV writes each row as a CSV record holding the label followed by the components of the vector.
+	ExportCSV
+)
+
+// ExportVectors writes the label and vector of every element not marked deleted to w, in internal storage order.
+// The vectors are read from the index in chunks and written as they are read, so the whole dataset is never
+// held in memory. As with GetDataByLabel, Cosine vectors are exported normalized.
+//
+// The index must not be modified while it is exported, otherwise an error may be returned for a label deleted
+// in the meantime.
+func (idx *HnswIndex) ExportVectors(w io.Writer, format ExportFormat) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	var writeRows func(labels []uint64, vectors [][]float32) error
+	var flush func() error
+
+	switch format {
+	case ExportRaw:
+		bw := bufio.NewWriter(w)
+		writeRows = func(labels []uint64, vectors [][]float32) error {
+			for i, label := range labels {
+				if err := binary.Write(bw, binary.LittleEndian, label); err != nil {
+					return err
+				}
+				if err := binary.Write(bw, binary.LittleEndian, vectors[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		flush = bw.Flush
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		record := make([]string, idx.Dim()+1)
+		writeRows = func(labels []uint64, vectors [][]float32) error {
+			for i, label := range labels {
+				record[0] = strconv.FormatUint(label, 10)
+				for j, v := range vectors[i] {
+					record[j+1] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+				}
+				if err := cw.Write(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return fmt.Errorf("%w: unknown export format %d", ErrInvalidInput, format)
+	}
+
+	if err := idx.forEachLiveChunk(nil, writeRows); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// SaveSubset saves to location, like Save, a new index holding the elements not marked deleted whose label is
+// accepted by keep, e.g. a smaller preview of a large index. The new index has the parameters and settings of idx,
+// see Config, and a capacity of the number of kept elements. Its graph is built again from the kept vectors,
+// which are copied in chunks and inserted with the default concurrency of idx, so idx itself is not modified.
+//
+// keep is called once for each label, from the calling goroutine. As with ExportVectors, the index must not be
+// modified while the subset is copied.
+func (idx *HnswIndex) SaveSubset(location string, keep func(label uint64) bool) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	var labels []uint64
+	if err := idx.ForEachLabel(func(label uint64) bool {
+		if keep(label) {
+			labels = append(labels, label)
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+
+	opts := idx.Config()
+	// hnswlib needs a positive capacity, even for an empty subset.
+	opts.MaxElements = uint64(max(len(labels), 1))
+	subset, err := NewWithOptions(opts)
+	if err != nil {
+		return err
+	}
+	defer subset.Close()
+	subset.SetDefaultConcurrency(idx.concurrency)
+	subset.SetEf(idx.GetEf())
+
+	// ForEachLabel visits the labels in the same order again, so the kept ones are matched without calling keep.
+	next := 0
+	kept := func(label uint64) bool {
+		if next < len(labels) && labels[next] == label {
+			next++
+			return true
+		}
+		return false
+	}
+	err = idx.forEachLiveChunk(kept, func(chunk []uint64, vectors [][]float32) error {
+		return subset.AddPoints(vectors, chunk, 0, false)
+	})
+	if err != nil {
+		return fmt.Errorf("save subset failed: %w", err)
+	}
+
+	return subset.Save(location)
+}
+
+// ImportVectors reads rows written by ExportVectors in the given format from r and adds them to the index in
+// batches, with the given concurrency as in AddPoints. It returns the number of rows imported, which is also
+// set when an error stops the import midway. The index must have been created with the dimension of the
+// exported vectors, and must either have room for all of them or have auto growth enabled, see SetAutoGrow.
+//
+// Rows whose vector does not have the index dimension are rejected with an error naming the row.
+func (idx *HnswIndex) ImportVectors(r io.Reader, format ExportFormat, concurrency int) (int, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	dim := idx.Dim()
+	var readRow func(vector []float32) (uint64, error)
+
+	switch format {
+	case ExportRaw:
+		br := bufio.NewReader(r)
+		readRow = func(vector []float32) (uint64, error) {
+			var label uint64
+			if err := binary.Read(br, binary.LittleEndian, &label); err != nil {
+				return 0, err
+			}
+			if err := binary.Read(br, binary.LittleEndian, vector); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, fmt.Errorf("truncated vector: %w", err)
+			}
+			return label, nil
+		}
+	case ExportCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		readRow = func(vector []float32) (uint64, error) {
+			record, err := cr.Read()
+			if err != nil {
+				return 0, err
+			}
+			if len(record)-1 != dim {
+				return 0, fmt.Errorf("%w: got %d, want %d", ErrDimMismatch, len(record)-1, dim)
+			}
+
+			label, err := strconv.ParseUint(record[0], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid label: %w", err)
+			}
+			for j, field := range record[1:] {
+				v, err := strconv.ParseFloat(field, 32)
+				if err != nil {
+					return 0, fmt.Errorf("invalid component %d: %w", j, err)
+				}
+				vector[j] = float32(v)
+			}
+			return label, nil
+		}
+	default:
+		return 0, fmt.Errorf("%w: unknown export format %d", ErrInvalidInput, format)
+	}
+
+	const chunkSize = 1024
+	flat := make([]float32, chunkSize*dim)
+	labels := make([]uint64, 0, chunkSize)
+	imported := 0
+	addBatch := func() error {
+		if len(labels) == 0 {
+			return nil
+		}
+		if err := idx.addFlat(flat[:len(labels)*dim], labels, concurrency, false, 0); err != nil {
+			return err
+		}
+		imported += len(labels)
+		labels = labels[:0]
+		return nil
+	}
+
+	for row := 0; ; row++ {
+		n := len(labels)
+		label, err := readRow(flat[n*dim : (n+1)*dim])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		labels = append(labels, label)
+		if len(labels) == chunkSize {
+			if err := addBatch(); err != nil {
+				return imported, err
+			}
+		}
+	}
+
+	return imported, addBatch()
+}
@@ -0,0 +1,200 @@
+package hnswgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExportVectors(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, 3000, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	// more points than a chunk of the export.
+	points, labels := randomPoints(dim, 0, 2500)
+	index.AddPoints(points, labels, 1, false)
+	index.MarkDeleted(7)
+
+	t.Run("raw", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := index.ExportVectors(&buf, ExportRaw); err != nil {
+			t.Fatalf("ExportVectors failed: %v", err)
+		}
+
+		rowSize := 8 + 4*dim
+		if buf.Len() != rowSize*2499 {
+			t.Fatalf("expected %d bytes, got %d", rowSize*2499, buf.Len())
+		}
+
+		data := buf.Bytes()
+		for off := 0; off < len(data); off += rowSize {
+			label := binary.LittleEndian.Uint64(data[off:])
+			if label == 7 {
+				t.Fatal("deleted label was exported")
+			}
+			for j := 0; j < dim; j++ {
+				v := math.Float32frombits(binary.LittleEndian.Uint32(data[off+8+4*j:]))
+				if v != points[label][j] {
+					t.Fatalf("label %d: component %d is %v, want %v", label, j, v, points[label][j])
+				}
+			}
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := index.ExportVectors(&buf, ExportCSV); err != nil {
+			t.Fatalf("ExportVectors failed: %v", err)
+		}
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("invalid CSV: %v", err)
+		}
+		if len(records) != 2499 {
+			t.Fatalf("expected 2499 records, got %d", len(records))
+		}
+
+		for _, record := range records {
+			label, _ := strconv.ParseUint(record[0], 10, 64)
+			if len(record) != dim+1 {
+				t.Fatalf("label %d: expected %d fields, got %d", label, dim+1, len(record))
+			}
+			for j, field := range record[1:] {
+				v, _ := strconv.ParseFloat(field, 32)
+				if float32(v) != points[label][j] {
+					t.Fatalf("label %d: component %d is %v, want %v", label, j, v, points[label][j])
+				}
+			}
+		}
+	})
+
+	if err := index.ExportVectors(&bytes.Buffer{}, ExportFormat(42)); err == nil {
+		t.Error("expected error for an unknown format")
+	}
+}
+
+func TestImportVectors(t *testing.T) {
+	source, err := New(dim, M, efConstruction, 55, 3000, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer source.Close()
+
+	points, labels := randomPoints(dim, 0, 2500)
+	source.AddPoints(points, labels, 1, false)
+
+	for name, format := range map[string]ExportFormat{"raw": ExportRaw, "csv": ExportCSV} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := source.ExportVectors(&buf, format); err != nil {
+				t.Fatalf("ExportVectors failed: %v", err)
+			}
+
+			// rebuilt with different parameters.
+			index, err := New(dim, 8, 50, 55, 100, L2, false)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			defer index.Close()
+			index.SetAutoGrow(DefaultGrowFactor)
+
+			n, err := index.ImportVectors(&buf, format, 2)
+			if err != nil {
+				t.Fatalf("ImportVectors failed: %v", err)
+			}
+			if n != 2500 || index.GetCurrentCount() != 2500 {
+				t.Fatalf("expected 2500 imported rows, got %d and a count of %d", n, index.GetCurrentCount())
+			}
+
+			for _, label := range []uint64{0, 1234, 2499} {
+				if v, _ := index.GetDataByLabel(label); !slices.Equal(v, points[label]) {
+					t.Errorf("label %d was not imported correctly", label)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid rows", func(t *testing.T) {
+		index, err := New(dim, M, efConstruction, 55, 10, L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer index.Close()
+
+		if _, err := index.ImportVectors(strings.NewReader("1,0.5,0.5\n"), ExportCSV, 1); err == nil {
+			t.Error("expected error for unmatched dimensions")
+		}
+
+		var buf bytes.Buffer
+		source.ExportVectors(&buf, ExportRaw)
+		truncated := buf.Bytes()[:2*(8+4*dim)+10]
+		n, err := index.ImportVectors(bytes.NewReader(truncated), ExportRaw, 1)
+		if err == nil || n != 0 {
+			t.Errorf("expected error for a truncated row before any insertion, got %d rows and %v", n, err)
+		}
+	})
+}
+
+func TestSaveSubset(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, 3*batchSize, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, 3*batchSize)
+	index.AddPoints(points, labels, 4, false)
+	index.MarkDeleted(2)
+	index.SetEf(50)
+
+	location := filepath.Join(t.TempDir(), "subset.bin")
+	even := func(label uint64) bool { return label%2 == 0 }
+	if err := index.SaveSubset(location, even); err != nil {
+		t.Fatalf("SaveSubset failed: %v", err)
+	}
+	if index.GetCurrentCount() != 3*batchSize || index.GetDeletedCount() != 1 {
+		t.Errorf("expected the source index to be left untouched")
+	}
+
+	subset, err := LoadAuto(location)
+	if err != nil {
+		t.Fatalf("LoadAuto failed: %v", err)
+	}
+	defer subset.Close()
+
+	// the even labels but the deleted one.
+	kept, _ := subset.Labels()
+	slices.Sort(kept)
+	if len(kept) != 3*batchSize/2-1 || subset.GetMaxElements() != uint64(len(kept)) {
+		t.Fatalf("expected %d elements in a full index, got %d of %d", 3*batchSize/2-1, len(kept), subset.GetMaxElements())
+	}
+	for _, label := range kept {
+		if !even(label) || label == 2 {
+			t.Fatalf("unexpected label %d in the subset", label)
+		}
+		vec, err := subset.GetDataByLabel(label)
+		if err != nil || !slices.Equal(vec, points[label]) {
+			t.Fatalf("label %d: expected the vector of the source index, got %v", label, err)
+		}
+	}
+
+	results, err := subset.SearchKNN([][]float32{points[4]}, 1, 1)
+	if err != nil || results[0][0].Label != 4 {
+		t.Errorf("expected the subset to find its own vector, got %v, %v", results, err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.bin")
+	if err := index.SaveSubset(empty, func(uint64) bool { return false }); err != nil {
+		t.Fatalf("SaveSubset failed for an empty subset: %v", err)
+	}
+}
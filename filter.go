@@ -0,0 +1,100 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// goFilterLabel is called by the C++ filter functor during graph traversal. handle refers
+// to the func(uint64) bool registered by SearchKNNFiltered.
+//
+//export goFilterLabel
+func goFilterLabel(handle C.uintptr_t, label C.size_t) C.int {
+	filter := cgo.Handle(handle).Value().(func(label uint64) bool)
+	if filter(uint64(label)) {
+		return 1
+	}
+
+	return 0
+}
+
+// SearchKNNFiltered queries the index with a single vector, only returning labels for which filter returns true.
+// A nil filter accepts all labels.
+//
+// The filter is called from C++ for each candidate visited during the graph traversal, not on the final results,
+// so it must be cheap and must not call back into the index. Every call crosses the cgo boundary, which makes
+// filtered searches noticeably slower than plain ones. Fewer than topK results are returned if the filter
+// rejects too many labels.
+func (idx *HnswIndex) SearchKNNFiltered(vector []float32, topK int, filter func(label uint64) bool) ([]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vector) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return nil, ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return nil, err
+	}
+
+	if uint64(topK) > uint64(C.getMaxElements(idx.index)) {
+		return nil, fmt.Errorf("%w: topK is larger than maxElements", ErrInvalidInput)
+	}
+
+	if err := checkResultSize(1, topK); err != nil {
+		return nil, err
+	}
+
+	// only a handle is passed to C, so no Go pointer is held across the boundary.
+	var handle cgo.Handle
+	if filter != nil {
+		handle = cgo.NewHandle(filter)
+		defer handle.Delete()
+	}
+
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.searchKnnFiltered(idx.index,
+			(*C.float)(unsafe.Pointer(&vector[0])),
+			C.int(topK),
+			C.uintptr_t(handle),
+		)
+	})
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, 1, topK)[0], nil
+}
+
+// SearchKNNExcluding queries the index with a single vector, skipping the excluded labels during the graph
+// traversal, e.g. the label of the queried vector itself or already shown results. Unlike removing them from
+// the results of SearchKNNSingle, topK results are still returned if the index holds enough other elements.
+// It is a SearchKNNFiltered with a filter rejecting the excluded labels, so it has the same cost.
+func (idx *HnswIndex) SearchKNNExcluding(vector []float32, topK int, exclude []uint64) ([]*SearchResult, error) {
+	if len(exclude) == 0 {
+		return idx.SearchKNNFiltered(vector, topK, nil)
+	}
+
+	excluded := make(map[uint64]struct{}, len(exclude))
+	for _, label := range exclude {
+		excluded[label] = struct{}{}
+	}
+
+	return idx.SearchKNNFiltered(vector, topK, func(label uint64) bool {
+		_, ok := excluded[label]
+		return !ok
+	})
+}
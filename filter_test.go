@@ -0,0 +1,93 @@
+package hnswgo
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSearchKNNFiltered(t *testing.T) {
+	t.Run("EvenLabels", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Close()
+
+		result, err := index.SearchKNNFiltered(randomPoint(dim), 10, func(label uint64) bool {
+			return label%2 == 0
+		})
+		if err != nil {
+			t.Fatalf("SearchKNNFiltered failed: %v", err)
+		}
+
+		if len(result) != 10 {
+			t.Errorf("expected 10 results, got %d", len(result))
+		}
+
+		for i, r := range result {
+			if r.Label%2 != 0 {
+				t.Errorf("result %d has label %d rejected by the filter", i, r.Label)
+			}
+		}
+	})
+
+	t.Run("FewerThanTopK", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Close()
+
+		result, err := index.SearchKNNFiltered(randomPoint(dim), 10, func(label uint64) bool {
+			return label < 3
+		})
+		if err != nil {
+			t.Fatalf("SearchKNNFiltered failed: %v", err)
+		}
+
+		if len(result) != 3 {
+			t.Errorf("expected 3 results, got %d", len(result))
+		}
+	})
+
+	t.Run("NilFilter", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Close()
+
+		result, err := index.SearchKNNFiltered(randomPoint(dim), 5, nil)
+		if err != nil {
+			t.Fatalf("SearchKNNFiltered failed: %v", err)
+		}
+
+		if len(result) != 5 {
+			t.Errorf("expected 5 results, got %d", len(result))
+		}
+	})
+}
+
+func TestSearchKNNExcluding(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	index.SetEf(efConstruction)
+	defer index.Close()
+
+	query := randomPoint(dim)
+	nearest, _ := index.SearchKNNSingle(query, 5, 1)
+	exclude := make([]uint64, len(nearest))
+	for i, r := range nearest {
+		exclude[i] = r.Label
+	}
+
+	result, err := index.SearchKNNExcluding(query, 10, exclude)
+	if err != nil {
+		t.Fatalf("SearchKNNExcluding failed: %v", err)
+	}
+	if len(result) != 10 {
+		t.Errorf("expected 10 results, got %d", len(result))
+	}
+	for i, r := range result {
+		if slices.Contains(exclude, r.Label) {
+			t.Errorf("result %d has the excluded label %d", i, r.Label)
+		}
+	}
+
+	if result, _ := index.SearchKNNExcluding(query, 5, nil); len(result) != 5 {
+		t.Errorf("expected 5 results without exclusion, got %d", len(result))
+	}
+}
@@ -0,0 +1,92 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"fmt"
+	"sync"
+)
+
+// flat64Pool holds the buffers of flatten64, which are only used for the duration of a C call.
+var flat64Pool sync.Pool
+
+// flatten64 is like flatten2DArray but narrows the float64 values to float32, into a buffer taken from
+// flat64Pool. The buffer must be given back with putFlat64 once it is no longer used.
+func flatten64(vectors [][]float64) []float32 {
+	size := 0
+	for _, vector := range vectors {
+		size += len(vector)
+	}
+
+	var flatVectors []float32
+	if buf, ok := flat64Pool.Get().(*[]float32); ok && cap(*buf) >= size {
+		flatVectors = (*buf)[:0]
+	} else {
+		// a buffer too small is dropped, so that the pool tends to hold the larger batches.
+		flatVectors = make([]float32, 0, size)
+	}
+	for _, vector := range vectors {
+		for _, v := range vector {
+			flatVectors = append(flatVectors, float32(v))
+		}
+	}
+
+	return flatVectors
+}
+
+// putFlat64 gives a buffer returned by flatten64 back to flat64Pool.
+func putFlat64(flatVectors []float32) {
+	flat64Pool.Put(&flatVectors)
+}
+
+// AddPoints64 is like AddPoints for float64 vectors. The vectors are converted to float32 as stored by hnswlib,
+// so precision beyond float32 is lost and values out of the float32 range become infinite. The conversion is
+// done directly into the buffer passed to C, without allocating an intermediate [][]float32, and the buffer is
+// reused by the following calls of AddPoints64 and SearchKNN64.
+func (idx *HnswIndex) AddPoints64(vectors [][]float64, labels []uint64, concurrency int, replaceDeleted bool) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 || len(labels) <= 0 {
+		return errNoVectorData
+	}
+
+	if len(labels) != len(vectors) {
+		return fmt.Errorf("%w: unmatched vectors size and labels size", ErrInvalidInput)
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return err
+	}
+
+	flatVectors := flatten64(vectors)
+	defer putFlat64(flatVectors)
+	return idx.addFlat(flatVectors, labels, concurrency, replaceDeleted, 0)
+}
+
+// SearchKNN64 is like SearchKNN for float64 query vectors, which are converted to float32 as for AddPoints64.
+// Distances are returned as float32, like for SearchKNN.
+func (idx *HnswIndex) SearchKNN64(vectors [][]float64, topK int, concurrency int) ([][]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return nil, err
+	}
+
+	flatVectors := flatten64(vectors)
+	defer putFlat64(flatVectors)
+	cResult, err := idx.searchFlat(flatVectors, len(vectors), topK, 0, concurrency, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, len(vectors), topK), nil
+}
@@ -0,0 +1,58 @@
+package hnswgo
+
+import (
+	"testing"
+)
+
+func TestFloat64Vectors(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, 10)
+	points64 := make([][]float64, len(points))
+	for i, p := range points {
+		points64[i] = make([]float64, dim)
+		for j, v := range p {
+			points64[i][j] = float64(v) + 1e-12
+		}
+	}
+
+	if err := index.AddPoints64(points64, labels, 1, false); err != nil {
+		t.Fatalf("AddPoints64 failed: %v", err)
+	}
+
+	stored, _ := index.GetDataByLabel(4)
+	for j := range stored {
+		if stored[j] != points[4][j] {
+			t.Fatalf("expected %v at %d, got %v", points[4][j], j, stored[j])
+		}
+	}
+
+	results, err := index.SearchKNN64(points64[2:4], 1, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN64 failed: %v", err)
+	}
+	if results[0][0].Label != 2 || results[1][0].Label != 3 {
+		t.Errorf("expected labels 2 and 3, got %d and %d", results[0][0].Label, results[1][0].Label)
+	}
+
+	points64[5] = points64[5][:dim-1]
+	if err := index.AddPoints64(points64, labels, 1, false); err == nil {
+		t.Error("expected error for a ragged input")
+	}
+	if _, err := index.SearchKNN64(points64, 1, 1); err == nil {
+		t.Error("expected error for a ragged query")
+	}
+	if _, err := index.SearchKNN64(nil, 1, 1); err == nil {
+		t.Error("expected error for an empty query")
+	}
+
+	// a reused buffer is cut to the size of the new batch.
+	putFlat64(flatten64(points64[:4]))
+	if flat := flatten64(points64[:1]); len(flat) != dim || flat[0] != points[0][0] {
+		t.Errorf("expected the %d values of the first point, got %d values", dim, len(flat))
+	}
+}
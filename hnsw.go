@@ -1,14 +1,25 @@
 package hnswgo
 
-// #cgo CXXFLAGS: -fPIC -pthread -Wall -std=c++11 -O2 -march=native -I.
+// #cgo CXXFLAGS: -fPIC -pthread -Wall -std=c++11 -O2 -I.
 // #cgo LDFLAGS: -pthread
 // #cgo CFLAGS: -I./
 // #include <stdlib.h>
 // #include "hnsw_wrapper.h"
 import "C"
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
 	"runtime"
+	"runtime/cgo"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -16,17 +27,119 @@ type SpaceType int
 
 const (
 	L2 SpaceType = iota
+	// IP is the inner product distance, computed as 1 - dot(a, b).
 	IP
+	// Cosine is the cosine distance, computed as the inner product of normalized vectors. Vectors are L2-normalized
+	// by the index when they are added and queried, so raw vectors can be passed. Zero vectors are left unchanged.
 	Cosine
+	// L1 is the Manhattan distance.
+	L1
+	// Linf is the Chebyshev distance.
+	Linf
 )
 
+// spaceNames are the names of the space types returned by String and accepted by ParseSpaceType, matching the
+// space names of hnswlib.
+var spaceNames = map[SpaceType]string{
+	L2:     "l2",
+	IP:     "ip",
+	Cosine: "cosine",
+	L1:     "l1",
+	Linf:   "linf",
+}
+
+// String implements fmt.Stringer, returning the name of the space type, e.g. "cosine".
+func (s SpaceType) String() string {
+	if name, ok := spaceNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("SpaceType(%d)", int(s))
+}
+
+// ParseSpaceType returns the space type named name, as returned by SpaceType.String. Names are case-insensitive.
+func ParseSpaceType(name string) (SpaceType, error) {
+	for s, n := range spaceNames {
+		if strings.EqualFold(n, name) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown space type %q", ErrInvalidInput, name)
+}
+
+// MarshalJSON implements json.Marshaler, encoding the space type as its name, e.g. "cosine".
+func (s SpaceType) MarshalJSON() ([]byte, error) {
+	name, ok := spaceNames[s]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown space type %d", ErrInvalidInput, int(s))
+	}
+	return json.Marshal(name)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding a space type name as accepted by ParseSpaceType.
+func (s *SpaceType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("space type must be a string: %w", err)
+	}
+
+	parsed, err := ParseSpaceType(name)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
 // HnswIndex wraps the C index type and provides a set of useful index manipulation methods.
+//
+// An index should be released with Close once it is no longer used. Indexes that are never
+// closed are freed by a finalizer when they are garbage collected, but this is best-effort only:
+// the Go runtime does not know the size of the C++ memory held by the index and may run
+// the finalizer late or not at all.
+//
+// Searches, insertions, deletions and reads of stored elements can run concurrently, see the README for the
+// details. Close, Compact, UnmarshalIndex and the setters must not be called concurrently with any other method,
+// use ConcurrentIndex if this is needed.
 type HnswIndex struct {
 	index *C.HnswIndex
+	// default number of threads used when 0 is passed as concurrency, see SetDefaultConcurrency.
+	concurrency int
+	// factor by which the capacity grows when adding points to a full index, 0 if disabled. See SetAutoGrow.
+	growFactor float64
+	// seed passed to New, 0 for an index loaded from a file, which does not store it. See Config.
+	randSeed int
+	// insert with a single thread whatever the requested concurrency, see SetDeterministic.
+	deterministic bool
+	// reject NaN and infinite values in all spaces, see SetStrictInput.
+	strictInput bool
+	// statistics of the last search, only recorded when metrics are enabled. See EnableSearchMetrics.
+	lastStats atomic.Pointer[SearchStats]
+	// labels changed since the last Save, see SaveDelta.
+	changes *changeLog
+	// held for reading by searches and insertions of new labels, and for writing by the operations rewriting
+	// memory read by searches. See the concurrency section of the README.
+	rw sync.RWMutex
+	// elements being inserted under the read lock, which a resize of auto grow must account for.
+	reserved atomic.Int64
+	// labels being inserted under the read lock, see lockForAdd. pendingMu is held for reading by the methods
+	// reading stored elements, see lockLabels.
+	pendingMu sync.RWMutex
+	pending   map[uint64]struct{}
+	// closed and replaced when pending labels are released.
+	pendingDone chan struct{}
+	// number of elements when the first of the pending labels was claimed, all written. See writtenCount.
+	settled uint64
 }
 
+// DefaultGrowFactor is the recommended factor to pass to SetAutoGrow.
+const DefaultGrowFactor = 1.5
+
 // SearchResult is the result returned by search method. Field Distance may be of
 // euclidean distance or inner product distance, or cosine distance, depending on the chosen space type.
+//
+// Search methods always return results ordered by ascending Distance, so the closest neighbor comes first.
+// In IP and Cosine spaces the distance is 1 minus the inner product, so a larger inner product is closer
+// and the same ordering applies.
 type SearchResult struct {
 	Label    uint64
 	Distance float32
@@ -34,220 +147,2423 @@ type SearchResult struct {
 
 // Create a new HnswIndex with  the specified dimension and other parameters. For details please see hnswlib documents.
 // When allowReplaceDeleted is set, deleted elements can be replaced with new added ones.
-func New(dim, M, efConstruction, randSeed int, maxElements uint64, spaceType SpaceType, allowReplaceDeleted bool) *HnswIndex {
+// An error is returned if the underlying index could not be created, e.g. when memory allocation fails, or if
+// the CPU does not support the instruction set the package was compiled for.
+//
+// randSeed seeds the generator of the element levels. Two indexes created with the same parameters and seed,
+// to which the same points are added in the same order, have identical graphs and return identical search
+// results. Adding points with a concurrency greater than 1 makes the insertion order depend on thread
+// scheduling, see SetDeterministic.
+func New(dim, M, efConstruction, randSeed int, maxElements uint64, spaceType SpaceType, allowReplaceDeleted bool) (*HnswIndex, error) {
+	if err := cpuFeatureCheck(); err != nil {
+		return nil, err
+	}
+
 	var allowReplace int = 0
 	if allowReplaceDeleted {
 		allowReplace = 1
 	}
 
-	var sType C.spaceType = C.l2
-	switch spaceType {
-	case L2:
-		sType = C.l2
-	case IP:
-		sType = C.ip
-	case Cosine:
-		sType = C.cosine
+	sType := cSpaceType(spaceType)
+	var cindex *C.HnswIndex
+	msg := cCall(func() {
+		cindex = C.newIndex(sType, C.int(dim), C.size_t(maxElements), C.int(M), C.int(efConstruction), C.int(randSeed), C.int(allowReplace))
+	})
+	if cindex == nil {
+		return nil, cError("failed to create index", msg)
 	}
 
-	cindex := C.newIndex(sType, C.int(dim), C.size_t(maxElements), C.int(M), C.int(efConstruction), C.int(randSeed), C.int(allowReplace))
+	idx := wrapIndex(cindex)
+	idx.randSeed = randSeed
+	return idx, nil
+}
 
-	idx := &HnswIndex{
-		index: cindex,
+// Loads data from existing HNSW index. An error is returned if the index file does not exist
+// or could not be loaded, and an error wrapping ErrDimMismatch if dim is not the dimension of the saved vectors.
+func Load(location string, spaceType SpaceType, dim int, maxElements uint64, allowReplaceDeleted bool) (*HnswIndex, error) {
+	if err := cpuFeatureCheck(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(location); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("index file not found: %w", err)
+		}
+		return nil, err
+	}
+
+	// hnswlib takes the dimension from the space and would read the vectors of the file with the wrong size.
+	header, err := readHeader(location)
+	if err != nil {
+		return nil, err
+	}
+	if header.dim != dim {
+		return nil, fmt.Errorf("%w: index file %s has dimension %d, loaded with %d", ErrDimMismatch, location, header.dim, dim)
 	}
-	runtime.SetFinalizer(idx, (*HnswIndex).Free)
-	return idx
-}
 
-// Loads data from existing HNSW index.
-func Load(location string, spaceType SpaceType, dim int, maxElements uint64, allowReplaceDeleted bool) *HnswIndex {
 	var allowReplace int = 0
 	if allowReplaceDeleted {
 		allowReplace = 1
 	}
 
-	var sType C.spaceType = C.l2
+	sType := cSpaceType(spaceType)
+	cloc := C.CString(location)
+	defer C.free(unsafe.Pointer(cloc))
+
+	var cindex *C.HnswIndex
+	msg := cCall(func() {
+		cindex = C.loadIndex(cloc, sType, C.int(dim), C.size_t(maxElements), C.int(allowReplace))
+	})
+	if cindex == nil {
+		return nil, cError("failed to load index from "+location, msg)
+	}
+
+	idx := wrapIndex(cindex)
+	// changes are tracked relative to the loaded save if it has an id, see SaveDelta.
+	if meta, err := loadMetadata(metadataPath(location)); err == nil {
+		idx.changes.reset(meta.SaveID)
+	}
+	return idx, nil
+}
+
+// cSpaceType converts spaceType to the space type enum of the C wrapper. Unknown space types map to l2.
+func cSpaceType(spaceType SpaceType) C.spaceType {
 	switch spaceType {
-	case L2:
-		sType = C.l2
 	case IP:
-		sType = C.ip
+		return C.ip
 	case Cosine:
-		sType = C.cosine
+		return C.cosine
+	case L1:
+		return C.l1
+	case Linf:
+		return C.linf
+	default:
+		return C.l2
 	}
+}
 
-	cloc := C.CString(location)
-	defer C.free(unsafe.Pointer(cloc))
-
-	cindex := C.loadIndex(cloc, sType, C.int(dim), C.size_t(maxElements), C.int(allowReplace))
+// goSpaceType is the reverse of cSpaceType.
+func goSpaceType(sType C.spaceType) SpaceType {
+	switch sType {
+	case C.ip:
+		return IP
+	case C.cosine:
+		return Cosine
+	case C.l1:
+		return L1
+	case C.linf:
+		return Linf
+	default:
+		return L2
+	}
+}
 
+// wrapIndex creates the Go side index and registers a finalizer to free the C++ index
+// in case Close is never called.
+func wrapIndex(cindex *C.HnswIndex) *HnswIndex {
 	idx := &HnswIndex{
-		index: cindex,
+		index:   cindex,
+		changes: &changeLog{},
 	}
-	runtime.SetFinalizer(idx, (*HnswIndex).Free)
+	runtime.SetFinalizer(idx, (*HnswIndex).Close)
 	return idx
 }
 
 // Sets the query time accuracy/speed trade-off, defined by the ef parameter ( see doc ALGO_PARAMS.md of hnswlib).
 // Note that the parameter is currently not saved along with the index, so you need to set it manually after loading.
-func (idx *HnswIndex) SetEf(ef int) {
+//
+// ef should be at least the topK of the searches. A smaller ef is not an error: hnswlib searches with max(ef, topK)
+// candidates, so ef is raised to topK for those searches. An error is returned if ef is not positive.
+func (idx *HnswIndex) SetEf(ef int) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if ef <= 0 {
+		return fmt.Errorf("%w: invalid ef %d, must be positive", ErrInvalidInput, ef)
+	}
+
 	C.setEf(idx.index, C.size_t(ef))
+	return nil
+}
+
+// Returns the current query time ef parameter. hnswlib defaults it to 10 if SetEf has never been called.
+// Returns 0 if the index is closed.
+func (idx *HnswIndex) GetEf() int {
+	if idx.index == nil {
+		return 0
+	}
+
+	return int(C.getEf(idx.index))
+}
+
+// SetVisitedListPoolSize preallocates n visited lists, the per search structures of hnswlib which take 2 bytes
+// per element of GetMaxElements each. hnswlib never makes searches wait for a list: one is allocated when all the
+// lists are in use, and kept in the pool once the search returns, so the pool grows to the peak number of
+// concurrent searches. Setting n to the expected search concurrency saves those allocations from the first
+// searches, and calling it again frees the lists above n, e.g. after a burst of searches.
+//
+// The size is kept when the index is resized or compacted, but not saved. It waits for the running searches
+// and insertions, and blocks the new ones until it returns. An error is returned if n is not positive.
+func (idx *HnswIndex) SetVisitedListPoolSize(n int) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if n <= 0 {
+		return fmt.Errorf("%w: invalid visited list pool size %d, must be positive", ErrInvalidInput, n)
+	}
+
+	idx.rw.Lock()
+	defer idx.rw.Unlock()
+
+	var rc C.int
+	msg := cCall(func() { rc = C.setVisitedListPoolSize(idx.index, C.int(n)) })
+	if rc != 0 {
+		return cError("set visited list pool size failed", msg)
+	}
+	return nil
+}
+
+// Returns the number of visited lists preallocated by SetVisitedListPoolSize, 1 if it was never called, which is
+// the default of hnswlib. Returns 0 if the index is closed.
+func (idx *HnswIndex) GetVisitedListPoolSize() int {
+	if idx.index == nil {
+		return 0
+	}
+
+	return int(C.getVisitedListPoolSize(idx.index))
 }
 
-// Returns index file size in bytes.
+// Returns index file size in bytes. Returns 0 if the index is closed.
 func (idx *HnswIndex) IndexFileSize() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
 	sz := C.indexFileSize(idx.index)
 
 	return uint64(sz)
 }
 
-// Save writes index data to disk.
-func (idx *HnswIndex) Save(location string) {
-	cloc := C.CString(location)
-	defer C.free(unsafe.Pointer(cloc))
+// Save writes index data to disk. The space type, dimension, capacity and allowReplaceDeleted setting of the index
+// are written to a metadata file next to it, named after location with a ".meta" suffix, so that LoadAuto can
+// load the index without them.
+//
+// Both files are written to a temporary file with a ".tmp" suffix first, which is renamed to the final name once
+// completely written and synced to disk. If Save fails before the index file is replaced, location is left
+// untouched and still holds the previously saved index, if any. The previous metadata file is removed just before
+// the index file is replaced, so a process dying before the new one is written leaves an index without metadata
+// rather than the metadata of the previous save, with which LoadWithDelta would replay a stale delta.
+func (idx *HnswIndex) Save(location string) error {
+	tmpName := location + ".tmp"
+	if err := idx.saveTemp(tmpName); err != nil {
+		return err
+	}
+
+	metaPath := metadataPath(location)
+	if err := os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, location); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	// a zero id would mean that changes are not tracked.
+	saveID := rand.Uint64() | 1
+	if err := idx.saveMetadata(metaPath, saveID); err != nil {
+		return err
+	}
 
-	C.saveIndex(idx.index, cloc)
+	idx.changes.reset(saveID)
+	return nil
 }
 
-// Adds points. Updates the point if it is already in the index.
-// If replacement of deleted elements is enabled: replaces previously deleted point if any, updating it with new point.
-func (idx *HnswIndex) AddPoints(vectors [][]float32, labels []uint64, concurrency int, replaceDeleted bool) error {
-	var replace int = 0
-	if replaceDeleted {
-		replace = 1
+// save writes the hnswlib index file only.
+func (idx *HnswIndex) save(location string) error {
+	tmpName := location + ".tmp"
+	if err := idx.saveTemp(tmpName); err != nil {
+		return err
 	}
 
-	if len(vectors) <= 0 || len(labels) <= 0 {
-		return errors.New("invalid vector data")
+	return os.Rename(tmpName, location)
+}
+
+// saveTemp writes the hnswlib index file to tmpName and syncs it, removing it on error.
+func (idx *HnswIndex) saveTemp(tmpName string) error {
+	if idx.index == nil {
+		return ErrIndexClosed
 	}
 
-	if len(labels) != len(vectors) {
-		return errors.New("unmatched vectors size and labels size")
+	cloc := C.CString(tmpName)
+	defer C.free(unsafe.Pointer(cloc))
+
+	var errCode C.int
+	msg := cCall(func() { errCode = C.saveIndex(idx.index, cloc) })
+	if errCode != 0 {
+		os.Remove(tmpName)
+		return cError("save index failed", msg)
 	}
 
-	if len(vectors[0]) != int(idx.index.dim) {
-		return errors.New("unmatched dimensions of vector and index")
+	// hnswlib does not check the stream it writes to, so a short or missing file is the only sign of an IO error.
+	if err := syncFile(tmpName, int64(idx.IndexFileSize())); err != nil {
+		os.Remove(tmpName)
+		return err
 	}
 
-	rows := len(vectors)
-	flatVectors := flatten2DArray(vectors)
+	return nil
+}
 
-	//as a Go []float32 is layout-compatible with a C float[] so we can pass  Go slice directly to the C function as a pointer to its first element.
-	errCode := C.addPoints(idx.index,
-		(*C.float)(unsafe.Pointer(&flatVectors[0])),
-		C.int(rows),
-		(*C.size_t)(unsafe.Pointer(&labels[0])),
-		C.int(concurrency),
-		C.int(replace))
+// syncFile flushes the file at path to disk, after checking that it holds size bytes.
+func syncFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("save index failed: %w", err)
+	}
+	defer f.Close()
 
-	if int(errCode) != 0 {
-		return errors.New("add point failed, check logged error to see details")
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() != size {
+		return fmt.Errorf("save index failed: %d bytes written to %s, expected %d", stat.Size(), path, size)
+	}
+
+	return f.Sync()
+}
+
+// SetDefaultConcurrency sets the number of threads used by AddPoints and SearchKNN (and their variants) when they
+// are called with a concurrency of 0. A negative n means runtime.GOMAXPROCS(0) threads, which is also what
+// passing a negative concurrency to those methods does. Until SetDefaultConcurrency is called, the default is 1.
+//
+// hnswlib has no well defined behavior for 0 threads, so a concurrency of 0 is never passed down to it.
+// SetDefaultConcurrency must not be called concurrently with other methods of the index.
+//
+// Neither hnswlib nor the wrapper keep a thread pool, so there is no SetNumThreads: a call using several threads
+// starts them and joins them before returning, and a batch of at most 4 rows per thread is processed on the
+// calling thread without starting any. For many small batches, calling the methods with a concurrency of 1 from
+// several goroutines avoids starting threads on every call, see the concurrency section of the README.
+//
+// A concurrency of 0 thus means a single thread unless a default is set here. Negative values keep meaning
+// runtime.GOMAXPROCS(0) rather than 1, as existing callers already pass them to use all the processors.
+func (idx *HnswIndex) SetDefaultConcurrency(n int) {
+	idx.concurrency = n
+}
+
+// SetDeterministic makes AddPoints and its variants insert with a single thread whatever the concurrency they
+// are called with, so that builds from the same seed and points are reproducible, see New. Searches are not
+// affected. SetDeterministic must not be called concurrently with other methods of the index.
+func (idx *HnswIndex) SetDeterministic(enable bool) {
+	idx.deterministic = enable
+}
+
+// SetStrictInput makes the insertions and searches of the index return an error wrapping ErrInvalidInput, naming
+// the row, for vectors holding NaN or infinite values. Such values are not checked by default in L2, IP, L1 and
+// LInf spaces, where a NaN inserted in the graph would break the searches of the other vectors for good. Cosine
+// space always rejects them. The check scans every value once, which is small next to the cost of a search.
+// SetStrictInput must not be called concurrently with other methods of the index.
+func (idx *HnswIndex) SetStrictInput(enable bool) {
+	idx.strictInput = enable
+}
+
+// SetAutoGrow makes AddPoints and AddPoint resize the index instead of failing when the points would not fit
+// in GetMaxElements. The capacity is multiplied by factor, or grown to the required size if that is not enough.
+// A factor of 0 disables auto growth, which is the default, and any other factor must be greater than 1.
+//
+// The required size is computed assuming that all the added points are new, so the index may grow when
+// updating existing points of a full index. SetAutoGrow must not be called concurrently with other methods.
+func (idx *HnswIndex) SetAutoGrow(factor float64) error {
+	if factor != 0 && !(factor > 1) {
+		return fmt.Errorf("%w: invalid grow factor %v, must be 0 or greater than 1", ErrInvalidInput, factor)
 	}
 
+	idx.growFactor = factor
 	return nil
 }
 
-// flatten the vectors to prevent the "cgo argument has Go pointer to unpinned Go pointer" issue.
-func flatten2DArray(vectors [][]float32) []float32 {
-	rows := len(vectors)
-	dim := len(vectors[0])
-	flatVectors := make([]float32, 0, rows*dim)
+// grow resizes the index if auto growth is enabled and n more elements would not fit in it.
+func (idx *HnswIndex) grow(n int) error {
+	if !idx.needsGrow(n) {
+		return nil
+	}
 
-	for _, vector := range vectors {
-		flatVectors = append(flatVectors, vector...)
+	maxElements := uint64(C.getMaxElements(idx.index))
+	needed := uint64(C.getCurrentCount(idx.index)) + uint64(n)
+
+	newSize := uint64(math.Ceil(float64(maxElements) * idx.growFactor))
+	if newSize < needed {
+		newSize = needed
 	}
+	if err := idx.resize(newSize); err != nil {
+		return fmt.Errorf("auto grow failed: %w", err)
+	}
+	return nil
+}
 
-	return flatVectors
+// needsGrow reports whether grow resizes the index to add n elements.
+func (idx *HnswIndex) needsGrow(n int) bool {
+	return idx.growFactor != 0 && uint64(C.getCurrentCount(idx.index))+uint64(n) > uint64(C.getMaxElements(idx.index))
 }
 
-// SearchKNN do a batch query against the index using the provided vectors. concurrency set the threads to use for searching.
-// For each of the queried vector, topK SearchResults will be returned if no error occured.
-func (idx *HnswIndex) SearchKNN(vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
-	if len(vectors) <= 0 {
-		return nil, errors.New("invalid vector data")
+// lockForAdd takes the lock needed to add the labels, growing the index if needed, and returns the function
+// releasing it. The lock is exclusive if the index is resized or if elements are rewritten in place, i.e. if a
+// label is already stored, repeated in the batch or being inserted by another call, or if exclusive is set.
+func (idx *HnswIndex) lockForAdd(labels []uint64, exclusive bool) (func(), error) {
+	n := len(labels)
+	if !exclusive {
+		idx.rw.RLock()
+		if idx.claim(labels) {
+			if !idx.anyStored(labels) && idx.reserve(n) {
+				return func() {
+					idx.reserved.Add(-int64(n))
+					idx.unclaim(labels)
+					idx.rw.RUnlock()
+				}, nil
+			}
+			idx.unclaim(labels)
+		}
+		idx.rw.RUnlock()
 	}
 
-	if len(vectors[0]) != int(idx.index.dim) {
-		return nil, errors.New("unmatched dimensions of vector and index")
+	idx.rw.Lock()
+	if err := idx.grow(n); err != nil {
+		idx.rw.Unlock()
+		return nil, err
 	}
+	return idx.rw.Unlock, nil
+}
 
-	if uint64(topK) > uint64(C.getMaxElements(idx.index)) {
-		return nil, errors.New("topK is larger than maxElements")
+// claim marks the labels as being inserted under the read lock. It returns false, claiming nothing, if a label is
+// repeated in labels or already claimed by another insertion, which must then be made exclusive: the second
+// insertion of a label rewrites the element of the first one in place.
+func (idx *HnswIndex) claim(labels []uint64) bool {
+	idx.pendingMu.Lock()
+	defer idx.pendingMu.Unlock()
+	if idx.pending == nil {
+		idx.pending = make(map[uint64]struct{})
+		idx.pendingDone = make(chan struct{})
+	}
+	if len(idx.pending) == 0 {
+		// no insertion is in flight, and the exclusive ones are excluded by the read lock held by the caller.
+		idx.settled = uint64(C.getCurrentCount(idx.index))
+	}
+	for i, label := range labels {
+		if _, ok := idx.pending[label]; ok {
+			for _, claimed := range labels[:i] {
+				delete(idx.pending, claimed)
+			}
+			return false
+		}
+		idx.pending[label] = struct{}{}
 	}
+	return true
+}
 
-	rows := len(vectors)
-	flatVectors := flatten2DArray(vectors)
-	cResult := C.searchKnn(idx.index,
-		(*C.float)(unsafe.Pointer(&flatVectors[0])),
-		C.int(rows),
-		C.int(topK),
-		C.int(concurrency),
-	)
+// unclaim releases the labels claimed by claim.
+func (idx *HnswIndex) unclaim(labels []uint64) {
+	idx.pendingMu.Lock()
+	defer idx.pendingMu.Unlock()
+	for _, label := range labels {
+		delete(idx.pending, label)
+	}
+	close(idx.pendingDone)
+	idx.pendingDone = make(chan struct{})
+}
 
-	if cResult == nil {
-		return nil, errors.New("search failed: internal error")
+// lockLabels takes the read lock for a method reading or marking the stored elements of labels, which is released
+// by unlockLabels. hnswlib publishes the label of a new element before writing the element, so lockLabels first
+// waits for the insertions of the labels in flight under the read lock, then holds pendingMu for reading so that
+// no new one starts until the lock is released.
+func (idx *HnswIndex) lockLabels(labels ...uint64) {
+	idx.rw.RLock()
+	for {
+		idx.pendingMu.RLock()
+		done := idx.pendingDone
+		if !idx.anyPending(labels) {
+			return
+		}
+		idx.pendingMu.RUnlock()
+		<-done
 	}
-	defer C.freeResult(cResult)
+}
 
-	results := make([][]*SearchResult, rows) //the resulting slice
-	for rowID := range results {
-		rowTopk := make([]*SearchResult, topK)
-		for j := 0; j < topK; j++ {
-			r := SearchResult{}
-			r.Label = *(*uint64)(unsafe.Add(unsafe.Pointer(cResult.label), (rowID*topK+j)*C.sizeof_ulong))
-			r.Distance = *(*float32)(unsafe.Add(unsafe.Pointer(cResult.dist), (rowID*topK+j)*C.sizeof_float))
-			rowTopk[j] = &r
+// unlockLabels releases the lock taken by lockLabels.
+func (idx *HnswIndex) unlockLabels() {
+	idx.pendingMu.RUnlock()
+	idx.rw.RUnlock()
+}
+
+// anyPending reports whether any of the labels is being inserted under the read lock, the caller holding pendingMu.
+func (idx *HnswIndex) anyPending(labels []uint64) bool {
+	if len(idx.pending) == 0 {
+		return false
+	}
+	for _, label := range labels {
+		if _, ok := idx.pending[label]; ok {
+			return true
 		}
-		results[rowID] = rowTopk
 	}
+	return false
+}
 
-	return results, nil
+// writtenCount returns the number of leading internal ids whose elements are completely written, the caller holding
+// the read lock and pendingMu. The elements of the insertions in flight have ids of at least settled.
+func (idx *HnswIndex) writtenCount() uint64 {
+	if len(idx.pending) > 0 {
+		return idx.settled
+	}
+	return uint64(C.getCurrentCount(idx.index))
+}
 
+// reserve reserves the capacity for n elements inserted concurrently with other insertions, the caller holding
+// the read lock. It returns false if the index must grow first, in which case nothing is reserved.
+func (idx *HnswIndex) reserve(n int) bool {
+	reserved := idx.reserved.Add(int64(n))
+	// the count includes the elements already inserted by the reservations in flight, so this overestimates.
+	if idx.growFactor != 0 && uint64(C.getCurrentCount(idx.index))+uint64(reserved) > uint64(C.getMaxElements(idx.index)) {
+		idx.reserved.Add(-int64(n))
+		return false
+	}
+	return true
 }
 
-// Getting vector data by label.
-func (idx *HnswIndex) GetDataByLabel(label uint64) []float32 {
-	var vec []float32 = make([]float32, idx.index.dim)
+// anyStored reports whether any of the labels is stored in the index, deleted elements included.
+func (idx *HnswIndex) anyStored(labels []uint64) bool {
+	states := make([]C.int, len(labels))
+	C.getLabelStates(idx.index, (*C.size_t)(unsafe.Pointer(&labels[0])), C.int(len(labels)), &states[0])
+	for _, state := range states {
+		if state >= 0 {
+			return true
+		}
+	}
+	return false
+}
 
-	C.getDataByLabel(idx.index, C.size_t(label), (*C.float)(unsafe.Pointer(&vec[0])))
-	return vec
+// threads resolves the concurrency passed to a method to the positive number of threads given to hnswlib.
+func (idx *HnswIndex) threads(concurrency int) int {
+	return resolveConcurrency(concurrency, idx.concurrency)
 }
 
-// Get the setting of allowReplaceDeleted.
-func (idx *HnswIndex) GetAllowReplaceDeleted() bool {
-	return C.getAllowReplaceDeleted(idx.index) > 0
+// resolveConcurrency implements the concurrency rules of SetDefaultConcurrency, def being the default.
+func resolveConcurrency(concurrency, def int) int {
+	if concurrency == 0 {
+		concurrency = def
+	}
+
+	switch {
+	case concurrency < 0:
+		return runtime.GOMAXPROCS(0)
+	case concurrency == 0:
+		return 1
+	default:
+		return concurrency
+	}
 }
 
-// Marks the element as deleted, so it will be omitted from search results.
-func (idx *HnswIndex) MarkDeleted(label uint64) {
-	C.markDeleted(idx.index, C.size_t(label))
+// Adds points. Updates the point if it is already in the index.
+// If replacement of deleted elements is enabled: replaces previously deleted point if any, updating it with new point.
+// An error is returned if replaceDeleted is set on an index that does not allow it, see SetAllowReplaceDeleted.
+// concurrency set the threads to use for insertion, see SetDefaultConcurrency for the meaning of 0 and negative values.
+// In Cosine space, zero vectors and vectors holding NaN or infinite values have no distance and are rejected with
+// an error wrapping ErrInvalidInput, as they are by the searches.
+func (idx *HnswIndex) AddPoints(vectors [][]float32, labels []uint64, concurrency int, replaceDeleted bool) error {
+	return idx.addPoints(vectors, labels, concurrency, replaceDeleted, 0, 0)
 }
 
-// Unmarks the element as deleted, so it will be not be omitted from search results.
-func (idx *HnswIndex) UnmarkDeleted(label uint64) {
-	C.unmarkDeleted(idx.index, C.size_t(label))
+// addPoints implements AddPoints. progress is the handle of a progress callback, or 0 if progress is not reported,
+// and efConstruction overrides the one of the index if positive, see addFlatEf.
+func (idx *HnswIndex) addPoints(vectors [][]float32, labels []uint64, concurrency int, replaceDeleted bool, progress cgo.Handle, efConstruction int) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 || len(labels) <= 0 {
+		return errNoVectorData
+	}
+
+	if len(labels) != len(vectors) {
+		return fmt.Errorf("%w: unmatched vectors size and labels size", ErrInvalidInput)
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return err
+	}
+
+	return idx.addFlatEf(flatten2DArray(vectors), labels, concurrency, replaceDeleted, progress, efConstruction)
 }
 
-// Resize changes the maximum capacity of the index.
-func (idx *HnswIndex) ResizeIndex(newSize uint64) {
-	C.resizeIndex(idx.index, C.size_t(newSize))
+// AddPointsAutoLabel adds vectors with the sequential labels starting from GetCurrentCount, and returns the
+// label of the first vector, the i-th vector having label startLabel+i. It is meant for indexes whose labels are
+// all assigned this way, where the count, deleted elements included, is the next free label. An error is
+// returned and nothing is added if any of the labels is already stored, e.g. for labels chosen by the caller.
+//
+// The labels of concurrent calls would collide, so AddPointsAutoLabel must not be called concurrently with
+// other insertions.
+func (idx *HnswIndex) AddPointsAutoLabel(vectors [][]float32, concurrency int) (startLabel uint64, err error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return 0, ErrReadOnly
+	}
+
+	if len(vectors) <= 0 {
+		return 0, errNoVectorData
+	}
+
+	startLabel = uint64(C.getCurrentCount(idx.index))
+	labels := make([]uint64, len(vectors))
+	for i := range labels {
+		labels[i] = startLabel + uint64(i)
+	}
+
+	if idx.anyStored(labels) {
+		return 0, fmt.Errorf("%w: labels from %d are already used", ErrInvalidInput, startLabel)
+	}
+
+	if err := idx.AddPoints(vectors, labels, concurrency, false); err != nil {
+		return 0, err
+	}
+	return startLabel, nil
 }
 
-// Returns the current capacity of the index
-func (idx *HnswIndex) GetMaxElements() uint64 {
-	return uint64(C.getMaxElements(idx.index))
+// AddPointsFlat is like AddPoints but takes the vectors stored contiguously in flat, e.g. read from a binary file,
+// which saves copying them into a single buffer for C. len(flat) must be len(labels) times the index dimension.
+func (idx *HnswIndex) AddPointsFlat(flat []float32, labels []uint64, concurrency int, replaceDeleted bool) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if len(labels) <= 0 {
+		return errNoVectorData
+	}
+
+	if dim := int(idx.index.dim); len(flat) != len(labels)*dim {
+		return fmt.Errorf("%w: got %d floats, want %d vectors of %d", ErrDimMismatch, len(flat), len(labels), dim)
+	}
+
+	return idx.addFlat(flat, labels, concurrency, replaceDeleted, 0)
 }
 
-// Returns the current number of element stored in the index.
-func (idx *HnswIndex) GetCurrentCount() uint64 {
-	return uint64(C.getCurrentCount(idx.index))
+// addFlat adds the rows of flatVectors, which must hold len(labels) vectors of the index dimension.
+func (idx *HnswIndex) addFlat(flatVectors []float32, labels []uint64, concurrency int, replaceDeleted bool, progress cgo.Handle) error {
+	return idx.addFlatEf(flatVectors, labels, concurrency, replaceDeleted, progress, 0)
 }
 
-// Free resources bound to the index. Should be called when index is destroyed on close.
-// Safe to call multiple times.
-func (idx *HnswIndex) Free() {
-	if idx.index != nil {
-		C.freeHNSW(idx.index)
-		idx.index = nil
+// unmarkDeletedLabels unmarks the labels marked deleted before their vectors are updated by addFlat: hnswlib
+// updates the vector of an existing label in place, but refuses to do so for deleted elements when replacing
+// deleted elements is allowed. Labels which are not in the index are ignored.
+func (idx *HnswIndex) unmarkDeletedLabels(labels []uint64) error {
+	for _, label := range labels {
+		if isDeleted, err := idx.IsMarkedDeleted(label); err == nil && isDeleted {
+			if err := idx.UnmarkDeleted(label); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addFlatEf is like addFlat, inserting with efConstruction instead of the efConstruction of the index if it is
+// positive. hnswlib reads it from the index while inserting, so the insertion is then exclusive and the previous
+// value is restored before the lock is released.
+func (idx *HnswIndex) addFlatEf(flatVectors []float32, labels []uint64, concurrency int, replaceDeleted bool, progress cgo.Handle, efConstruction int) error {
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	if err := idx.checkReplaceDeleted(replaceDeleted); err != nil {
+		return err
+	}
+
+	if err := idx.checkInput(flatVectors); err != nil {
+		return err
+	}
+
+	var replace int = 0
+	if replaceDeleted {
+		replace = 1
+	}
+
+	rows := len(labels)
+	unlock, err := idx.lockForAdd(labels, replaceDeleted || efConstruction > 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if efConstruction > 0 {
+		previous := C.getEfConstruction(idx.index)
+		C.setEfConstruction(idx.index, C.size_t(efConstruction))
+		defer C.setEfConstruction(idx.index, previous)
+	}
+
+	threads := idx.threads(concurrency)
+	if idx.deterministic {
+		threads = 1
+	}
+
+	// a failed insertion may have added some of the points.
+	defer idx.changes.record(labels...)
+
+	//as a Go []float32 is layout-compatible with a C float[] so we can pass  Go slice directly to the C function as a pointer to its first element.
+	var errCode C.int
+	msg := cCall(func() {
+		errCode = C.addPoints(idx.index,
+			(*C.float)(unsafe.Pointer(&flatVectors[0])),
+			C.int(rows),
+			(*C.size_t)(unsafe.Pointer(&labels[0])),
+			C.int(threads),
+			C.int(replace),
+			C.uintptr_t(progress))
+	})
+
+	if int(errCode) != 0 {
+		return cError("add point failed", msg)
+	}
+
+	return nil
+}
+
+// AddPoint adds a single point. It behaves like AddPoints with a batch of one vector,
+// but avoids allocating the intermediate slices.
+func (idx *HnswIndex) AddPoint(vector []float32, label uint64, replaceDeleted bool) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	var replace int = 0
+	if replaceDeleted {
+		replace = 1
+	}
+
+	if len(vector) <= 0 {
+		return errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return err
+	}
+
+	if err := idx.checkReplaceDeleted(replaceDeleted); err != nil {
+		return err
+	}
+
+	unlock, err := idx.lockForAdd([]uint64{label}, replaceDeleted)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cLabel := C.size_t(label)
+	defer idx.changes.record(label)
+	var errCode C.int
+	msg := cCall(func() {
+		errCode = C.addPoints(idx.index,
+			(*C.float)(unsafe.Pointer(&vector[0])),
+			C.int(1),
+			&cLabel,
+			C.int(1),
+			C.int(replace),
+			C.uintptr_t(0))
+	})
+
+	if int(errCode) != 0 {
+		return cError("add point failed", msg)
+	}
+
+	return nil
+}
+
+// Normalize L2-normalizes vec in place, as is done by the index for Cosine space. A zero vector is left unchanged.
+func Normalize(vec []float32) {
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+
+	if norm == 0 {
+		return
 	}
+
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+}
+
+// checkDims makes sure every row of vectors has the dimension dim of the index, so that the flattened
+// buffer passed to C is exactly rows*dim long.
+func checkDims[T float32 | float64](vectors [][]T, dim int) error {
+	for i, vec := range vectors {
+		if len(vec) == 0 {
+			return fmt.Errorf("%w at row %d: empty vector", errNoVectorData, i)
+		}
+		if len(vec) != dim {
+			return fmt.Errorf("%w at row %d: got %d, want %d", ErrDimMismatch, i, len(vec), dim)
+		}
+	}
+
+	return nil
+}
+
+// checkCosineInput returns an error naming the first row of flat, holding rows of dim floats, which has no cosine
+// distance: a zero vector, which would be found at the same distance of every element, or one holding NaN or
+// infinite values, whose NaN distances break the ordering of results and the links of the graph.
+func checkCosineInput(flat []float32, dim int) error {
+	for row := 0; (row+1)*dim <= len(flat); row++ {
+		var norm float64
+		for _, v := range flat[row*dim : (row+1)*dim] {
+			norm += float64(v) * float64(v)
+		}
+
+		switch {
+		case norm == 0:
+			return fmt.Errorf("%w at row %d: zero vector in cosine space", ErrInvalidInput, row)
+		case math.IsNaN(norm) || math.IsInf(norm, 0):
+			return fmt.Errorf("%w at row %d: non-finite value in cosine space", ErrInvalidInput, row)
+		}
+	}
+
+	return nil
+}
+
+// checkFinite returns an error naming the first row of flat, holding rows of dim floats, with a NaN or infinite
+// value. It is a single pass with one comparison per value, so that it stays cheap next to a search.
+func checkFinite(flat []float32, dim int) error {
+	for i, v := range flat {
+		// v-v is NaN for NaN and infinite values, and 0 for the others.
+		if v-v != 0 {
+			return fmt.Errorf("%w at row %d: non-finite value %v at position %d", ErrInvalidInput, i/dim, v, i%dim)
+		}
+	}
+
+	return nil
+}
+
+// checkInput returns an error for the first row of flat which cannot be inserted in or searched with the index,
+// see checkCosineInput and SetStrictInput.
+func (idx *HnswIndex) checkInput(flat []float32) error {
+	if idx.index.space_type == C.cosine {
+		return checkCosineInput(flat, int(idx.index.dim))
+	}
+	if idx.strictInput {
+		return checkFinite(flat, int(idx.index.dim))
+	}
+	return nil
+}
+
+// CheckVector validates vec before it is passed to the index, e.g. to reject invalid user input early. An error
+// naming the expected and actual dimensions is returned if the length of vec does not match the index, and an
+// error is returned if vec contains NaN or infinite values.
+//
+// For Cosine space, an error wrapping ErrInvalidInput is returned for a zero vector, which AddPoints and the
+// searches reject, and an error wrapping ErrNotNormalized if vec is not of unit length. Non-zero vectors which are
+// not of unit length are still valid as the index normalizes them, so callers may choose to ignore this error with
+// errors.Is.
+func (idx *HnswIndex) CheckVector(vec []float32) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if len(vec) != int(idx.index.dim) {
+		return fmt.Errorf("%w: got %d, want %d", ErrDimMismatch, len(vec), int(idx.index.dim))
+	}
+
+	var norm float64
+	for i, v := range vec {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return fmt.Errorf("%w: invalid value %v at position %d", ErrInvalidInput, v, i)
+		}
+		norm += float64(v) * float64(v)
+	}
+
+	if idx.index.space_type != C.cosine {
+		return nil
+	}
+	if norm == 0 {
+		return fmt.Errorf("%w: zero vector in cosine space", ErrInvalidInput)
+	}
+	if math.Abs(math.Sqrt(norm)-1) > 1e-3 {
+		return fmt.Errorf("%w: norm is %v", ErrNotNormalized, math.Sqrt(norm))
+	}
+
+	return nil
+}
+
+// flatten2DArray concatenates the rows of vectors to prevent the "cgo argument has Go pointer to unpinned Go
+// pointer" issue. The result is empty if vectors or all of its rows are empty, so callers must check its length
+// before passing its first element to C.
+func flatten2DArray(vectors [][]float32) []float32 {
+	size := 0
+	for _, vector := range vectors {
+		size += len(vector)
+	}
+	flatVectors := make([]float32, 0, size)
+
+	for _, vector := range vectors {
+		flatVectors = append(flatVectors, vector...)
+	}
+
+	return flatVectors
+}
+
+// SearchKNN do a batch query against the index using the provided vectors. concurrency set the threads to use for searching,
+// see SetDefaultConcurrency for the meaning of 0 and negative values.
+// For each of the queried vector, at most topK SearchResults will be returned if no error occured. A row holds fewer
+// than topK results when the index has fewer than topK live elements, so rows may have different lengths.
+func (idx *HnswIndex) SearchKNN(vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	return idx.searchKNN(vectors, topK, 0, concurrency, nil)
+}
+
+// SearchKNNSimilarity is like SearchKNN for IP and Cosine spaces, but the Distance of the results is replaced
+// by a similarity score, so that a higher value means more similar:
+//
+//   - IP: the inner product dot(a, b), i.e. 1 - distance.
+//   - Cosine: the cosine similarity dot(a, b) / (|a| |b|), i.e. 1 - distance, in [-1, 1].
+//
+// Results keep being ordered from the most to the least similar. An error is returned for the other spaces,
+// whose distances have no similarity counterpart.
+func (idx *HnswIndex) SearchKNNSimilarity(vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if s := idx.SpaceType(); s != IP && s != Cosine {
+		return nil, fmt.Errorf("%w: similarity is only defined for ip and cosine spaces, not %s", ErrInvalidInput, s)
+	}
+
+	results, err := idx.SearchKNN(vectors, topK, concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range results {
+		for _, r := range row {
+			r.Distance = 1 - r.Distance
+		}
+	}
+	return results, nil
+}
+
+// SearchKNNFlat is like SearchKNN but takes the numVectors query vectors stored contiguously in flat, e.g. in a
+// memory mapped file, which saves copying the rows into a single buffer for C. len(flat) must be numVectors times
+// the index dimension.
+func (idx *HnswIndex) SearchKNNFlat(flat []float32, numVectors, topK, concurrency int) ([][]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if numVectors <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if dim := int(idx.index.dim); len(flat) != numVectors*dim {
+		return nil, fmt.Errorf("%w: got %d floats, want %d vectors of %d", ErrDimMismatch, len(flat), numVectors, dim)
+	}
+
+	cResult, err := idx.searchFlat(flat, numVectors, topK, 0, concurrency, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, numVectors, topK), nil
+}
+
+// SearchKNNWithEf is like SearchKNN but searches with at least ef candidates, so that a batch can trade latency
+// for recall without changing the ef shared by all searches. The index ef is left untouched and concurrent calls
+// with different ef values do not interfere with each other.
+//
+// hnswlib always searches with max(ef, GetEf(), topK) candidates, so ef can only raise the accuracy above the ef
+// set with SetEf. Keep the index ef low and pass a larger ef for the queries that need a higher recall.
+func (idx *HnswIndex) SearchKNNWithEf(vectors [][]float32, topK, ef, concurrency int) ([][]*SearchResult, error) {
+	if ef <= 0 {
+		return nil, fmt.Errorf("%w: ef must be positive", ErrInvalidInput)
+	}
+
+	return idx.searchKNN(vectors, topK, ef, concurrency, nil)
+}
+
+// SearchKNNContext is like SearchKNN but stops searching the remaining vectors once ctx is done, in which case
+// ctx.Err() is returned. Cancellation is checked before each vector is searched, the traversal of a vector already
+// being searched is not interrupted.
+func (idx *HnswIndex) SearchKNNContext(ctx context.Context, vectors [][]float32, topK int, concurrency int) ([][]*SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// the flag is only read by C during the call, so it can live in Go memory.
+	var cancelled int32
+	stop := context.AfterFunc(ctx, func() {
+		atomic.StoreInt32(&cancelled, 1)
+	})
+	defer stop()
+
+	results, err := idx.searchKNN(vectors, topK, 0, concurrency, &cancelled)
+	if err != nil && atomic.LoadInt32(&cancelled) != 0 {
+		return nil, ctx.Err()
+	}
+
+	return results, err
+}
+
+// SearchKNNStream is like SearchKNN but calls fn with the results of each row of vectors, in order, instead of
+// returning them all, so that the memory used by the results of a large batch does not grow with its size. The
+// rows are searched in chunks, each with up to concurrency threads, and fn is called from the calling goroutine.
+// The results passed to fn are not reused by later calls.
+//
+// The search stops at the first error returned by fn, which is returned.
+func (idx *HnswIndex) SearchKNNStream(vectors [][]float32, topK, concurrency int, fn func(rowIndex int, results []*SearchResult) error) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 {
+		return errNoVectorData
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return err
+	}
+
+	const chunkSize = 1024
+	for start := 0; start < len(vectors); start += chunkSize {
+		end := min(start+chunkSize, len(vectors))
+		results, err := idx.searchKNN(vectors[start:end], topK, 0, concurrency, nil)
+		if err != nil {
+			return err
+		}
+		for i, row := range results {
+			if err := fn(start+i, row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Query is a query vector of SearchKNNVariadic with the number of neighbors to return for it.
+type Query struct {
+	Vector []float32
+	TopK   int
+}
+
+// SearchKNNVariadic is like SearchKNN but each query has its own topK, so that a batch of queries asking for
+// different numbers of neighbors is searched in a single parallel loop, with the default concurrency, see
+// SetDefaultConcurrency. The i-th row of the result holds at most queries[i].TopK results.
+func (idx *HnswIndex) SearchKNNVariadic(queries []Query) ([][]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(queries) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	dim := int(idx.index.dim)
+	maxElements := uint64(C.getMaxElements(idx.index))
+	flatVectors := make([]float32, 0, len(queries)*dim)
+	ks := make([]C.int, len(queries))
+	maxK := 0
+	for i, q := range queries {
+		if len(q.Vector) == 0 {
+			return nil, fmt.Errorf("%w at row %d: empty vector", errNoVectorData, i)
+		}
+		if len(q.Vector) != dim {
+			return nil, fmt.Errorf("%w at row %d: got %d, want %d", ErrDimMismatch, i, len(q.Vector), dim)
+		}
+		if q.TopK <= 0 || uint64(q.TopK) > maxElements {
+			return nil, fmt.Errorf("%w at row %d: topK %d must be positive and at most maxElements", ErrInvalidInput, i, q.TopK)
+		}
+
+		flatVectors = append(flatVectors, q.Vector...)
+		ks[i] = C.int(q.TopK)
+		maxK = max(maxK, q.TopK)
+	}
+
+	if err := idx.checkInput(flatVectors); err != nil {
+		return nil, err
+	}
+
+	rows := len(queries)
+	if err := checkResultSize(rows, maxK); err != nil {
+		return nil, err
+	}
+
+	metrics := idx.snapshotMetrics()
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.searchKnnVariadic(idx.index,
+			(*C.float)(unsafe.Pointer(&flatVectors[0])),
+			C.int(rows),
+			&ks[0],
+			C.int(maxK),
+			C.int(idx.threads(0)),
+		)
+	})
+	idx.recordMetrics(metrics)
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, rows, maxK), nil
+}
+
+// searchKNN implements SearchKNN. ef is the minimum number of candidates, or 0 to use the index ef.
+// If cancel is not nil, the search is abandoned once it is set to a non-zero value.
+func (idx *HnswIndex) searchKNN(vectors [][]float32, topK int, ef int, concurrency int, cancel *int32) ([][]*SearchResult, error) {
+	cResult, err := idx.searchKNNC(vectors, topK, ef, concurrency, cancel)
+	if err != nil {
+		return nil, err
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, len(vectors), topK), nil
+}
+
+// searchKNNC validates the arguments of searchKNN and returns the C result, which must be freed by the caller.
+func (idx *HnswIndex) searchKNNC(vectors [][]float32, topK int, ef int, concurrency int, cancel *int32) (*C.SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vectors) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if err := checkDims(vectors, int(idx.index.dim)); err != nil {
+		return nil, err
+	}
+
+	return idx.searchFlat(flatten2DArray(vectors), len(vectors), topK, ef, concurrency, cancel)
+}
+
+// searchFlat searches the rows of flatVectors, which must hold rows vectors of the index dimension.
+func (idx *HnswIndex) searchFlat(flatVectors []float32, rows int, topK int, ef int, concurrency int, cancel *int32) (*C.SearchResult, error) {
+	if err := idx.checkInput(flatVectors); err != nil {
+		return nil, err
+	}
+
+	if uint64(topK) > uint64(C.getMaxElements(idx.index)) {
+		return nil, fmt.Errorf("%w: topK is larger than maxElements", ErrInvalidInput)
+	}
+
+	if err := checkResultSize(rows, topK); err != nil {
+		return nil, err
+	}
+
+	metrics := idx.snapshotMetrics()
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.searchKnn(idx.index,
+			(*C.float)(unsafe.Pointer(&flatVectors[0])),
+			C.int(rows),
+			C.int(topK),
+			C.int(ef),
+			C.int(idx.threads(concurrency)),
+			(*C.int)(unsafe.Pointer(cancel)),
+		)
+	})
+	idx.recordMetrics(metrics)
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+
+	return cResult, nil
+}
+
+// KthDistance returns the distance from vector to its k-th nearest neighbor, e.g. to derive an adaptive radius
+// for SearchRange, without allocating the results of the search. The distance is the one SearchKNN would return
+// in the k-th result, so it is approximate like the search. An error is returned if fewer than k live elements
+// are found.
+func (idx *HnswIndex) KthDistance(vector []float32, k int) (float32, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	if len(vector) <= 0 {
+		return 0, errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return 0, ErrDimMismatch
+	}
+
+	if k <= 0 {
+		return 0, fmt.Errorf("%w: k must be positive", ErrInvalidInput)
+	}
+
+	cResult, err := idx.searchFlat(vector, 1, k, 0, 1, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer C.freeResult(cResult)
+
+	counts, _, dists := resultView(cResult, 1, k)
+	if found := int(counts[0]); found < k {
+		return 0, fmt.Errorf("%w: only %d neighbors found for k %d", ErrInvalidInput, found, k)
+	}
+	return dists[k-1], nil
+}
+
+// SearchKNNValues is like SearchKNN but returns SearchResult values instead of pointers. All the results share
+// a single backing array, which avoids allocating each result separately on hot query paths.
+func (idx *HnswIndex) SearchKNNValues(vectors [][]float32, topK int, concurrency int) ([][]SearchResult, error) {
+	cResult, err := idx.searchKNNC(vectors, topK, 0, concurrency, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer C.freeResult(cResult)
+
+	return convertResultValues(cResult, len(vectors), topK), nil
+}
+
+// SearchKNNInto is like SearchKNNValues but writes the results into dst, reusing its memory, and returns the
+// updated buffer. It is meant to be called repeatedly with the buffer returned by the previous call, e.g. one
+// kept in a sync.Pool, so that hot query paths do not allocate results.
+//
+// The returned buffer has one row per queried vector, and each row has a capacity of at least topK, so rows
+// only need to be reallocated when topK grows or the batch has more vectors than dst had rows. As with append,
+// the previous content of dst is overwritten: callers must not retain rows of a buffer passed to SearchKNNInto.
+func (idx *HnswIndex) SearchKNNInto(dst [][]SearchResult, vectors [][]float32, topK int, concurrency int) ([][]SearchResult, error) {
+	cResult, err := idx.searchKNNC(vectors, topK, 0, concurrency, nil)
+	if err != nil {
+		return dst, err
+	}
+	defer C.freeResult(cResult)
+
+	rows := len(vectors)
+	counts, labels, dists := resultView(cResult, rows, topK)
+
+	if cap(dst) < rows {
+		dst = append(dst[:cap(dst)], make([][]SearchResult, rows-cap(dst))...)
+	}
+	dst = dst[:rows]
+	for rowID := range dst {
+		row := dst[rowID]
+		if cap(row) < topK {
+			row = make([]SearchResult, 0, topK)
+		}
+		row = row[:counts[rowID]]
+		for j := range row {
+			row[j] = SearchResult{Label: labels[rowID*topK+j], Distance: dists[rowID*topK+j]}
+		}
+		dst[rowID] = row
+	}
+
+	return dst, nil
+}
+
+// convertResultValues is like convertResult but copies the results into a single backing array of values.
+func convertResultValues(cResult *C.SearchResult, rows int, topK int) [][]SearchResult {
+	counts, labels, dists := resultView(cResult, rows, topK)
+
+	total := 0
+	for _, count := range counts {
+		total += int(count)
+	}
+
+	flat := make([]SearchResult, total)
+	results := make([][]SearchResult, rows)
+	offset := 0
+	for rowID := range results {
+		count := int(counts[rowID])
+		row := flat[offset : offset+count : offset+count]
+		for j := range row {
+			row[j] = SearchResult{Label: labels[rowID*topK+j], Distance: dists[rowID*topK+j]}
+		}
+		results[rowID] = row
+		offset += count
+	}
+
+	return results
+}
+
+// convertResult copies a C SearchResult of rows*topK capacity into Go SearchResults.
+// Each row only holds the number of neighbors actually found for it.
+func convertResult(cResult *C.SearchResult, rows int, topK int) [][]*SearchResult {
+	counts, labels, dists := resultView(cResult, rows, topK)
+
+	results := make([][]*SearchResult, rows) //the resulting slice
+	for rowID := range results {
+		count := int(counts[rowID])
+		rowTopk := make([]*SearchResult, count)
+		for j := 0; j < count; j++ {
+			rowTopk[j] = &SearchResult{Label: labels[rowID*topK+j], Distance: dists[rowID*topK+j]}
+		}
+		results[rowID] = rowTopk
+	}
+
+	return results
+}
+
+// resultView returns the row counts, labels and distances of a C SearchResult of rows*topK capacity as slices
+// over the C memory. It panics if the result was not allocated with that capacity or a row count is out of
+// bounds, rather than reading past the C arrays.
+func resultView(cResult *C.SearchResult, rows int, topK int) ([]C.int, []uint64, []float32) {
+	if int(cResult.rows) != rows || int(cResult.k) != topK {
+		panic(fmt.Sprintf("hnswgo: search result of %d rows of %d read as %d rows of %d", cResult.rows, cResult.k, rows, topK))
+	}
+
+	counts := unsafe.Slice((*C.int)(unsafe.Pointer(cResult.count)), rows)
+	for rowID, count := range counts {
+		if count < 0 || int(count) > topK {
+			panic(fmt.Sprintf("hnswgo: search result row %d has %d neighbors for a capacity of %d", rowID, count, topK))
+		}
+	}
+
+	labels := unsafe.Slice((*uint64)(unsafe.Pointer(cResult.label)), rows*topK)
+	dists := unsafe.Slice((*float32)(unsafe.Pointer(cResult.dist)), rows*topK)
+	return counts, labels, dists
+}
+
+// checkResultSize checks that the results of rows vectors of topK neighbors can be allocated by the C wrapper,
+// which takes them as int.
+func checkResultSize(rows int, topK int) error {
+	if rows > math.MaxInt32 || topK > math.MaxInt32 {
+		return fmt.Errorf("%w: cannot search %d vectors for %d neighbors, both must be at most %d", ErrInvalidInput, rows, topK, math.MaxInt32)
+	}
+	return nil
+}
+
+// SearchResultWithVector is a SearchResult carrying the vector stored in the index for the neighbor.
+type SearchResultWithVector struct {
+	Label    uint64
+	Distance float32
+	Vector   []float32
+}
+
+// SearchKNNWithVectors is like SearchKNNSingle but also returns the stored vectors of the neighbors, copied from C
+// in the same call, e.g. for re-ranking. The vectors are the ones returned by GetDataByLabel, so they are
+// normalized for Cosine space. All the vectors share a single backing array.
+func (idx *HnswIndex) SearchKNNWithVectors(vector []float32, topK, concurrency int) ([]*SearchResultWithVector, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vector) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return nil, ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return nil, err
+	}
+
+	if topK <= 0 {
+		return nil, fmt.Errorf("%w: topK must be positive", ErrInvalidInput)
+	}
+
+	if uint64(topK) > uint64(C.getMaxElements(idx.index)) {
+		return nil, fmt.Errorf("%w: topK is larger than maxElements", ErrInvalidInput)
+	}
+
+	if err := checkResultSize(1, topK); err != nil {
+		return nil, err
+	}
+
+	dim := int(idx.index.dim)
+	vectors := make([]float32, topK*dim)
+	metrics := idx.snapshotMetrics()
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.searchKnnWithVectors(idx.index,
+			(*C.float)(unsafe.Pointer(&vector[0])),
+			C.int(topK),
+			C.int(idx.threads(concurrency)),
+			(*C.float)(unsafe.Pointer(&vectors[0])),
+		)
+	})
+	idx.recordMetrics(metrics)
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+	defer C.freeResult(cResult)
+
+	row := convertResult(cResult, 1, topK)[0]
+	results := make([]*SearchResultWithVector, len(row))
+	for i, r := range row {
+		results[i] = &SearchResultWithVector{
+			Label:    r.Label,
+			Distance: r.Distance,
+			Vector:   vectors[i*dim : (i+1)*dim : (i+1)*dim],
+		}
+	}
+	return results, nil
+}
+
+// SearchKNNRescore queries the index with a single vector for candidateK approximate neighbors, recomputes their
+// distances to the stored vectors in double precision and returns the topK closest, ordered by ascending exact
+// distance. The rescoring is done in C with concurrency threads. The concurrency follows the rules of
+// SetDefaultConcurrency. candidateK must be at least topK; the larger it is, the more neighbors missed or
+// misordered by the approximate search are recovered.
+func (idx *HnswIndex) SearchKNNRescore(vector []float32, topK, candidateK, concurrency int) ([]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vector) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return nil, ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return nil, err
+	}
+
+	if topK <= 0 {
+		return nil, fmt.Errorf("%w: topK must be positive", ErrInvalidInput)
+	}
+
+	if candidateK < topK {
+		return nil, fmt.Errorf("%w: candidateK must be at least topK", ErrInvalidInput)
+	}
+
+	if uint64(candidateK) > uint64(C.getMaxElements(idx.index)) {
+		return nil, fmt.Errorf("%w: candidateK is larger than maxElements", ErrInvalidInput)
+	}
+
+	if err := checkResultSize(1, candidateK); err != nil {
+		return nil, err
+	}
+
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.searchKnnRescore(idx.index,
+			(*C.float)(unsafe.Pointer(&vector[0])),
+			C.int(topK),
+			C.int(candidateK),
+			C.int(idx.threads(concurrency)),
+		)
+	})
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, 1, topK)[0], nil
+}
+
+// SearchKNNDedup queries the index with a single vector for candidateK neighbors and keeps only the closest
+// neighbor of each key returned by keyFn, e.g. one result per document when several labels map to chunks of the
+// same document. At most topK results are returned, fewer if the candidates have fewer than topK distinct keys,
+// so candidateK should be large enough to hold topK keys. keyFn is called in Go on the found labels only.
+func (idx *HnswIndex) SearchKNNDedup(vector []float32, topK, candidateK int, keyFn func(label uint64) uint64) ([]*SearchResult, error) {
+	if keyFn == nil {
+		return nil, fmt.Errorf("%w: keyFn must not be nil", ErrInvalidInput)
+	}
+
+	if topK <= 0 {
+		return nil, fmt.Errorf("%w: topK must be positive", ErrInvalidInput)
+	}
+
+	if candidateK < topK {
+		return nil, fmt.Errorf("%w: candidateK must be at least topK", ErrInvalidInput)
+	}
+
+	candidates, err := idx.SearchKNNSingle(vector, candidateK, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// candidates are ordered by ascending distance, so the first result of a key is the closest.
+	seen := make(map[uint64]struct{}, topK)
+	results := make([]*SearchResult, 0, topK)
+	for _, r := range candidates {
+		key := keyFn(r.Label)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		results = append(results, r)
+		if len(results) == topK {
+			break
+		}
+	}
+	return results, nil
+}
+
+// SearchKNNSingle queries the index with a single vector and returns its topK SearchResults.
+func (idx *HnswIndex) SearchKNNSingle(vector []float32, topK int, concurrency int) ([]*SearchResult, error) {
+	results, err := idx.SearchKNN([][]float32{vector}, topK, concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0], nil
+}
+
+// SearchKNNMultiK queries the index once with a single vector for the largest of ks and returns the results for
+// each of ks, e.g. both the top 10 and top 100 neighbors for different ranking stages. The results of each k
+// are the first k results of the largest search, sharing its memory, so they may differ from what a search
+// for that k alone returns. ks must be positive and not larger than the number of elements in the index not
+// marked as deleted.
+func (idx *HnswIndex) SearchKNNMultiK(vector []float32, ks []int) (map[int][]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(ks) == 0 {
+		return nil, fmt.Errorf("%w: no k to search for", ErrInvalidInput)
+	}
+
+	count := idx.GetLiveCount()
+	maxK := 0
+	for _, k := range ks {
+		if k <= 0 {
+			return nil, fmt.Errorf("%w: invalid k %d, it must be positive", ErrInvalidInput, k)
+		}
+		if uint64(k) > count {
+			return nil, fmt.Errorf("%w: k %d is larger than the %d live elements of the index", ErrInvalidInput, k, count)
+		}
+		maxK = max(maxK, k)
+	}
+
+	results, err := idx.SearchKNNSingle(vector, maxK, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// fewer than k results are found when elements are marked deleted.
+	byK := make(map[int][]*SearchResult, len(ks))
+	for _, k := range ks {
+		n := min(k, len(results))
+		byK[k] = results[:n:n]
+	}
+	return byK, nil
+}
+
+// SearchRange queries the index with a single vector and returns all neighbors whose distance is less than or
+// equal to radius, ordered by ascending distance and capped at maxResults.
+//
+// The radius is expressed in the distance of the index space, as returned by SearchKNN: squared euclidean distance
+// for L2, and 1 - inner product for IP. For Cosine space, vectors are normalized so the distance is
+// 1 - cosine similarity, e.g. a radius of 0.2 returns the neighbors with a cosine similarity of at least 0.8.
+// As with KNN search, results are approximate and neighbors within the radius may be missed.
+func (idx *HnswIndex) SearchRange(vector []float32, radius float32, maxResults int) ([]*SearchResult, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(vector) <= 0 {
+		return nil, errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return nil, ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return nil, err
+	}
+
+	if maxResults <= 0 {
+		return nil, fmt.Errorf("%w: maxResults must be positive", ErrInvalidInput)
+	}
+
+	if uint64(maxResults) > uint64(C.getMaxElements(idx.index)) {
+		return nil, fmt.Errorf("%w: maxResults is larger than maxElements", ErrInvalidInput)
+	}
+
+	if err := checkResultSize(1, maxResults); err != nil {
+		return nil, err
+	}
+
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	var cResult *C.SearchResult
+	msg := cCall(func() {
+		cResult = C.searchRange(idx.index,
+			(*C.float)(unsafe.Pointer(&vector[0])),
+			C.float(radius),
+			C.int(maxResults),
+		)
+	})
+
+	if cResult == nil {
+		return nil, searchError(msg)
+	}
+	defer C.freeResult(cResult)
+
+	return convertResult(cResult, 1, maxResults)[0], nil
+}
+
+// Getting vector data by label. An error is returned if the label is not found or is marked as deleted.
+func (idx *HnswIndex) GetDataByLabel(label uint64) ([]float32, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if idx.index.dim <= 0 {
+		return nil, fmt.Errorf("%w: invalid index dimension", ErrInvalidInput)
+	}
+
+	var vec []float32 = make([]float32, idx.index.dim)
+	if err := idx.GetDataByLabelInto(label, vec); err != nil {
+		return nil, err
+	}
+
+	return vec, nil
+}
+
+// GetDataByLabelInto is like GetDataByLabel but copies the vector into the first Dim() floats of dst instead of
+// allocating it, e.g. to read many vectors back with a single buffer. An error is returned if dst holds fewer
+// than Dim() floats, in which case dst is left untouched, or if the label is not found or is marked as deleted.
+func (idx *HnswIndex) GetDataByLabelInto(label uint64, dst []float32) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.index.dim <= 0 {
+		return fmt.Errorf("%w: invalid index dimension", ErrInvalidInput)
+	}
+
+	if dim := int(idx.index.dim); len(dst) < dim {
+		return fmt.Errorf("%w: dst holds %d floats, want at least %d", ErrInvalidInput, len(dst), dim)
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	// pass the backing array rather than the slice header to C.
+	errCode := C.getDataByLabel(idx.index, C.size_t(label), (*C.float)(unsafe.Pointer(&dst[0])))
+	if int(errCode) != 0 {
+		return ErrLabelNotFound
+	}
+
+	return nil
+}
+
+// GetDataByLabels is the batch version of GetDataByLabel. The vectors are copied from C in a single call
+// and the returned rows share one backing array. An error naming the first label not found or marked as
+// deleted is returned, in which case no vector is returned.
+func (idx *HnswIndex) GetDataByLabels(labels []uint64) ([][]float32, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	dim := int(idx.index.dim)
+	flat := make([]float32, dim*len(labels))
+	idx.lockLabels(labels...)
+	pos := C.getDataByLabels(idx.index, (*C.size_t)(unsafe.Pointer(&labels[0])), C.int(len(labels)), (*C.float)(unsafe.Pointer(&flat[0])))
+	idx.unlockLabels()
+	if pos >= 0 {
+		return nil, fmt.Errorf("%w: label %d", ErrLabelNotFound, labels[pos])
+	}
+
+	vectors := make([][]float32, len(labels))
+	for i := range vectors {
+		vectors[i] = flat[i*dim : (i+1)*dim : (i+1)*dim]
+	}
+	return vectors, nil
+}
+
+// DistanceBetween computes the distance between two stored elements with the distance function of the index space,
+// so the result is comparable with the distances returned by SearchKNN. An error is returned if any of the labels
+// is not found or is marked as deleted.
+func (idx *HnswIndex) DistanceBetween(labelA, labelB uint64) (float32, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	idx.lockLabels(labelA, labelB)
+	defer idx.unlockLabels()
+	var dist C.float
+	errCode := C.distanceBetween(idx.index, C.size_t(labelA), C.size_t(labelB), &dist)
+	if int(errCode) != 0 {
+		return 0, ErrLabelNotFound
+	}
+
+	return float32(dist), nil
+}
+
+// DistanceToLabel computes the distance between the vector and a stored element with the distance function of
+// the index space. For cosine space the vector is normalized first, as it is when searching. An error is returned
+// if the label is not found or is marked as deleted.
+func (idx *HnswIndex) DistanceToLabel(vector []float32, label uint64) (float32, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	if len(vector) <= 0 {
+		return 0, errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return 0, ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return 0, err
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	var dist C.float
+	errCode := C.distanceToLabel(idx.index, (*C.float)(unsafe.Pointer(&vector[0])), C.size_t(label), &dist)
+	if int(errCode) != 0 {
+		return 0, ErrLabelNotFound
+	}
+
+	return float32(dist), nil
+}
+
+// Get the setting of allowReplaceDeleted.
+func (idx *HnswIndex) GetAllowReplaceDeleted() bool {
+	if idx.index == nil {
+		return false
+	}
+
+	return C.getAllowReplaceDeleted(idx.index) > 0
+}
+
+// SetAllowReplaceDeleted enables or disables the replacement of deleted elements by new points added with
+// replaceDeleted set, e.g. to reclaim deleted elements only during a compaction window. When enabled, all the
+// elements currently marked deleted can be replaced, including the ones deleted while it was disabled.
+// SetAllowReplaceDeleted must not be called concurrently with insertions or deletions, and does nothing on a
+// read-only index.
+func (idx *HnswIndex) SetAllowReplaceDeleted(allow bool) {
+	if idx.index == nil || idx.readOnly() {
+		return
+	}
+
+	var allowReplace int = 0
+	if allow {
+		allowReplace = 1
+	}
+	C.setAllowReplaceDeleted(idx.index, C.int(allowReplace))
+}
+
+// checkReplaceDeleted returns an error if replaceDeleted is set but the index does not allow it, which hnswlib
+// would only log.
+func (idx *HnswIndex) checkReplaceDeleted(replaceDeleted bool) error {
+	if replaceDeleted && !idx.GetAllowReplaceDeleted() {
+		return fmt.Errorf("%w: replaceDeleted requires an index allowing to replace deleted elements, see SetAllowReplaceDeleted", ErrInvalidInput)
+	}
+	return nil
+}
+
+// Returns the vector dimension of the index. Returns 0 if the index is closed.
+func (idx *HnswIndex) Dim() int {
+	if idx.index == nil {
+		return 0
+	}
+
+	return int(idx.index.dim)
+}
+
+// Returns the M parameter the index was built with. Returns 0 if the index is closed.
+func (idx *HnswIndex) M() int {
+	if idx.index == nil {
+		return 0
+	}
+
+	return int(C.getM(idx.index))
+}
+
+// Returns the efConstruction parameter the index was built with, or set by SetEfConstruction, which hnswlib
+// raises to at least M. Returns 0 if the index is closed.
+func (idx *HnswIndex) EfConstruction() int {
+	if idx.index == nil {
+		return 0
+	}
+
+	return int(C.getEfConstruction(idx.index))
+}
+
+// SetEfConstruction changes the efConstruction parameter used to insert new points: a larger value builds a
+// better graph at the cost of slower insertions, e.g. for a large batch, while a smaller one speeds up trickle
+// inserts. Points already in the index are not affected. As on construction, efConstruction is raised to at
+// least M. An error is returned if ef is not positive.
+//
+// The current efConstruction is the one saved by Save. SetEfConstruction must not be called concurrently with
+// insertions.
+func (idx *HnswIndex) SetEfConstruction(ef int) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if ef <= 0 {
+		return fmt.Errorf("%w: invalid efConstruction %d, must be positive", ErrInvalidInput, ef)
+	}
+
+	C.setEfConstruction(idx.index, C.size_t(ef))
+	return nil
+}
+
+// Returns the space type of the index.
+func (idx *HnswIndex) SpaceType() SpaceType {
+	if idx.index == nil {
+		return L2
+	}
+
+	return goSpaceType(idx.index.space_type)
+}
+
+// UpdatePoint replaces the vector of an existing element in place. An error is returned if the label is not found
+// or is marked as deleted, unlike AddPoint which would insert a new element.
+//
+// The connections of the element itself are always repaired for the new vector. When updateNeighborList is true,
+// the connections of its neighbors are also recomputed, which is what AddPoint does when called with an existing
+// label. Passing false is much cheaper as only the element's own neighborhood is searched, at the cost of a slightly
+// degraded graph when the vector moves a lot.
+func (idx *HnswIndex) UpdatePoint(vector []float32, label uint64, updateNeighborList bool) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	if len(vector) <= 0 {
+		return errNoVectorData
+	}
+
+	if len(vector) != int(idx.index.dim) {
+		return ErrDimMismatch
+	}
+
+	if err := idx.checkInput(vector); err != nil {
+		return err
+	}
+
+	var probability float32 = 0
+	if updateNeighborList {
+		probability = 1
+	}
+
+	idx.rw.Lock()
+	defer idx.rw.Unlock()
+	var errCode C.int
+	msg := cCall(func() {
+		errCode = C.updatePoint(idx.index, (*C.float)(unsafe.Pointer(&vector[0])), C.size_t(label), C.float(probability))
+	})
+	switch errCode {
+	case 0:
+		idx.changes.record(label)
+		return nil
+	case 1:
+		return ErrLabelNotFound
+	default:
+		return cError("update point failed", msg)
+	}
+}
+
+// ContainsLabel reports whether the label is stored in the index. Labels marked as deleted are reported as absent.
+func (idx *HnswIndex) ContainsLabel(label uint64) bool {
+	if idx.index == nil {
+		return false
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	return C.containsLabel(idx.index, C.size_t(label)) > 0
+}
+
+// InternalID returns the hnswlib internal id of the element with the given label. Internal ids are assigned in
+// insertion order and are only meant for debugging the graph. Unlike ContainsLabel, elements marked as deleted are
+// still found.
+func (idx *HnswIndex) InternalID(label uint64) (uint32, bool) {
+	if idx.index == nil {
+		return 0, false
+	}
+
+	var id C.uint
+	if C.getInternalId(idx.index, C.size_t(label), &id) == 0 {
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// LabelForInternalID returns the external label of the element with the given hnswlib internal id, the reverse of
+// InternalID. false is returned if id is not less than GetCurrentCount.
+func (idx *HnswIndex) LabelForInternalID(id uint32) (uint64, bool) {
+	if idx.index == nil {
+		return 0, false
+	}
+
+	var label C.size_t
+	if C.getLabelByInternalId(idx.index, C.uint(id), &label) == 0 {
+		return 0, false
+	}
+	return uint64(label), true
+}
+
+// MaxLevel returns the top layer of the multilayer graph, layer 0 being the base layer holding all the elements.
+// Returns -1 if the index is empty or closed.
+func (idx *HnswIndex) MaxLevel() int {
+	if idx.index == nil {
+		return -1
+	}
+
+	return int(C.getMaxLevel(idx.index))
+}
+
+// LevelOf returns the top layer of the element with the given label, which is linked in all the layers from 0 up
+// to it. Levels are drawn at insertion from an exponential distribution, so most elements are at level 0.
+// As with InternalID, elements marked as deleted are still found. An error is returned if the label is not found.
+func (idx *HnswIndex) LevelOf(label uint64) (int, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	var level C.int
+	if C.getLevel(idx.index, C.size_t(label), &level) == 0 {
+		return 0, ErrLabelNotFound
+	}
+	return int(level), nil
+}
+
+// Neighbors returns the labels of the elements linked from the element with the given label at the given level
+// of the graph, in the order of the hnswlib link list. Links are directed, so an element may not be in the
+// neighbors of its own neighbors. Deleted elements keep their links and can be neighbors. An error is returned
+// if the label is not found or if level is not between 0 and LevelOf(label).
+func (idx *HnswIndex) Neighbors(label uint64, level int) ([]uint64, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	// the base layer allows up to 2*M links, the other layers M.
+	neighbors := make([]uint64, 2*idx.M())
+	idx.lockLabels(label)
+	n := int(C.getNeighbors(idx.index, C.size_t(label), C.int(level), (*C.size_t)(unsafe.Pointer(&neighbors[0])), C.int(len(neighbors))))
+	idx.unlockLabels()
+	switch n {
+	case -1:
+		return nil, fmt.Errorf("%w: label %d", ErrLabelNotFound, label)
+	case -2:
+		return nil, fmt.Errorf("%w: level %d is out of range for label %d", ErrInvalidInput, level, label)
+	}
+
+	return neighbors[:n], nil
+}
+
+// IsMarkedDeleted reports whether the element with the given label is marked as deleted. An error is returned
+// if the label was never inserted.
+func (idx *HnswIndex) IsMarkedDeleted(label uint64) (bool, error) {
+	if idx.index == nil {
+		return false, ErrIndexClosed
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	switch C.isMarkedDeleted(idx.index, C.size_t(label)) {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, ErrLabelNotFound
+	}
+}
+
+// LabelState tells whether a label is stored in the index, and if so whether it is marked as deleted.
+type LabelState struct {
+	Exists  bool
+	Deleted bool
+}
+
+// LabelStates returns the state of each of the labels, computed in a single call to C, e.g. to reconcile the
+// index with an external store. A label was never inserted, or was replaced, if it does not exist.
+func (idx *HnswIndex) LabelStates(labels []uint64) ([]LabelState, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	cStates := make([]C.int, len(labels))
+	idx.lockLabels(labels...)
+	C.getLabelStates(idx.index, (*C.size_t)(unsafe.Pointer(&labels[0])), C.int(len(labels)), &cStates[0])
+	idx.unlockLabels()
+
+	states := make([]LabelState, len(labels))
+	for i, state := range cStates {
+		states[i] = LabelState{Exists: state >= 0, Deleted: state == 1}
+	}
+	return states, nil
+}
+
+// Marks the element as deleted, so it will be omitted from search results.
+// An error is returned if the label is not found or is already marked as deleted.
+func (idx *HnswIndex) MarkDeleted(label uint64) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	switch C.markDeleted(idx.index, C.size_t(label)) {
+	case 1:
+		return ErrLabelNotFound
+	case 2:
+		return ErrAlreadyDeleted
+	}
+
+	idx.changes.record(label)
+	return nil
+}
+
+// Unmarks the element as deleted, so it will be not be omitted from search results.
+// An error is returned if the label is not found or is not marked as deleted.
+func (idx *HnswIndex) UnmarkDeleted(label uint64) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	idx.lockLabels(label)
+	defer idx.unlockLabels()
+	switch C.unmarkDeleted(idx.index, C.size_t(label)) {
+	case 1:
+		return ErrLabelNotFound
+	case 2:
+		return ErrNotDeleted
+	}
+
+	idx.changes.record(label)
+	return nil
+}
+
+// MarkDeletedBatch marks all the labels as deleted in a single call to C. Labels already marked as deleted are skipped.
+// If any of the labels is not found, an error naming it is returned and no label is marked.
+func (idx *HnswIndex) MarkDeletedBatch(labels []uint64) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+
+	idx.lockLabels(labels...)
+	defer idx.unlockLabels()
+	pos := C.markDeletedBatch(idx.index, (*C.size_t)(unsafe.Pointer(&labels[0])), C.int(len(labels)))
+	if int(pos) >= 0 {
+		return fmt.Errorf("%w: label %d", ErrLabelNotFound, labels[pos])
+	}
+
+	idx.changes.record(labels...)
+	return nil
+}
+
+// UnmarkDeletedBatch unmarks all the labels as deleted in a single call to C. Labels not marked as deleted are skipped.
+// If any of the labels is not found, an error naming it is returned and no label is unmarked.
+func (idx *HnswIndex) UnmarkDeletedBatch(labels []uint64) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+
+	idx.lockLabels(labels...)
+	defer idx.unlockLabels()
+	pos := C.unmarkDeletedBatch(idx.index, (*C.size_t)(unsafe.Pointer(&labels[0])), C.int(len(labels)))
+	if int(pos) >= 0 {
+		return fmt.Errorf("%w: label %d", ErrLabelNotFound, labels[pos])
+	}
+
+	idx.changes.record(labels...)
+	return nil
+}
+
+// Clear removes all the elements from the index, deleted ones included, so that it behaves like a new index with
+// the same parameters. The memory preallocated for GetMaxElements elements is kept, which avoids reallocating it
+// when an index is periodically rebuilt from scratch. The query time ef is kept as well.
+func (idx *HnswIndex) Clear() error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	idx.rw.Lock()
+	defer idx.rw.Unlock()
+
+	C.clearIndex(idx.index)
+	idx.changes.clear()
+	return nil
+}
+
+// Compact rebuilds the index without the elements marked deleted, reclaiming their memory and removing their
+// tombstones from the graph. The live vectors are copied into a new graph built with the same parameters and
+// capacity, which then replaces the current one; the query time ef and other settings are kept. The levels of the
+// elements are drawn again from the seed passed to New, or from DefaultRandSeed if it is not known because the
+// index was loaded from a file, in which case Config reports DefaultRandSeed afterwards.
+//
+// Compact is an expensive maintenance operation: it inserts every live element again, and both graphs are held
+// in memory until it returns. It must not be called concurrently with any other method of the index. On error,
+// the index is left unchanged.
+func (idx *HnswIndex) Compact() error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	randSeed := idx.randSeed
+	if randSeed == 0 {
+		randSeed = DefaultRandSeed
+	}
+	compacted, err := New(idx.Dim(), idx.M(), idx.EfConstruction(), randSeed, idx.GetMaxElements(), idx.SpaceType(), idx.GetAllowReplaceDeleted())
+	if err != nil {
+		return err
+	}
+	defer compacted.Close()
+	compacted.concurrency = idx.concurrency
+	compacted.deterministic = idx.deterministic
+
+	err = idx.forEachLiveChunk(nil, func(labels []uint64, vectors [][]float32) error {
+		return compacted.addFlat(flatten2DArray(vectors), labels, 0, false, 0)
+	})
+	if err != nil {
+		return fmt.Errorf("compact failed: %w", err)
+	}
+
+	C.setEf(compacted.index, C.getEf(idx.index))
+	C.setCollectMetrics(compacted.index, idx.index.collect_metrics)
+	if n := C.getVisitedListPoolSize(idx.index); n > 1 {
+		var rc C.int
+		msg := cCall(func() { rc = C.setVisitedListPoolSize(compacted.index, n) })
+		if rc != 0 {
+			return cError("compact failed", msg)
+		}
+	}
+
+	// swap the C indexes, the previous one is freed with compacted.
+	idx.index, compacted.index = compacted.index, idx.index
+	idx.randSeed = randSeed
+	return nil
+}
+
+// Resize changes the maximum capacity of the index. An error is returned if newSize is less than the
+// number of elements in the index, including the ones marked as deleted.
+func (idx *HnswIndex) ResizeIndex(newSize uint64) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	idx.rw.Lock()
+	defer idx.rw.Unlock()
+	return idx.resize(newSize)
+}
+
+// resize implements ResizeIndex, the caller must hold the write lock.
+func (idx *HnswIndex) resize(newSize uint64) error {
+	if count := uint64(C.getCurrentCount(idx.index)); newSize < count {
+		return fmt.Errorf("%w: cannot resize index to %d, it already holds %d elements", ErrInvalidInput, newSize, count)
+	}
+
+	var errCode C.int
+	msg := cCall(func() { errCode = C.resizeIndex(idx.index, C.size_t(newSize)) })
+	if errCode != 0 {
+		return cError("resize index failed", msg)
+	}
+	return nil
+}
+
+// Returns the current capacity of the index. Returns 0 if the index is closed.
+func (idx *HnswIndex) GetMaxElements() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.getMaxElements(idx.index))
+}
+
+// Returns the current number of element stored in the index. Returns 0 if the index is closed.
+func (idx *HnswIndex) GetCurrentCount() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.getCurrentCount(idx.index))
+}
+
+// Returns the number of elements marked as deleted. Returns 0 if the index is closed.
+func (idx *HnswIndex) GetDeletedCount() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.getDeletedCount(idx.index))
+}
+
+// Returns the number of elements not marked as deleted, that is the current count minus the deleted count.
+// Returns 0 if the index is closed.
+func (idx *HnswIndex) GetLiveCount() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.getCurrentCount(idx.index)) - uint64(C.getDeletedCount(idx.index))
+}
+
+// RemainingCapacity returns the number of new elements which can be added before the index is full, that is
+// GetMaxElements minus GetCurrentCount. If replacement of deleted elements is allowed, the deleted elements are
+// counted as available too, although only points added with replaceDeleted set reuse them. Updates of existing
+// labels take no capacity, and the growth enabled by SetAutoGrow is not accounted for. Returns 0 if the index
+// is closed.
+func (idx *HnswIndex) RemainingCapacity() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	remaining := uint64(C.getMaxElements(idx.index)) - uint64(C.getCurrentCount(idx.index))
+	if C.getAllowReplaceDeleted(idx.index) > 0 {
+		remaining += uint64(C.getDeletedCount(idx.index))
+	}
+	return remaining
+}
+
+// IsFull reports whether RemainingCapacity is 0, in which case adding a new label fails unless the index is
+// resized or grows automatically. A closed index is reported full.
+func (idx *HnswIndex) IsFull() bool {
+	return idx.RemainingCapacity() == 0
+}
+
+// Verify runs sanity checks on the index, e.g. after loading it from untrusted storage: the element count is
+// within capacity, every element is found by its label, the deleted count matches the deletion marks, and the
+// link lists have valid sizes and only point to existing elements. An error describing the first inconsistency
+// found is returned. Verify visits every element and link but computes no distance, so it runs in time linear
+// in the size of the graph. It must not be called concurrently with methods modifying the index.
+//
+// Verify cannot detect every corruption, e.g. that of the vectors themselves.
+func (idx *HnswIndex) Verify() error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	msg := make([]byte, 256)
+	if C.verifyIndex(idx.index, (*C.char)(unsafe.Pointer(&msg[0])), C.size_t(len(msg))) != 0 {
+		return fmt.Errorf("index verification failed: %s", C.GoString((*C.char)(unsafe.Pointer(&msg[0]))))
+	}
+
+	return nil
+}
+
+// MemoryUsageBytes returns an estimate of the memory allocated by hnswlib for the index, in bytes. It sums the
+// level 0 storage preallocated for maxElements, the upper layer links of each element and the overhead of the
+// label map, so it differs from IndexFileSize. Allocator overhead and the visited lists created by concurrent
+// searches are not accounted for. Returns 0 if the index is closed.
+func (idx *HnswIndex) MemoryUsageBytes() uint64 {
+	if idx.index == nil {
+		return 0
+	}
+
+	return uint64(C.memoryUsage(idx.index))
+}
+
+// prewarmSearches is the number of searches run by Prewarm for each thread.
+const prewarmSearches = 4
+
+// Prewarm reads the vectors and links of all the elements using up to concurrency threads, then runs a few
+// searches for stored vectors, so that the first searches after Load or LoadReadOnly do not pay for page faults
+// and for the allocation of the visited lists of each thread. The concurrency follows the rules of
+// SetDefaultConcurrency. Calling it is optional, searches are correct without it. Prewarm runs concurrently with
+// searches and insertions, skipping the elements being inserted, and its searches are counted in SearchMetrics.
+func (idx *HnswIndex) Prewarm(concurrency int) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	idx.rw.RLock()
+	defer idx.rw.RUnlock()
+	// the elements being inserted may not be written yet, the ones below the written count stay written.
+	idx.pendingMu.RLock()
+	count := C.size_t(idx.writtenCount())
+	idx.pendingMu.RUnlock()
+
+	threads := idx.threads(concurrency)
+	var rc C.int
+	msg := cCall(func() { rc = C.prewarmIndex(idx.index, count, C.int(threads), C.int(threads*prewarmSearches)) })
+	if rc != 0 {
+		return cError("prewarm failed", msg)
+	}
+	return nil
+}
+
+// Labels returns the labels of all the elements not marked as deleted, in internal storage order.
+func (idx *HnswIndex) Labels() ([]uint64, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	labels := make([]uint64, 0, idx.GetLiveCount())
+	err := idx.ForEachLabel(func(label uint64) bool {
+		labels = append(labels, label)
+		return true
+	})
+
+	return labels, err
+}
+
+// ForEachLabel calls fn with the label of each element not marked as deleted, until fn returns false.
+// Labels are copied from C in fixed size chunks, so this can be used on large indexes without materializing
+// all the labels at once. fn must not modify the index.
+func (idx *HnswIndex) ForEachLabel(fn func(label uint64) bool) error {
+	return idx.forEachLabel(func() {}, func() {}, fn)
+}
+
+// forEachLabel implements ForEachLabel, calling lock and unlock around the copy of each chunk of labels, so that
+// wrappers such as ConcurrentIndex can hold their own lock during the copy but not while fn runs.
+func (idx *HnswIndex) forEachLabel(lock, unlock func(), fn func(label uint64) bool) error {
+	const chunkSize = 1024
+	chunk := make([]uint64, chunkSize)
+	var cursor C.size_t
+	for {
+		// the lock is not held while fn runs, which may call other methods of the index. The elements being
+		// inserted are skipped, their label may not be written yet.
+		lock()
+		if idx.index == nil {
+			unlock()
+			return ErrIndexClosed
+		}
+		idx.rw.RLock()
+		idx.pendingMu.RLock()
+		end := C.size_t(idx.writtenCount())
+		n := int(C.getLabels(idx.index, &cursor, end, (*C.size_t)(unsafe.Pointer(&chunk[0])), C.size_t(chunkSize)))
+		idx.pendingMu.RUnlock()
+		idx.rw.RUnlock()
+		unlock()
+		if n == 0 {
+			return nil
+		}
+
+		for _, label := range chunk[:n] {
+			if !fn(label) {
+				return nil
+			}
+		}
+	}
+}
+
+// forEachLiveChunk calls fn with the labels of the elements not marked as deleted which are accepted by keep, or
+// all of them if keep is nil, and with their vectors, in chunks copied by ForEachLabel and GetDataByLabels. It
+// stops at the first error returned by fn, which is returned. labels and vectors are only valid during the call.
+func (idx *HnswIndex) forEachLiveChunk(keep func(label uint64) bool, fn func(labels []uint64, vectors [][]float32) error) error {
+	const chunkSize = 1024
+	chunk := make([]uint64, 0, chunkSize)
+	flush := func() error {
+		vectors, err := idx.GetDataByLabels(chunk)
+		if err != nil {
+			return err
+		}
+		err = fn(chunk, vectors)
+		chunk = chunk[:0]
+		return err
+	}
+
+	var err error
+	iterErr := idx.ForEachLabel(func(label uint64) bool {
+		if keep != nil && !keep(label) {
+			return true
+		}
+		chunk = append(chunk, label)
+		if len(chunk) == chunkSize {
+			err = flush()
+		}
+		return err == nil
+	})
+	if iterErr != nil {
+		return iterErr
+	}
+	if err == nil && len(chunk) > 0 {
+		err = flush()
+	}
+	return err
+}
+
+// Close frees resources bound to the index. Should be called when the index is no longer used.
+// It is safe to call Close multiple times, subsequent calls return an error without touching
+// the freed memory. Any other method called on a closed index returns an error or a zero value.
+//
+// Close frees all the memory allocated by hnswlib for the index, vectors, links, label map and visited lists
+// included, so short lived indexes, e.g. one per request, can be created and closed repeatedly without growing
+// the memory of the process. The C allocator may keep small freed blocks for reuse by later allocations rather
+// than returning them to the operating system. Relying on the finalizer instead of Close delays the release
+// until the garbage collector runs, which does not account for the C memory.
+func (idx *HnswIndex) Close() error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	C.freeHNSW(idx.index)
+	idx.index = nil
+	runtime.SetFinalizer(idx, nil)
+	return nil
+}
+
+// Free resources bound to the index. Safe to call multiple times.
+//
+// Deprecated: use Close instead.
+func (idx *HnswIndex) Free() {
+	idx.Close()
 }
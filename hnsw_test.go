@@ -1,12 +1,22 @@
 package hnswgo
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const testVectorDB = "./test.db"
@@ -18,259 +28,2867 @@ const (
 	batchSize = 100
 )
 
-func newTestIndex(batch int, allowRepaceDeleted bool) *HnswIndex {
+func newTestIndex(t *testing.T, batch int, allowRepaceDeleted bool) *HnswIndex {
 	maxElements := batch * batchSize
 
-	index := New(dim, M, efConstruction, 55, uint64(maxElements), Cosine, allowRepaceDeleted)
+	index, err := New(dim, M, efConstruction, 55, uint64(maxElements), Cosine, allowRepaceDeleted)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
 
 	for i := 0; i < batch; i++ {
 		points, labels := randomPoints(dim, i*batchSize, batchSize)
 		index.AddPoints(points, labels, 1, false)
 	}
 
-	return index
-}
-
-func TestNewIndex(t *testing.T) {
-	var maxElements uint64 = batchSize * 1
+	return index
+}
+
+func TestNewIndex(t *testing.T) {
+	var maxElements uint64 = batchSize * 1
+
+	idx := newTestIndex(t, 1, true)
+	defer idx.Free()
+
+	if idx.GetMaxElements() != maxElements {
+		t.Fail()
+	}
+
+	if idx.GetAllowReplaceDeleted() != true {
+		t.Fail()
+	}
+
+	if idx.GetCurrentCount() != maxElements {
+		t.Fail()
+	}
+
+}
+
+func TestLoadAndSaveIndex(t *testing.T) {
+	var maxElements uint64 = batchSize * 1
+
+	// setup
+	idx := newTestIndex(t, 1, true)
+	idx.Save(testVectorDB)
+	idx.Free()
+
+	index, err := Load(testVectorDB, Cosine, dim, uint64(maxElements), true)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	index.SetEf(efConstruction)
+	defer index.Free()
+
+	index.Save(testVectorDB)
+	t.Cleanup(func() {
+		deleteDB()
+	})
+}
+
+func TestIndexParameters(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	idx.Save(testVectorDB)
+	idx.Close()
+	t.Cleanup(func() {
+		deleteDB()
+	})
+
+	index, err := Load(testVectorDB, Cosine, dim, batchSize, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer index.Close()
+
+	if index.Dim() != dim {
+		t.Errorf("expected dim %d, got %d", dim, index.Dim())
+	}
+
+	if index.M() != M {
+		t.Errorf("expected M %d, got %d", M, index.M())
+	}
+
+	// hnswlib raises efConstruction to at least M.
+	if index.EfConstruction() != max(efConstruction, M) {
+		t.Errorf("expected efConstruction %d, got %d", max(efConstruction, M), index.EfConstruction())
+	}
+
+	if index.SpaceType() != Cosine {
+		t.Errorf("expected space type %d, got %d", Cosine, index.SpaceType())
+	}
+}
+
+func TestGetEf(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	if idx.GetEf() != 10 {
+		t.Errorf("expected default ef 10, got %d", idx.GetEf())
+	}
+
+	idx.SetEf(50)
+	if idx.GetEf() != 50 {
+		t.Errorf("expected ef 50, got %d", idx.GetEf())
+	}
+
+	for _, ef := range []int{0, -1} {
+		if err := idx.SetEf(ef); err == nil {
+			t.Errorf("expected error for ef %d", ef)
+		}
+	}
+	if idx.GetEf() != 50 {
+		t.Errorf("expected ef to stay 50 after invalid values, got %d", idx.GetEf())
+	}
+
+	// ef smaller than topK is raised to topK by hnswlib.
+	idx.SetEf(1)
+	results, err := idx.SearchKNN([][]float32{randomPoint(dim)}, 20, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	if len(results[0]) != 20 {
+		t.Errorf("expected 20 results with ef < topK, got %d", len(results[0]))
+	}
+}
+
+func TestSetEfConstruction(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	if err := idx.SetEfConstruction(100); err != nil {
+		t.Fatalf("SetEfConstruction failed: %v", err)
+	}
+	if idx.EfConstruction() != 100 {
+		t.Errorf("expected efConstruction 100, got %d", idx.EfConstruction())
+	}
+
+	// raised to M as on construction.
+	idx.SetEfConstruction(1)
+	if idx.EfConstruction() != M {
+		t.Errorf("expected efConstruction to be raised to M, got %d", idx.EfConstruction())
+	}
+
+	for _, ef := range []int{0, -1} {
+		if err := idx.SetEfConstruction(ef); err == nil {
+			t.Errorf("expected error for efConstruction %d", ef)
+		}
+	}
+
+	idx.ResizeIndex(2 * batchSize)
+	points, labels := randomPoints(dim, batchSize, batchSize)
+	if err := idx.AddPoints(points, labels, 1, false); err != nil {
+		t.Errorf("AddPoints failed after SetEfConstruction: %v", err)
+	}
+}
+
+func TestCloseIndex(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+
+	if err := idx.Close(); err != nil {
+		t.Fatalf("first Close failed: %v", err)
+	}
+
+	if err := idx.Close(); err == nil {
+		t.Error("expected error on second Close")
+	}
+
+	// also make sure the deprecated Free is still safe.
+	idx.Free()
+
+	if err := idx.AddPoints([][]float32{randomPoint(dim)}, []uint64{1}, 1, false); err == nil {
+		t.Error("expected error when adding points to a closed index")
+	}
+
+	if _, err := idx.SearchKNN([][]float32{randomPoint(dim)}, 1, 1); err == nil {
+		t.Error("expected error when searching a closed index")
+	}
+
+	if idx.GetCurrentCount() != 0 || idx.GetMaxElements() != 0 {
+		t.Error("expected zero values from a closed index")
+	}
+}
+
+func TestFinalizer(t *testing.T) {
+	func() {
+		// leaked on purpose, freed by the finalizer.
+		newTestIndex(t, 1, false)
+		// closed explicitly, the finalizer must not free it again.
+		idx := newTestIndex(t, 1, false)
+		idx.Close()
+	}()
+
+	runtime.GC()
+	runtime.GC()
+}
+
+func TestSaveAtomic(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "index.bin")
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	if err := index.Save(location); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, path := range []string{location + ".tmp", metadataPath(location) + ".tmp"} {
+		if pathExists(path) {
+			t.Errorf("temporary file %s was left behind", path)
+		}
+	}
+
+	stat, _ := os.Stat(location)
+	if uint64(stat.Size()) != index.IndexFileSize() {
+		t.Errorf("expected a file of %d bytes, got %d", index.IndexFileSize(), stat.Size())
+	}
+
+	if err := index.Save(filepath.Join(location, "missing", "index.bin")); err == nil {
+		t.Error("expected error when saving to a missing directory")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	index.MarkDeleted(4)
+
+	if err := index.Verify(); err != nil {
+		t.Fatalf("expected a valid index, got %v", err)
+	}
+
+	empty, _ := New(dim, M, efConstruction, 55, 10, L2, false)
+	defer empty.Close()
+	if err := empty.Verify(); err != nil {
+		t.Errorf("expected a valid empty index, got %v", err)
+	}
+
+	t.Run("corrupted file", func(t *testing.T) {
+		location := filepath.Join(t.TempDir(), "index.bin")
+		index.Save(location)
+
+		data, _ := os.ReadFile(location)
+		// the level 0 data of the first element follows the 96 bytes header of the hnswlib format, starting with
+		// the link count; overwrite its first link.
+		copy(data[100:104], []byte{0xff, 0xff, 0xff, 0x7f})
+		os.WriteFile(location, data, 0644)
+
+		loaded, err := Load(location, Cosine, dim, batchSize, false)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		defer loaded.Close()
+
+		err = loaded.Verify()
+		if err == nil || !strings.Contains(err.Error(), "unknown element") {
+			t.Errorf("expected a corrupted link to be reported, got %v", err)
+		}
+	})
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	index, err := Load("./not-exist.db", Cosine, dim, batchSize, false)
+	if err == nil {
+		index.Close()
+		t.Fatal("expected error when loading a non-existent index file")
+	}
+}
+
+func TestLoadDimMismatch(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "index.bin")
+	index := newTestIndex(t, 1, false)
+	if err := index.Save(location); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	index.Close()
+
+	for _, d := range []int{dim / 2, dim + 1} {
+		if loaded, err := Load(location, Cosine, d, batchSize, false); !errors.Is(err, ErrDimMismatch) {
+			if err == nil {
+				loaded.Close()
+			}
+			t.Errorf("dimension %d: expected ErrDimMismatch, got %v", d, err)
+		}
+	}
+	if _, err := LoadReadOnly(location, Cosine, dim/2); !errors.Is(err, ErrDimMismatch) {
+		t.Errorf("expected ErrDimMismatch from LoadReadOnly, got %v", err)
+	}
+
+	loaded, err := Load(location, Cosine, dim, batchSize, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	loaded.Close()
+
+	truncated := filepath.Join(t.TempDir(), "truncated.bin")
+	os.WriteFile(truncated, make([]byte, 20), 0o644)
+	if _, err := Load(truncated, Cosine, dim, batchSize, false); err == nil || !strings.Contains(err.Error(), "truncated header") {
+		t.Errorf("expected a truncated header error, got %v", err)
+	}
+}
+
+// residentMemory returns the resident set size of the process in bytes, read from /proc/self/statm.
+func residentMemory(t *testing.T) uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		t.Skipf("resident memory not available: %v", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		t.Skipf("unexpected statm content %q", data)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		t.Skipf("unexpected statm content %q", data)
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+func TestCloseReleasesMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping memory test in short mode")
+	}
+
+	location := filepath.Join(t.TempDir(), "index.bin")
+	points, labels := randomPoints(dim, 0, 5*batchSize)
+	query := genQuery(dim, 10)
+	lifecycle := func() {
+		index, err := New(dim, M, efConstruction, 55, 5*batchSize, Cosine, true)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		index.SetVisitedListPoolSize(4)
+		if err := index.AddPoints(points, labels, 4, false); err != nil {
+			t.Fatalf("AddPoints failed: %v", err)
+		}
+		if err := index.MarkDeletedBatch(labels[:batchSize]); err != nil {
+			t.Fatalf("MarkDeletedBatch failed: %v", err)
+		}
+		if err := index.AddPoints(points[:batchSize], labels[:batchSize], 4, true); err != nil {
+			t.Fatalf("AddPoints replacing deleted failed: %v", err)
+		}
+		if _, err := index.SearchKNN(query, 10, 4); err != nil {
+			t.Fatalf("SearchKNN failed: %v", err)
+		}
+		if err := index.ResizeIndex(6 * batchSize); err != nil {
+			t.Fatalf("ResizeIndex failed: %v", err)
+		}
+		if err := index.Save(location); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		if err := index.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		loaded, err := LoadReadOnly(location, Cosine, dim)
+		if err != nil {
+			t.Fatalf("LoadReadOnly failed: %v", err)
+		}
+		if _, err := loaded.SearchKNN(query, 10, 4); err != nil {
+			t.Fatalf("SearchKNN of the loaded index failed: %v", err)
+		}
+		if err := loaded.Close(); err != nil {
+			t.Fatalf("Close of the loaded index failed: %v", err)
+		}
+
+		bf, err := NewBruteForce(dim, 5*batchSize, Cosine)
+		if err != nil {
+			t.Fatalf("NewBruteForce failed: %v", err)
+		}
+		if err := bf.AddPoints(points, labels); err != nil {
+			t.Fatalf("BruteForceIndex.AddPoints failed: %v", err)
+		}
+		if _, err := bf.SearchKNN(query, 10, 4); err != nil {
+			t.Fatalf("BruteForceIndex.SearchKNN failed: %v", err)
+		}
+		if err := bf.Close(); err != nil {
+			t.Fatalf("BruteForceIndex.Close failed: %v", err)
+		}
+	}
+
+	measure := func() uint64 {
+		runtime.GC()
+		debug.FreeOSMemory()
+		return residentMemory(t)
+	}
+
+	// let the allocators reach their steady state first.
+	for i := 0; i < 5; i++ {
+		lifecycle()
+	}
+	before := measure()
+	const cycles = 100
+	for i := 0; i < cycles; i++ {
+		lifecycle()
+	}
+	after := measure()
+
+	// each cycle allocates about 0.8 MB of vectors per index plus their links and visited lists. The bound allows
+	// for the slack of the allocators while catching a leak of a tenth of that per cycle.
+	const maxGrowthPerCycle = 80 << 10
+	if after > before && after-before > cycles*maxGrowthPerCycle {
+		t.Errorf("resident memory grew by %d KB over %d cycles", (after-before)>>10, cycles)
+	}
+}
+
+func TestResizeIndex(t *testing.T) {
+	var maxElements uint64 = batchSize * 1
+
+	idx := newTestIndex(t, 1, false)
+	defer idx.Free()
+
+	if idx.GetMaxElements() != maxElements {
+		t.Fail()
+	}
+
+	if idx.GetCurrentCount() != maxElements {
+		t.Fail()
+	}
+
+	if idx.GetAllowReplaceDeleted() != false {
+		t.Fail()
+	}
+
+	points, labels := randomPoints(dim, 1*batchSize, batchSize)
+	err := idx.AddPoints(points, labels, 1, false)
+	if err == nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if err := idx.ResizeIndex(maxElements - 1); err == nil {
+		t.Error("expected error when shrinking below the element count")
+	}
+	if idx.GetMaxElements() != maxElements {
+		t.Errorf("expected capacity %d after a failed resize, got %d", maxElements, idx.GetMaxElements())
+	}
+
+	if err := idx.ResizeIndex(maxElements * 2); err != nil {
+		t.Fatalf("ResizeIndex failed: %v", err)
+	}
+	if idx.GetMaxElements() != maxElements*2 {
+		t.Fail()
+	}
+
+	if idx.GetCurrentCount() != maxElements {
+		t.Fail()
+	}
+
+	err = idx.AddPoints(points, labels, 1, false)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestDefaultConcurrency(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	if got := index.threads(0); got != 1 {
+		t.Errorf("expected 1 thread before setting a default, got %d", got)
+	}
+	if got := index.threads(-1); got != runtime.GOMAXPROCS(0) {
+		t.Errorf("expected GOMAXPROCS threads for a negative concurrency, got %d", got)
+	}
+
+	index.SetDefaultConcurrency(3)
+	if got := index.threads(0); got != 3 {
+		t.Errorf("expected the default of 3 threads, got %d", got)
+	}
+	if got := index.threads(2); got != 2 {
+		t.Errorf("expected an explicit concurrency to win over the default, got %d", got)
+	}
+
+	index.SetDefaultConcurrency(-1)
+	if got := index.threads(0); got != runtime.GOMAXPROCS(0) {
+		t.Errorf("expected GOMAXPROCS threads for a negative default, got %d", got)
+	}
+
+	// cosine space uses a per thread buffer which hnswlib would overflow with 0 threads.
+	points, labels := randomPoints(dim, 0, batchSize)
+	if err := index.AddPoints(points, labels, 0, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+	results, err := index.SearchKNN(points, 1, 0)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	if len(results) != batchSize {
+		t.Errorf("expected %d rows, got %d", batchSize, len(results))
+	}
+}
+
+func TestAutoGrow(t *testing.T) {
+	newIndex := func() *HnswIndex {
+		index, err := New(dim, M, efConstruction, 55, 10, L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		return index
+	}
+	points, labels := randomPoints(dim, 0, 30)
+
+	full := newIndex()
+	defer full.Close()
+	for _, factor := range []float64{-1, 0.5, 1} {
+		if err := full.SetAutoGrow(factor); err == nil {
+			t.Errorf("expected error for grow factor %v", factor)
+		}
+	}
+	if err := full.AddPoints(points[:11], labels[:11], 1, false); err == nil {
+		t.Error("expected error adding to a full index without auto growth")
+	}
+
+	index := newIndex()
+	defer index.Close()
+	if err := index.SetAutoGrow(DefaultGrowFactor); err != nil {
+		t.Fatalf("SetAutoGrow failed: %v", err)
+	}
+
+	steps := []struct {
+		from, to    int
+		maxElements uint64
+	}{
+		{0, 10, 10},  // fits without growing
+		{10, 15, 15}, // grown by the factor
+		{15, 25, 25}, // the factor is not enough
+	}
+	for _, step := range steps {
+		if err := index.AddPoints(points[step.from:step.to], labels[step.from:step.to], 1, false); err != nil {
+			t.Fatalf("AddPoints failed: %v", err)
+		}
+		if index.GetMaxElements() != step.maxElements || index.GetCurrentCount() != uint64(step.to) {
+			t.Errorf("expected capacity %d and count %d, got %d and %d",
+				step.maxElements, step.to, index.GetMaxElements(), index.GetCurrentCount())
+		}
+	}
+
+	if err := index.AddPoint(points[25], labels[25], false); err != nil {
+		t.Fatalf("AddPoint failed: %v", err)
+	}
+	if index.GetMaxElements() != 38 {
+		t.Errorf("expected capacity 38, got %d", index.GetMaxElements())
+	}
+}
+
+func TestClear(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	index.SetEf(50)
+	index.MarkDeleted(5)
+
+	if err := index.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if index.GetCurrentCount() != 0 || index.GetDeletedCount() != 0 {
+		t.Errorf("expected an empty index, got %d elements and %d deleted", index.GetCurrentCount(), index.GetDeletedCount())
+	}
+	if index.GetMaxElements() != batchSize || index.GetEf() != 50 {
+		t.Errorf("expected capacity and ef to be kept, got %d and %d", index.GetMaxElements(), index.GetEf())
+	}
+	if index.ContainsLabel(0) {
+		t.Error("expected labels to be removed")
+	}
+	results, err := index.SearchKNN([][]float32{randomPoint(dim)}, 5, 1)
+	if err != nil || len(results[0]) != 0 {
+		t.Errorf("expected no results from an empty index, got %v, %v", results, err)
+	}
+
+	// the index can be filled again up to its capacity.
+	points, labels := randomPoints(dim, 1000, batchSize)
+	if err := index.AddPoints(points, labels, 2, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+	if index.GetCurrentCount() != batchSize || !index.ContainsLabel(1005) {
+		t.Errorf("expected %d elements after refilling, got %d", batchSize, index.GetCurrentCount())
+	}
+	result, _ := index.SearchKNNSingle(points[7], 1, 1)
+	if len(result) != 1 || result[0].Label != labels[7] {
+		t.Errorf("expected label %d, got %v", labels[7], result)
+	}
+
+	index.Close()
+	if err := index.Clear(); err == nil {
+		t.Error("expected error clearing a closed index")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, 2000, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, 1500)
+	index.AddPoints(points, labels, 1, false)
+	deleted := labels[:500]
+	index.MarkDeletedBatch(deleted)
+	index.SetEf(40)
+
+	if err := index.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+
+	if index.GetCurrentCount() != 1000 || index.GetDeletedCount() != 0 {
+		t.Errorf("expected 1000 elements and no deleted one, got %d and %d", index.GetCurrentCount(), index.GetDeletedCount())
+	}
+	if index.GetMaxElements() != 2000 || index.GetEf() != 40 {
+		t.Errorf("expected capacity and ef to be kept, got %d and %d", index.GetMaxElements(), index.GetEf())
+	}
+	if index.ContainsLabel(deleted[0]) {
+		t.Error("deleted label is still stored")
+	}
+	if v, _ := index.GetDataByLabel(labels[1200]); !slices.Equal(v, points[1200]) {
+		t.Error("live vector was not kept")
+	}
+	if err := index.Verify(); err != nil {
+		t.Errorf("compacted index is inconsistent: %v", err)
+	}
+
+	results, _ := index.SearchKNN([][]float32{points[800]}, 1, 1)
+	if results[0][0].Label != labels[800] {
+		t.Errorf("expected label %d to be found, got %d", labels[800], results[0][0].Label)
+	}
+}
+
+func TestAddPoint(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := index.AddPoint(randomPoint(dim), uint64(i), false); err != nil {
+			t.Fatalf("AddPoint failed: %v", err)
+		}
+	}
+
+	if index.GetCurrentCount() != 10 {
+		t.Errorf("expected 10 elements, got %d", index.GetCurrentCount())
+	}
+
+	if err := index.AddPoint(randomPoint(dim-1), 100, false); err == nil {
+		t.Error("expected error for unmatched dimension")
+	}
+}
+
+func TestAddPointsFlat(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, batchSize, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	if err := index.AddPointsFlat(flatten2DArray(points), labels, 2, false); err != nil {
+		t.Fatalf("AddPointsFlat failed: %v", err)
+	}
+	if index.GetCurrentCount() != batchSize {
+		t.Errorf("expected %d elements, got %d", batchSize, index.GetCurrentCount())
+	}
+	if v, _ := index.GetDataByLabel(labels[42]); !slices.Equal(v, points[42]) {
+		t.Error("stored vector does not match")
+	}
+
+	flat := flatten2DArray(points[:2])
+	for _, n := range []int{0, 1, 3} {
+		if err := index.AddPointsFlat(flat, labels[:n], 1, false); err == nil {
+			t.Errorf("expected error for %d labels with a buffer of 2 vectors", n)
+		}
+	}
+}
+
+func TestRaggedVectors(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	points, labels := randomPoints(dim, batchSize, 10)
+	points[5] = points[5][:dim-1]
+
+	err := index.AddPoints(points, labels, 1, false)
+	if err == nil || !strings.Contains(err.Error(), "row 5") {
+		t.Errorf("expected error naming row 5, got %v", err)
+	}
+	if index.GetCurrentCount() != batchSize {
+		t.Errorf("expected no points added, got %d", index.GetCurrentCount()-batchSize)
+	}
+
+	_, err = index.SearchKNN(points, 5, 1)
+	if err == nil || !strings.Contains(err.Error(), "row 5") {
+		t.Errorf("expected error naming row 5, got %v", err)
+	}
+}
+
+func TestUpdatePoint(t *testing.T) {
+	for _, updateNeighbors := range []bool{false, true} {
+		index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		points, labels := randomPoints(dim, 0, batchSize)
+		index.AddPoints(points, labels, 1, false)
+
+		vec := randomPoint(dim)
+		if err := index.UpdatePoint(vec, 10, updateNeighbors); err != nil {
+			t.Fatalf("UpdatePoint failed: %v", err)
+		}
+
+		stored, _ := index.GetDataByLabel(10)
+		if !slices.Equal(stored, vec) {
+			t.Error("stored vector was not updated")
+		}
+		if index.GetCurrentCount() != batchSize {
+			t.Errorf("expected the element count to stay %d, got %d", batchSize, index.GetCurrentCount())
+		}
+
+		result, _ := index.SearchKNNSingle(vec, 1, 1)
+		if len(result) != 1 || result[0].Label != 10 {
+			t.Errorf("expected the updated point to be found, got %v", result)
+		}
+
+		if err := index.UpdatePoint(vec, 12345, updateNeighbors); err == nil {
+			t.Error("expected error updating a missing label")
+		}
+		index.MarkDeleted(11)
+		if err := index.UpdatePoint(vec, 11, updateNeighbors); err == nil {
+			t.Error("expected error updating a deleted label")
+		}
+		if err := index.UpdatePoint(vec[1:], 12, updateNeighbors); err == nil {
+			t.Error("expected error for unmatched dimension")
+		}
+
+		index.Close()
+	}
+}
+
+func TestEmptyVectors(t *testing.T) {
+	if flat := flatten2DArray(nil); len(flat) != 0 {
+		t.Errorf("expected an empty result for no rows, got %v", flat)
+	}
+	if flat := flatten2DArray([][]float32{{}, {}}); len(flat) != 0 {
+		t.Errorf("expected an empty result for empty rows, got %v", flat)
+	}
+
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	cases := map[string][][]float32{
+		"empty outer":   {},
+		"empty inner":   {{}},
+		"one empty row": {randomPoint(dim), {}},
+	}
+	for name, vectors := range cases {
+		t.Run(name, func(t *testing.T) {
+			labels := make([]uint64, len(vectors))
+			if err := index.AddPoints(vectors, labels, 1, false); err == nil {
+				t.Error("expected AddPoints error")
+			}
+			if _, err := index.SearchKNN(vectors, 1, 1); err == nil {
+				t.Error("expected SearchKNN error")
+			}
+		})
+	}
+
+	if err := index.UpdatePoint(nil, 0, false); err == nil {
+		t.Error("expected UpdatePoint error for an empty vector")
+	}
+}
+
+func TestContainsLabel(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	if !idx.ContainsLabel(0) {
+		t.Error("expected label 0 to be present")
+	}
+
+	if idx.ContainsLabel(batchSize + 1) {
+		t.Error("expected never inserted label to be absent")
+	}
+
+	idx.MarkDeleted(0)
+	if idx.ContainsLabel(0) {
+		t.Error("expected deleted label to be absent")
+	}
+}
+
+func TestLabelStates(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	index.MarkDeleted(4)
+
+	states, err := index.LabelStates([]uint64{3, 4, batchSize, 3})
+	if err != nil {
+		t.Fatalf("LabelStates failed: %v", err)
+	}
+	expected := []LabelState{{Exists: true}, {Exists: true, Deleted: true}, {}, {Exists: true}}
+	if !slices.Equal(states, expected) {
+		t.Errorf("expected %v, got %v", expected, states)
+	}
+
+	if states, err := index.LabelStates(nil); err != nil || len(states) != 0 {
+		t.Errorf("expected no state for no label, got %v and %v", states, err)
+	}
+}
+
+func TestInternalID(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	// labels are inserted in reverse order so they differ from the internal ids.
+	for i := 0; i < 10; i++ {
+		index.AddPoint(randomPoint(dim), uint64(100-i), false)
+	}
+	index.MarkDeleted(95)
+
+	for i := 0; i < 10; i++ {
+		label := uint64(100 - i)
+		id, ok := index.InternalID(label)
+		if !ok || id != uint32(i) {
+			t.Errorf("label %d: expected internal id %d, got %d, %v", label, i, id, ok)
+		}
+
+		got, ok := index.LabelForInternalID(uint32(i))
+		if !ok || got != label {
+			t.Errorf("internal id %d: expected label %d, got %d, %v", i, label, got, ok)
+		}
+	}
+
+	if _, ok := index.InternalID(1); ok {
+		t.Error("expected unknown label not to be found")
+	}
+	if _, ok := index.LabelForInternalID(10); ok {
+		t.Error("expected out of range internal id not to be found")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	empty, _ := New(dim, M, efConstruction, 55, 10, L2, false)
+	defer empty.Close()
+	if empty.MaxLevel() != -1 {
+		t.Errorf("expected level -1 for an empty index, got %d", empty.MaxLevel())
+	}
+
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	maxLevel := index.MaxLevel()
+	if maxLevel < 0 {
+		t.Fatalf("expected a non-negative max level, got %d", maxLevel)
+	}
+
+	top := 0
+	for label := uint64(0); label < batchSize; label++ {
+		level, err := index.LevelOf(label)
+		if err != nil {
+			t.Fatalf("LevelOf(%d) failed: %v", label, err)
+		}
+		if level < 0 || level > maxLevel {
+			t.Errorf("label %d has level %d outside [0, %d]", label, level, maxLevel)
+		}
+		top = max(top, level)
+	}
+	if top != maxLevel {
+		t.Errorf("expected an element at the max level %d, the highest is at %d", maxLevel, top)
+	}
+
+	if _, err := index.LevelOf(batchSize); err == nil {
+		t.Error("expected error for a missing label")
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	for label := uint64(0); label < batchSize; label++ {
+		level, _ := index.LevelOf(label)
+		for l := 0; l <= level; l++ {
+			neighbors, err := index.Neighbors(label, l)
+			if err != nil {
+				t.Fatalf("Neighbors(%d, %d) failed: %v", label, l, err)
+			}
+			if l == 0 && len(neighbors) == 0 {
+				t.Errorf("label %d has no neighbor in the base layer", label)
+			}
+			for _, n := range neighbors {
+				if n == label || n >= batchSize {
+					t.Errorf("label %d has invalid neighbor %d at level %d", label, n, l)
+				}
+				if nl, _ := index.LevelOf(n); nl < l {
+					t.Errorf("neighbor %d of label %d at level %d is only at level %d", n, label, l, nl)
+				}
+			}
+		}
+	}
+
+	level, _ := index.LevelOf(3)
+	for _, l := range []int{-1, level + 1} {
+		if _, err := index.Neighbors(3, l); err == nil {
+			t.Errorf("expected error for level %d", l)
+		}
+	}
+	if _, err := index.Neighbors(batchSize, 0); err == nil {
+		t.Error("expected error for a missing label")
+	}
+}
+
+func TestDistanceBetween(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	a, b := randomPoint(dim), randomPoint(dim)
+	index.AddPoints([][]float32{a, b}, []uint64{1, 2}, 1, false)
+
+	dist, err := index.DistanceBetween(1, 2)
+	if err != nil {
+		t.Fatalf("DistanceBetween failed: %v", err)
+	}
+
+	// hnswlib L2 space uses squared euclidean distance.
+	var expected float32
+	for i := range a {
+		expected += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	if math.Abs(float64(dist-expected)) > 1e-3 {
+		t.Errorf("expected distance %f, got %f", expected, dist)
+	}
+
+	if _, err := index.DistanceBetween(1, 3); err == nil {
+		t.Error("expected error for missing label")
+	}
+}
+
+func TestDistanceToLabel(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	vec, err := index.GetDataByLabel(5)
+	if err != nil {
+		t.Fatalf("GetDataByLabel failed: %v", err)
+	}
+
+	dist, err := index.DistanceToLabel(vec, 5)
+	if err != nil {
+		t.Fatalf("DistanceToLabel failed: %v", err)
+	}
+
+	if math.Abs(float64(dist)) > 1e-4 {
+		t.Errorf("expected zero distance to itself, got %f", dist)
+	}
+
+	if _, err := index.DistanceToLabel(vec, batchSize+1); err == nil {
+		t.Error("expected error for missing label")
+	}
+
+	if _, err := index.DistanceToLabel(vec[1:], 5); err == nil {
+		t.Error("expected error for unmatched dimension")
+	}
+}
+
+func TestMarkDeleted(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	if err := idx.MarkDeleted(batchSize + 1); err == nil {
+		t.Error("expected error when marking a missing label")
+	}
+
+	if err := idx.UnmarkDeleted(batchSize + 1); err == nil {
+		t.Error("expected error when unmarking a missing label")
+	}
+
+	if err := idx.UnmarkDeleted(1); err == nil {
+		t.Error("expected error when unmarking a label not deleted")
+	}
+
+	if err := idx.MarkDeleted(1); err != nil {
+		t.Fatalf("MarkDeleted failed: %v", err)
+	}
+
+	if err := idx.MarkDeleted(1); err == nil {
+		t.Error("expected error when marking a label twice")
+	}
+
+	if err := idx.UnmarkDeleted(1); err != nil {
+		t.Fatalf("UnmarkDeleted failed: %v", err)
+	}
+}
+
+func TestIsMarkedDeleted(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	if _, err := idx.IsMarkedDeleted(batchSize + 1); err == nil {
+		t.Error("expected error for a missing label")
+	}
+
+	if deleted, err := idx.IsMarkedDeleted(1); err != nil || deleted {
+		t.Errorf("expected label 1 not to be deleted, got %v, %v", deleted, err)
+	}
+
+	idx.MarkDeleted(1)
+	if deleted, err := idx.IsMarkedDeleted(1); err != nil || !deleted {
+		t.Errorf("expected label 1 to be deleted, got %v, %v", deleted, err)
+	}
+
+	idx.UnmarkDeleted(1)
+	if deleted, _ := idx.IsMarkedDeleted(1); deleted {
+		t.Error("expected label 1 not to be deleted after unmarking")
+	}
+}
+
+func TestDeletedAndLiveCount(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	if idx.GetDeletedCount() != 0 || idx.GetLiveCount() != batchSize {
+		t.Fatalf("unexpected counts: deleted %d, live %d", idx.GetDeletedCount(), idx.GetLiveCount())
+	}
+
+	idx.MarkDeletedBatch([]uint64{1, 2, 3})
+
+	if idx.GetDeletedCount() != 3 {
+		t.Errorf("expected 3 deleted elements, got %d", idx.GetDeletedCount())
+	}
+
+	if idx.GetLiveCount() != batchSize-3 {
+		t.Errorf("expected %d live elements, got %d", batchSize-3, idx.GetLiveCount())
+	}
+
+	if idx.GetCurrentCount() != batchSize {
+		t.Errorf("expected current count to include deleted elements, got %d", idx.GetCurrentCount())
+	}
+}
+
+func TestMemoryUsageBytes(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize*2), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	empty := index.MemoryUsageBytes()
+	// the vectors alone are preallocated for maxElements.
+	if empty < uint64(batchSize*2*dim*4) {
+		t.Errorf("expected at least the vector storage, got %d bytes", empty)
+	}
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+	if index.MemoryUsageBytes() <= empty {
+		t.Errorf("expected memory usage to grow after insertion, got %d <= %d", index.MemoryUsageBytes(), empty)
+	}
+
+	index.Close()
+	if index.MemoryUsageBytes() != 0 {
+		t.Error("expected 0 for a closed index")
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "index.bin")
+	index := newTestIndex(t, 3, false)
+	query := genQuery(dim, 5)
+	expected, _ := index.SearchKNN(query, 10, 1)
+	if err := index.Save(location); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	index.Close()
+	if err := index.Prewarm(1); !errors.Is(err, ErrIndexClosed) {
+		t.Errorf("expected ErrIndexClosed, got %v", err)
+	}
+
+	loaded, err := Load(location, Cosine, dim, batchSize*3, false)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer loaded.Close()
+
+	if err := loaded.Prewarm(4); err != nil {
+		t.Fatalf("Prewarm failed: %v", err)
+	}
+	results, err := loaded.SearchKNN(query, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	for i := range results {
+		for j := range results[i] {
+			if *results[i][j] != *expected[i][j] {
+				t.Fatalf("expected the results of the saved index, got %v at row %d", *results[i][j], i)
+			}
+		}
+	}
+
+	empty, err := New(dim, M, efConstruction, 55, batchSize, Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer empty.Close()
+	if err := empty.Prewarm(-1); err != nil {
+		t.Errorf("expected no error for an empty index, got %v", err)
+	}
+}
+
+func TestVisitedListPoolSize(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	if n := index.GetVisitedListPoolSize(); n != 1 {
+		t.Errorf("expected the default pool size of 1, got %d", n)
+	}
+	if err := index.SetVisitedListPoolSize(0); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput, got %v", err)
+	}
+
+	if err := index.SetVisitedListPoolSize(8); err != nil {
+		t.Fatalf("SetVisitedListPoolSize failed: %v", err)
+	}
+	if err := index.ResizeIndex(batchSize * 2); err != nil {
+		t.Fatalf("ResizeIndex failed: %v", err)
+	}
+	index.MarkDeleted(0)
+	if err := index.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if n := index.GetVisitedListPoolSize(); n != 8 {
+		t.Errorf("expected the pool size to be kept, got %d", n)
+	}
+
+	// searches still work with more concurrent rows than preallocated lists.
+	results, err := index.SearchKNN(genQuery(dim, 32), 10, 16)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	if len(results) != 32 || len(results[0]) != 10 {
+		t.Errorf("unexpected results shape %d x %d", len(results), len(results[0]))
+	}
+
+	index.Close()
+	if n := index.GetVisitedListPoolSize(); n != 0 {
+		t.Errorf("expected 0 for a closed index, got %d", n)
+	}
+}
+
+func TestAddPointsAutoLabel(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, batchSize*3, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, _ := randomPoints(dim, 0, batchSize)
+	start, err := index.AddPointsAutoLabel(points, 2)
+	if err != nil || start != 0 {
+		t.Fatalf("expected the labels to start from 0, got %d, %v", start, err)
+	}
+
+	// deleted elements keep their label.
+	index.MarkDeleted(10)
+	start, err = index.AddPointsAutoLabel(points[:10], 2)
+	if err != nil || start != batchSize {
+		t.Fatalf("expected the labels to start from %d, got %d, %v", batchSize, start, err)
+	}
+	for i, point := range points[:10] {
+		vec, err := index.GetDataByLabel(start + uint64(i))
+		if err != nil {
+			t.Fatalf("GetDataByLabel failed: %v", err)
+		}
+		if !slices.Equal(vec, point) {
+			t.Errorf("label %d: expected the vector added at position %d", start+uint64(i), i)
+		}
+	}
+
+	index.AddPoints(points[:1], []uint64{batchSize + 12}, 1, false)
+	if _, err := index.AddPointsAutoLabel(points[:5], 1); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for labels already used, got %v", err)
+	}
+	if count := index.GetCurrentCount(); count != batchSize+11 {
+		t.Errorf("expected nothing to be added, got count %d", count)
+	}
+}
+
+func TestRemainingCapacity(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, batchSize*2, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize*2)
+	index.AddPoints(points[:batchSize], labels[:batchSize], 1, false)
+	if n := index.RemainingCapacity(); n != batchSize || index.IsFull() {
+		t.Errorf("expected %d slots left, got %d", batchSize, n)
+	}
+
+	index.AddPoints(points[batchSize:], labels[batchSize:], 1, false)
+	index.MarkDeletedBatch(labels[:10])
+	if n := index.RemainingCapacity(); n != 0 || !index.IsFull() {
+		t.Errorf("expected a full index, got %d slots left", n)
+	}
+
+	// deleted elements can be reused once replacement is allowed.
+	index.SetAllowReplaceDeleted(true)
+	if n := index.RemainingCapacity(); n != 10 || index.IsFull() {
+		t.Errorf("expected the 10 deleted slots, got %d", n)
+	}
+
+	index.Close()
+	if !index.IsFull() {
+		t.Error("expected a closed index to be full")
+	}
+}
+
+func TestLabels(t *testing.T) {
+	idx := newTestIndex(t, 3, false)
+	defer idx.Close()
+
+	idx.MarkDeletedBatch([]uint64{0, 150})
+
+	labels, err := idx.Labels()
+	if err != nil {
+		t.Fatalf("Labels failed: %v", err)
+	}
+
+	if len(labels) != 3*batchSize-2 {
+		t.Errorf("expected %d labels, got %d", 3*batchSize-2, len(labels))
+	}
+
+	if slices.Contains(labels, 0) || slices.Contains(labels, 150) {
+		t.Error("expected deleted labels to be skipped")
+	}
+
+	slices.Sort(labels)
+	if len(slices.Compact(labels)) != 3*batchSize-2 {
+		t.Error("expected labels to be unique")
+	}
+
+	visited := 0
+	idx.ForEachLabel(func(label uint64) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Errorf("expected iteration to stop after 10 labels, got %d", visited)
+	}
+}
+
+func TestMarkDeletedBatch(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	labels := []uint64{1, 2, 3, 4}
+	idx.MarkDeleted(2)
+	if err := idx.MarkDeletedBatch(labels); err != nil {
+		t.Fatalf("MarkDeletedBatch failed: %v", err)
+	}
+
+	for _, label := range labels {
+		if idx.ContainsLabel(label) {
+			t.Errorf("expected label %d to be deleted", label)
+		}
+	}
+
+	if err := idx.MarkDeletedBatch([]uint64{5, batchSize + 1}); err == nil {
+		t.Error("expected error for missing label")
+	}
+	if !idx.ContainsLabel(5) {
+		t.Error("expected no label deleted when one is missing")
+	}
+
+	if err := idx.UnmarkDeletedBatch(labels); err != nil {
+		t.Fatalf("UnmarkDeletedBatch failed: %v", err)
+	}
+
+	for _, label := range labels {
+		if !idx.ContainsLabel(label) {
+			t.Errorf("expected label %d to be restored", label)
+		}
+	}
+
+	if err := idx.UnmarkDeletedBatch([]uint64{batchSize + 1}); err == nil {
+		t.Error("expected error for missing label")
+	}
+}
+
+func TestReplacePoint(t *testing.T) {
+	allowRepaceDeleted := true
+	maxElements := 100
+	index, err := New(dim, M, efConstruction, 505, uint64(maxElements), Cosine, allowRepaceDeleted)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Free()
+
+	if !index.GetAllowReplaceDeleted() {
+		t.Fail()
+	}
+
+	points, labels := randomPoints(dim, 0, maxElements)
+	index.AddPoints(points, labels, 1, false)
+
+	index.MarkDeleted(labels[len(labels)-1])
+
+	err = index.AddPoints([][]float32{randomPoint(dim)}, []uint64{math.MaxUint64 - 1}, 1, false)
+	if err == nil {
+		t.Fail()
+	}
+
+	err = index.AddPoints([][]float32{randomPoint(dim)}, []uint64{math.MaxUint64 - 1}, 1, true)
+	if err != nil {
+		t.Fail()
+	}
+
+}
+
+func TestSetAllowReplaceDeleted(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 505, batchSize, Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+	// deleted while replacement is disabled.
+	index.MarkDeleted(labels[0])
+
+	err = index.AddPoints([][]float32{randomPoint(dim)}, []uint64{batchSize}, 1, true)
+	if err == nil || !strings.Contains(err.Error(), "SetAllowReplaceDeleted") {
+		t.Errorf("expected a clear error when replacement is not allowed, got %v", err)
+	}
+	if err := index.AddPoint(randomPoint(dim), batchSize, true); err == nil {
+		t.Error("expected AddPoint to fail as well")
+	}
+
+	index.SetAllowReplaceDeleted(true)
+	if !index.GetAllowReplaceDeleted() {
+		t.Fatal("expected replacement to be allowed")
+	}
+	if err := index.AddPoint(randomPoint(dim), batchSize, true); err != nil {
+		t.Fatalf("AddPoint failed: %v", err)
+	}
+	if index.GetCurrentCount() != batchSize || index.GetDeletedCount() != 0 {
+		t.Errorf("expected the deleted element to be replaced, count %d, deleted %d", index.GetCurrentCount(), index.GetDeletedCount())
+	}
+
+	index.SetAllowReplaceDeleted(false)
+	if index.GetAllowReplaceDeleted() {
+		t.Error("expected replacement to be disallowed")
+	}
+}
+
+func TestVectorSearch(t *testing.T) {
+	// Test 1: Basic search with valid index
+	t.Run("BasicSearch", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		query := genQuery(dim, 10)
+		topK := 5
+
+		result, err := index.SearchKNN(query, topK, 1)
+		if err != nil {
+			t.Errorf("SearchKNN failed: %v", err)
+			return
+		}
+
+		if len(result) != len(query) {
+			t.Errorf("expected %d results, got %d", len(query), len(result))
+		}
+
+		for i, rv := range result {
+			if len(rv) != topK {
+				t.Errorf("query %d: expected %d results, got %d", i, topK, len(rv))
+			}
+		}
+	})
+
+	// Test 2: Verify distances are in ascending order
+	t.Run("SortedDistances", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		query := genQuery(dim, 1)
+		result, err := index.SearchKNN(query, 5, 1)
+		if err != nil {
+			t.Errorf("SearchKNN failed: %v", err)
+			return
+		}
+
+		for i, rv := range result {
+			for j := 1; j < len(rv); j++ {
+				if rv[j].Distance < rv[j-1].Distance {
+					t.Errorf("query %d: distances not sorted at position %d: %f > %f",
+						i, j, rv[j-1].Distance, rv[j].Distance)
+				}
+			}
+		}
+	})
+
+	// Test 3: Edge case - k larger than maxElements returns error (expected behavior)
+	t.Run("KExceedsElements", func(t *testing.T) {
+		index := newTestIndex(t, 1, false) // 100 elements
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		query := genQuery(dim, 1)
+		// Request more than available - library returns error
+		_, err := index.SearchKNN(query, 200, 1)
+		if err == nil {
+			t.Errorf("expected error when k > maxElements, got nil")
+		}
+	})
+
+	// Test 4: Edge case - search with k=1
+	t.Run("SingleK", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		query := genQuery(dim, 1)
+		result, err := index.SearchKNN(query, 1, 1)
+		if err != nil {
+			t.Errorf("SearchKNN failed: %v", err)
+			return
+		}
+
+		if len(result[0]) != 1 {
+			t.Errorf("expected 1 result, got %d", len(result[0]))
+		}
+	})
+
+	// Test 5: Verify results are labeled (not empty labels)
+	t.Run("ValidLabels", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		query := genQuery(dim, 1)
+		result, err := index.SearchKNN(query, 5, 1)
+		if err != nil {
+			t.Errorf("SearchKNN failed: %v", err)
+			return
+		}
+
+		for i, rv := range result {
+			for j, r := range rv {
+				if r.Label == 0 {
+					t.Errorf("query %d: result %d has empty label", i, j)
+				}
+			}
+		}
+	})
+
+	// Test 6: Single query without the batch wrapping
+	t.Run("SingleQuery", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		result, err := index.SearchKNNSingle(randomPoint(dim), 5, 1)
+		if err != nil {
+			t.Errorf("SearchKNNSingle failed: %v", err)
+			return
+		}
+
+		if len(result) != 5 {
+			t.Errorf("expected 5 results, got %d", len(result))
+		}
+
+		if _, err := index.SearchKNNSingle(randomPoint(dim+1), 5, 1); err == nil {
+			t.Error("expected error for unmatched dimension")
+		}
+	})
+
+	// Test 7: Multiple queries in a single call
+	t.Run("MultipleQueries", func(t *testing.T) {
+		index := newTestIndex(t, 3, false) // 300 elements
+		index.SetEf(efConstruction)
+		defer index.Free()
+
+		query := genQuery(dim, 50)
+		result, err := index.SearchKNN(query, 10, 1)
+		if err != nil {
+			t.Errorf("SearchKNN failed: %v", err)
+			return
+		}
+
+		if len(result) != 50 {
+			t.Errorf("expected 50 results, got %d", len(result))
+		}
+	})
+
+}
+
+func TestSearchKNNFewerThanTopK(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, 3)
+	if err := index.AddPoints(points, labels, 1, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
+
+	results, err := index.SearchKNN([][]float32{points[0], randomPoint(dim)}, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+
+	for i, row := range results {
+		if len(row) != 3 {
+			t.Fatalf("row %d: expected 3 results, got %d", i, len(row))
+		}
+
+		seen := make(map[uint64]bool)
+		for _, r := range row {
+			if r.Label > 2 || seen[r.Label] {
+				t.Errorf("row %d: unexpected label %d", i, r.Label)
+			}
+			seen[r.Label] = true
+		}
+	}
+
+	if results[0][0].Label != 0 {
+		t.Errorf("expected the query point itself first, got label %d", results[0][0].Label)
+	}
+
+	index.MarkDeleted(1)
+	row, err := index.SearchKNNSingle(points[0], 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNSingle failed: %v", err)
+	}
+	if len(row) != 2 {
+		t.Errorf("expected 2 results after deletion, got %d", len(row))
+	}
+}
+
+func TestSearchResultOrder(t *testing.T) {
+	checkOrder := func(t *testing.T, row []*SearchResult) {
+		for i := 1; i < len(row); i++ {
+			if row[i-1].Distance > row[i].Distance {
+				t.Fatalf("results not sorted by ascending distance at %d: %v > %v", i, row[i-1].Distance, row[i].Distance)
+			}
+		}
+	}
+
+	for _, space := range []SpaceType{L2, IP, Cosine, L1, Linf} {
+		index, err := New(dim, M, efConstruction, 55, uint64(batchSize), space, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		points, labels := randomPoints(dim, 0, batchSize)
+		index.AddPoints(points, labels, 1, false)
+		index.SetEf(50)
+
+		queries := genQuery(dim, 5)
+		results, err := index.SearchKNN(queries, 20, 1)
+		if err != nil {
+			t.Fatalf("SearchKNN failed: %v", err)
+		}
+		for _, row := range results {
+			checkOrder(t, row)
+		}
+
+		filtered, _ := index.SearchKNNFiltered(queries[0], 20, func(label uint64) bool { return label%2 == 0 })
+		checkOrder(t, filtered)
+
+		inRange, _ := index.SearchRange(queries[0], results[0][len(results[0])-1].Distance, 20)
+		checkOrder(t, inRange)
+
+		if space == IP {
+			// the first result has the largest inner product.
+			var best float32
+			for _, v := range results[0] {
+				vec, _ := index.GetDataByLabel(v.Label)
+				var dot float32
+				for i := range vec {
+					dot += vec[i] * queries[0][i]
+				}
+				if v == results[0][0] {
+					best = dot
+				} else if dot > best+1e-4 {
+					t.Errorf("label %d has a larger inner product than the first result", v.Label)
+				}
+			}
+		}
+
+		index.Close()
+	}
+}
+
+func TestSearchRange(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+	index.SetEf(batchSize)
+
+	query := points[0]
+	dists := make([]float32, 0, len(points))
+	for _, p := range points {
+		var d float32
+		for i := range p {
+			d += (p[i] - query[i]) * (p[i] - query[i])
+		}
+		dists = append(dists, d)
+	}
+	slices.Sort(dists)
+	radius := dists[10]
+
+	t.Run("WithinRadius", func(t *testing.T) {
+		result, err := index.SearchRange(query, radius, batchSize)
+		if err != nil {
+			t.Fatalf("SearchRange failed: %v", err)
+		}
+
+		if len(result) == 0 || len(result) > 11 {
+			t.Errorf("expected at most 11 results, got %d", len(result))
+		}
+
+		if result[0].Label != labels[0] {
+			t.Errorf("expected the query point itself first, got label %d", result[0].Label)
+		}
+
+		for i, r := range result {
+			if r.Distance > radius {
+				t.Errorf("result %d: distance %f exceeds radius %f", i, r.Distance, radius)
+			}
+			if i > 0 && r.Distance < result[i-1].Distance {
+				t.Errorf("result %d: distances not sorted", i)
+			}
+		}
+	})
+
+	t.Run("MaxResults", func(t *testing.T) {
+		result, err := index.SearchRange(query, math.MaxFloat32, 5)
+		if err != nil {
+			t.Fatalf("SearchRange failed: %v", err)
+		}
+
+		if len(result) != 5 {
+			t.Errorf("expected 5 results, got %d", len(result))
+		}
+	})
+
+	// labels are returned rather than internal ids, which randomPoints makes equal.
+	t.Run("SparseLabels", func(t *testing.T) {
+		sparse, err := New(dim, M, efConstruction, 55, 2, L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer sparse.Close()
+
+		if err := sparse.AddPoints(points[:2], []uint64{1000, 2000}, 1, false); err != nil {
+			t.Fatalf("AddPoints failed: %v", err)
+		}
+
+		result, err := sparse.SearchRange(points[0], math.MaxFloat32, 2)
+		if err != nil {
+			t.Fatalf("SearchRange failed: %v", err)
+		}
+		if len(result) != 2 || result[0].Label != 1000 || result[1].Label != 2000 {
+			t.Errorf("expected labels 1000 and 2000, got %v", result)
+		}
+	})
+}
+
+func TestCheckVector(t *testing.T) {
+	l2, _ := New(dim, M, efConstruction, 55, 10, L2, false)
+	defer l2.Close()
+	cosine, _ := New(dim, M, efConstruction, 55, 10, Cosine, false)
+	defer cosine.Close()
+
+	vec := randomPoint(dim)
+	if err := l2.CheckVector(vec); err != nil {
+		t.Errorf("expected a valid vector, got %v", err)
+	}
+
+	err := l2.CheckVector(vec[:dim-1])
+	if err == nil || !strings.Contains(err.Error(), "got 399, want 400") {
+		t.Errorf("expected error naming the dimensions, got %v", err)
+	}
+
+	invalid := slices.Clone(vec)
+	invalid[3] = float32(math.NaN())
+	if err := l2.CheckVector(invalid); err == nil {
+		t.Error("expected error for a NaN value")
+	}
+	invalid[3] = float32(math.Inf(-1))
+	if err := l2.CheckVector(invalid); err == nil {
+		t.Error("expected error for an infinite value")
+	}
+
+	if err := cosine.CheckVector(vec); !errors.Is(err, ErrNotNormalized) {
+		t.Errorf("expected ErrNotNormalized, got %v", err)
+	}
+	if err := cosine.CheckVector(make([]float32, dim)); !errors.Is(err, ErrInvalidInput) || errors.Is(err, ErrNotNormalized) {
+		t.Errorf("expected ErrInvalidInput for a zero vector, got %v", err)
+	}
+	Normalize(vec)
+	if err := cosine.CheckVector(vec); err != nil {
+		t.Errorf("expected a normalized vector to be valid, got %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	vec := []float32{3, 4}
+	Normalize(vec)
+	if math.Abs(float64(vec[0]-0.6)) > 1e-6 || math.Abs(float64(vec[1]-0.8)) > 1e-6 {
+		t.Errorf("unexpected normalized vector %v", vec)
+	}
+
+	zero := []float32{0, 0}
+	Normalize(zero)
+	if zero[0] != 0 || zero[1] != 0 {
+		t.Errorf("expected zero vector to be unchanged, got %v", zero)
+	}
+}
+
+func TestCosineNormalization(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	index.SetEf(batchSize)
+	defer index.Close()
+
+	// stored vectors are normalized.
+	vec, err := index.GetDataByLabel(0)
+	if err != nil {
+		t.Fatalf("GetDataByLabel failed: %v", err)
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if math.Abs(norm-1) > 1e-4 {
+		t.Errorf("expected stored vector to be normalized, got norm %f", norm)
+	}
+
+	// queries are normalized, so scaling a query does not change the results.
+	query := randomPoint(dim)
+	scaled := make([]float32, dim)
+	for i := range query {
+		scaled[i] = query[i] * 10
+	}
+
+	r1, err := index.SearchKNNSingle(query, 5, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNSingle failed: %v", err)
+	}
+	r2, err := index.SearchKNNSingle(scaled, 5, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNSingle failed: %v", err)
+	}
+
+	for i := range r1 {
+		if r1[i].Label != r2[i].Label {
+			t.Errorf("result %d: expected label %d, got %d", i, r1[i].Label, r2[i].Label)
+		}
+	}
+}
+
+func TestSpaceTypeString(t *testing.T) {
+	for _, s := range []SpaceType{L2, IP, Cosine, L1, Linf} {
+		parsed, err := ParseSpaceType(s.String())
+		if err != nil || parsed != s {
+			t.Errorf("ParseSpaceType(%q): expected %d, got %d, %v", s.String(), s, parsed, err)
+		}
+	}
+
+	if s, err := ParseSpaceType("Cosine"); err != nil || s != Cosine {
+		t.Errorf("expected names to be case-insensitive, got %v, %v", s, err)
+	}
+	if _, err := ParseSpaceType("hamming"); err == nil {
+		t.Error("expected error for an unknown space type")
+	}
+	if name := SpaceType(42).String(); name != "SpaceType(42)" {
+		t.Errorf("unexpected name of an unknown space type: %s", name)
+	}
+}
+
+func TestSpaceTypeJSON(t *testing.T) {
+	var config struct {
+		SpaceType SpaceType `json:"spaceType"`
+	}
+	if err := json.Unmarshal([]byte(`{"spaceType": "cosine"}`), &config); err != nil || config.SpaceType != Cosine {
+		t.Errorf("expected Cosine, got %v, %v", config.SpaceType, err)
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil || string(data) != `{"spaceType":"cosine"}` {
+		t.Errorf("unexpected JSON %s, %v", data, err)
+	}
+
+	for _, invalid := range []string{`{"spaceType": "hamming"}`, `{"spaceType": 2}`} {
+		if err := json.Unmarshal([]byte(invalid), &config); err == nil {
+			t.Errorf("expected error decoding %s", invalid)
+		}
+	}
+	if _, err := json.Marshal(SpaceType(42)); err == nil {
+		t.Error("expected error encoding an unknown space type")
+	}
+}
+
+func TestL1AndLinfSpaces(t *testing.T) {
+	l1 := func(a, b []float32) float32 {
+		var d float32
+		for i := range a {
+			d += float32(math.Abs(float64(a[i] - b[i])))
+		}
+		return d
+	}
+	linf := func(a, b []float32) float32 {
+		var d float32
+		for i := range a {
+			d = max(d, float32(math.Abs(float64(a[i]-b[i]))))
+		}
+		return d
+	}
+
+	cases := []struct {
+		name      string
+		spaceType SpaceType
+		distance  func(a, b []float32) float32
+	}{
+		{"L1", L1, l1},
+		{"Linf", Linf, linf},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			index, err := New(dim, M, efConstruction, 55, uint64(batchSize), c.spaceType, false)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			defer index.Close()
+
+			if index.SpaceType() != c.spaceType {
+				t.Errorf("expected space type %d, got %d", c.spaceType, index.SpaceType())
+			}
+
+			points, labels := randomPoints(dim, 0, batchSize)
+			index.AddPoints(points, labels, 1, false)
+			// ef as large as the index makes the search exhaustive.
+			index.SetEf(batchSize)
+
+			query := randomPoint(dim)
+			result, err := index.SearchKNNSingle(query, 5, 1)
+			if err != nil {
+				t.Fatalf("SearchKNNSingle failed: %v", err)
+			}
+
+			// brute force reference
+			exact := make([]float32, len(points))
+			for i, p := range points {
+				exact[i] = c.distance(query, p)
+			}
+			nearest := 0
+			for i := range exact {
+				if exact[i] < exact[nearest] {
+					nearest = i
+				}
+			}
+
+			if result[0].Label != labels[nearest] {
+				t.Errorf("expected nearest label %d, got %d", labels[nearest], result[0].Label)
+			}
+
+			for _, r := range result {
+				if math.Abs(float64(r.Distance-exact[r.Label])) > 1e-3 {
+					t.Errorf("label %d: expected distance %f, got %f", r.Label, exact[r.Label], r.Distance)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchKNNWithEf(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize*5), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	bf, _ := NewBruteForce(dim, uint64(batchSize*5), L2)
+	defer bf.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize*5)
+	index.AddPoints(points, labels, 1, false)
+	bf.AddPoints(points, labels)
+	index.SetEf(1)
+
+	queries := genQuery(dim, 20)
+	exact, _ := bf.SearchKNN(queries, 10, 1)
+	low, _ := index.SearchKNN(queries, 10, 1)
+
+	var wg sync.WaitGroup
+	var high [][]*SearchResult
+	for _, ef := range []int{400, 20} {
+		wg.Add(1)
+		go func(ef int) {
+			defer wg.Done()
+			results, err := index.SearchKNNWithEf(queries, 10, ef, 1)
+			if err != nil {
+				t.Errorf("SearchKNNWithEf failed: %v", err)
+			}
+			if ef == 400 {
+				high = results
+			}
+		}(ef)
+	}
+	wg.Wait()
+
+	if index.GetEf() != 1 {
+		t.Errorf("expected the index ef to stay 1, got %d", index.GetEf())
+	}
+	for _, row := range high {
+		if len(row) != 10 {
+			t.Fatalf("expected 10 results, got %d", len(row))
+		}
+	}
+	if Recall(high, exact, 10) < Recall(low, exact, 10) {
+		t.Errorf("expected a larger ef to give a better recall: %v < %v", Recall(high, exact, 10), Recall(low, exact, 10))
+	}
+	if recall := Recall(high, exact, 10); recall < 0.95 {
+		t.Errorf("expected a recall of at least 0.95 with ef=400, got %v", recall)
+	}
+
+	if _, err := index.SearchKNNWithEf(queries, 10, 0, 1); err == nil {
+		t.Error("expected error for a non-positive ef")
+	}
+}
+
+func TestSearchKNNValues(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	index.SetEf(50)
+
+	queries := genQuery(dim, 5)
+	pointers, err := index.SearchKNN(queries, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	values, err := index.SearchKNNValues(queries, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNValues failed: %v", err)
+	}
+
+	for i := range pointers {
+		if len(values[i]) != len(pointers[i]) {
+			t.Fatalf("row %d: expected %d results, got %d", i, len(pointers[i]), len(values[i]))
+		}
+		for j := range pointers[i] {
+			if values[i][j] != *pointers[i][j] {
+				t.Errorf("row %d: expected %v at %d, got %v", i, *pointers[i][j], j, values[i][j])
+			}
+		}
+	}
+
+	// rows must not overlap in the shared backing array.
+	values[0] = append(values[0], SearchResult{Label: 12345})
+	if values[1][0].Label == 12345 {
+		t.Error("appending to a row overwrote the next one")
+	}
+
+	if _, err := index.SearchKNNValues(nil, 10, 1); err == nil {
+		t.Error("expected error for empty vectors")
+	}
+}
+
+func TestSearchKNNInto(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	index.SetEf(50)
+
+	queries := genQuery(dim, 5)
+	expected, _ := index.SearchKNNValues(queries, 10, 1)
+
+	var buf [][]SearchResult
+	for i := 0; i < 2; i++ {
+		var err error
+		buf, err = index.SearchKNNInto(buf, queries, 10, 1)
+		if err != nil {
+			t.Fatalf("SearchKNNInto failed: %v", err)
+		}
+		if len(buf) != len(queries) {
+			t.Fatalf("expected %d rows, got %d", len(queries), len(buf))
+		}
+		for r := range expected {
+			if !slices.Equal(buf[r], expected[r]) {
+				t.Errorf("row %d: expected %v, got %v", r, expected[r], buf[r])
+			}
+		}
+	}
+
+	// a smaller batch reuses the first rows.
+	first := &buf[0][:1][0]
+	buf, _ = index.SearchKNNInto(buf, queries[:2], 10, 1)
+	if len(buf) != 2 || &buf[0][:1][0] != first {
+		t.Error("expected the buffer to be reused")
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		buf, _ = index.SearchKNNInto(buf, queries, 10, 1)
+	})
+	// only the flattened query vectors are allocated.
+	if allocs > 1 {
+		t.Errorf("expected at most 1 allocation with a reused buffer, got %v", allocs)
+	}
+}
+
+func benchmarkSearch(b *testing.B, search func(index *HnswIndex, queries [][]float32)) {
+	index, err := New(dim, M, efConstruction, 55, 1000, L2, false)
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, 1000)
+	index.AddPoints(points, labels, 4, false)
+	queries := genQuery(dim, 10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search(index, queries)
+	}
+}
+
+func BenchmarkSearchKNN(b *testing.B) {
+	benchmarkSearch(b, func(index *HnswIndex, queries [][]float32) {
+		index.SearchKNN(queries, 50, 1)
+	})
+}
+
+func BenchmarkSearchKNNValues(b *testing.B) {
+	benchmarkSearch(b, func(index *HnswIndex, queries [][]float32) {
+		index.SearchKNNValues(queries, 50, 1)
+	})
+}
+
+func BenchmarkSearchKNNInto(b *testing.B) {
+	var buf [][]SearchResult
+	benchmarkSearch(b, func(index *HnswIndex, queries [][]float32) {
+		buf, _ = index.SearchKNNInto(buf, queries, 50, 1)
+	})
+}
+
+func TestSearchKNNFlat(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	queries := genQuery(dim, 5)
+	flat := flatten2DArray(queries)
+	expected, _ := index.SearchKNN(queries, 10, 1)
+	results, err := index.SearchKNNFlat(flat, len(queries), 10, 2)
+	if err != nil {
+		t.Fatalf("SearchKNNFlat failed: %v", err)
+	}
+
+	for i := range expected {
+		if len(results[i]) != len(expected[i]) {
+			t.Fatalf("row %d: expected %d results, got %d", i, len(expected[i]), len(results[i]))
+		}
+		for j := range expected[i] {
+			if *results[i][j] != *expected[i][j] {
+				t.Errorf("row %d, result %d: expected %v, got %v", i, j, *expected[i][j], *results[i][j])
+			}
+		}
+	}
+
+	for _, n := range []int{0, 4, 6} {
+		if _, err := index.SearchKNNFlat(flat, n, 10, 1); err == nil {
+			t.Errorf("expected error for %d vectors in a buffer of 5", n)
+		}
+	}
+}
+
+func TestSearchKNNWithVectors(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+
+	results, err := index.SearchKNNWithVectors(points[8], 5, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNWithVectors failed: %v", err)
+	}
+	plain, _ := index.SearchKNNSingle(points[8], 5, 1)
+	if len(results) != len(plain) {
+		t.Fatalf("expected %d results, got %d", len(plain), len(results))
+	}
+
+	for i, r := range results {
+		if r.Label != plain[i].Label || r.Distance != plain[i].Distance {
+			t.Errorf("result %d: expected %v, got label %d distance %v", i, *plain[i], r.Label, r.Distance)
+		}
+		if !slices.Equal(r.Vector, points[r.Label]) {
+			t.Errorf("result %d: vector does not match the stored vector of label %d", i, r.Label)
+		}
+	}
+
+	if _, err := index.SearchKNNWithVectors(points[0][1:], 5, 1); err == nil {
+		t.Error("expected error for unmatched dimension")
+	}
+}
+
+func TestSearchKNNMultiK(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	query := genQuery(dim, 1)[0]
+	byK, err := index.SearchKNNMultiK(query, []int{10, 50})
+	if err != nil {
+		t.Fatalf("SearchKNNMultiK failed: %v", err)
+	}
+	if len(byK) != 2 || len(byK[10]) != 10 || len(byK[50]) != 50 {
+		t.Fatalf("unexpected result sizes: top 10 %d, top 50 %d", len(byK[10]), len(byK[50]))
+	}
+	for i, r := range byK[10] {
+		if *r != *byK[50][i] {
+			t.Errorf("result %d: top 10 has %v, top 50 has %v", i, *r, *byK[50][i])
+		}
+	}
+
+	for _, ks := range [][]int{nil, {0}, {-1, 10}, {10, batchSize + 1}} {
+		if _, err := index.SearchKNNMultiK(query, ks); err == nil {
+			t.Errorf("expected error for ks %v", ks)
+		}
+	}
+
+	// deleted elements are not counted.
+	live := int(index.GetLiveCount())
+	if err := index.MarkDeleted(byK[10][0].Label); err != nil {
+		t.Fatalf("MarkDeleted failed: %v", err)
+	}
+	if _, err := index.SearchKNNMultiK(query, []int{live}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for k %d with a deleted element, got %v", live, err)
+	}
+}
+
+func TestSearchKNNRescore(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+
+	// with all the elements as candidates the result is exact.
+	query := genQuery(dim, 1)[0]
+	results, err := index.SearchKNNRescore(query, 10, batchSize, 2)
+	if err != nil {
+		t.Fatalf("SearchKNNRescore failed: %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("expected 10 results, got %d", len(results))
+	}
+
+	dists := make([]float64, batchSize)
+	for i, p := range points {
+		for j := range p {
+			d := float64(p[j]) - float64(query[j])
+			dists[i] += d * d
+		}
+	}
+	order := make([]int, batchSize)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return dists[order[a]] < dists[order[b]] })
+
+	for i, r := range results {
+		if r.Label != uint64(order[i]) {
+			t.Errorf("result %d: expected label %d, got %d", i, order[i], r.Label)
+		}
+		if math.Abs(float64(r.Distance)-dists[r.Label]) > 1e-3 {
+			t.Errorf("result %d: expected distance %v, got %v", i, dists[r.Label], r.Distance)
+		}
+	}
+
+	for _, k := range [][2]int{{0, 10}, {10, 5}, {10, batchSize + 1}} {
+		if _, err := index.SearchKNNRescore(query, k[0], k[1], 1); err == nil {
+			t.Errorf("expected error for topK %d and candidateK %d", k[0], k[1])
+		}
+	}
+}
+
+func TestSearchKNNDedup(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	// 10 labels per document.
+	document := func(label uint64) uint64 { return label / 10 }
+
+	query := genQuery(dim, 1)[0]
+	results, err := index.SearchKNNDedup(query, 5, batchSize, document)
+	if err != nil {
+		t.Fatalf("SearchKNNDedup failed: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(results))
+	}
+
+	// the results are the closest label of the first documents found by a plain search.
+	all, _ := index.SearchKNNSingle(query, batchSize, 1)
+	var expected []*SearchResult
+	seen := map[uint64]bool{}
+	for _, r := range all {
+		if !seen[document(r.Label)] && len(expected) < 5 {
+			seen[document(r.Label)] = true
+			expected = append(expected, r)
+		}
+	}
+	for i, r := range results {
+		if *r != *expected[i] {
+			t.Errorf("result %d: expected %v, got %v", i, *expected[i], *r)
+		}
+	}
+
+	// the 100 labels map to 10 documents.
+	results, _ = index.SearchKNNDedup(query, 20, batchSize, document)
+	if len(results) != 10 {
+		t.Errorf("expected one result per document, got %d", len(results))
+	}
+
+	if _, err := index.SearchKNNDedup(query, 5, 4, document); err == nil {
+		t.Error("expected error for candidateK less than topK")
+	}
+	if _, err := index.SearchKNNDedup(query, 5, 10, nil); err == nil {
+		t.Error("expected error for a nil keyFn")
+	}
+}
+
+func TestSearchResultSize(t *testing.T) {
+	if err := checkResultSize(1000, 100); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if math.MaxInt > math.MaxInt32 {
+		tooLarge := int64(math.MaxInt32) + 1
+		if err := checkResultSize(1, int(tooLarge)); err == nil {
+			t.Error("expected error for a topK overflowing the C int")
+		}
+	}
+
+	// rows with fewer neighbors than topK only return the neighbors found.
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	for i := uint64(1); i < batchSize; i++ {
+		index.MarkDeleted(i)
+	}
+	results, err := index.SearchKNN(genQuery(dim, 3), 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	for i, row := range results {
+		if len(row) != 1 || row[0].Label != 0 {
+			t.Errorf("row %d: expected the only live label 0, got %d results", i, len(row))
+		}
+	}
+}
+
+func TestSearchKNNSimilarity(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	query := genQuery(dim, 2)
+	similar, err := index.SearchKNNSimilarity(query, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNSimilarity failed: %v", err)
+	}
+	plain, _ := index.SearchKNN(query, 10, 1)
+
+	for i, row := range similar {
+		for j, r := range row {
+			if r.Label != plain[i][j].Label || r.Distance != 1-plain[i][j].Distance {
+				t.Errorf("row %d result %d: expected similarity %v of label %d, got %v of label %d",
+					i, j, 1-plain[i][j].Distance, plain[i][j].Label, r.Distance, r.Label)
+			}
+			if j > 0 && r.Distance > row[j-1].Distance {
+				t.Errorf("row %d: results are not ordered by descending similarity", i)
+			}
+		}
+	}
+
+	l2, err := New(dim, M, efConstruction, 55, batchSize, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer l2.Close()
+	if _, err := l2.SearchKNNSimilarity(query, 10, 1); err == nil {
+		t.Error("expected error for L2 space")
+	}
+}
+
+func TestConcurrentAddAndSearch(t *testing.T) {
+	const (
+		smallDim = 16
+		n        = 4000
+		writers  = 4
+		readers  = 4
+		batch    = 50
+	)
+
+	// the index grows while it is searched.
+	index, err := New(smallDim, M, efConstruction, 55, batch, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+	index.SetAutoGrow(DefaultGrowFactor)
+
+	points, labels := randomPoints(smallDim, 0, n)
+	distance := func(query []float32, label uint64) float64 {
+		var d float64
+		for i, v := range points[label] {
+			d += float64(v-query[i]) * float64(v-query[i])
+		}
+		return d
+	}
+
+	var wg, searches sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		searches.Add(1)
+		go func() {
+			defer searches.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// labels of elements being inserted are skipped, their label may not be written yet.
+				var stored []uint64
+				if err := index.ForEachLabel(func(label uint64) bool {
+					stored = append(stored, label)
+					return len(stored) < 100
+				}); err != nil {
+					t.Errorf("ForEachLabel failed: %v", err)
+					return
+				}
+				vectors, err := index.GetDataByLabels(stored)
+				if err != nil {
+					t.Errorf("GetDataByLabels failed: %v", err)
+					return
+				}
+				for i, label := range stored {
+					if label >= n || !slices.Equal(vectors[i], points[label]) {
+						t.Errorf("inconsistent vector of label %d", label)
+						return
+					}
+				}
+
+				query := randomPoint(smallDim)
+				results, err := index.SearchKNNSingle(query, 10, 1)
+				if err != nil {
+					t.Errorf("SearchKNNSingle failed: %v", err)
+					return
+				}
+				for i, r := range results {
+					// a result matching the stored vector of its label was not read while being written.
+					if r.Label >= n || math.Abs(float64(r.Distance)-distance(query, r.Label)) > 1e-4 {
+						t.Errorf("inconsistent result %v", *r)
+						return
+					}
+					if i > 0 && r.Distance < results[i-1].Distance {
+						t.Errorf("results are not ordered: %v", results)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for start := w * batch; start < n; start += writers * batch {
+				if err := index.AddPoints(points[start:start+batch], labels[start:start+batch], 1, false); err != nil {
+					t.Errorf("AddPoints failed: %v", err)
+					return
+				}
+				// rewrite an added element in place, which is exclusive.
+				if err := index.UpdatePoint(points[start], labels[start], false); err != nil {
+					t.Errorf("UpdatePoint failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(done)
+	searches.Wait()
+
+	if count := index.GetCurrentCount(); count != n {
+		t.Errorf("expected %d elements, got %d", n, count)
+	}
+	if err := index.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
+
+func TestConcurrentAddSameLabels(t *testing.T) {
+	const (
+		smallDim = 16
+		n        = 2000
+		writers  = 4
+		readers  = 4
+		batch    = 50
+	)
+
+	index, err := New(smallDim, M, efConstruction, 55, batch, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+	index.SetAutoGrow(DefaultGrowFactor)
+
+	// every writer inserts the same labels with its own vectors, so all but the first insertion of a label
+	// rewrite an element in place.
+	points := make([][][]float32, writers)
+	var labels []uint64
+	for w := range points {
+		points[w], labels = randomPoints(smallDim, 0, n)
+	}
+	matches := func(query []float32, r *SearchResult) bool {
+		for w := range points {
+			var d float64
+			for i, v := range points[w][r.Label] {
+				d += float64(v-query[i]) * float64(v-query[i])
+			}
+			if math.Abs(float64(r.Distance)-d) <= 1e-4 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var wg, searches sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		searches.Add(1)
+		go func() {
+			defer searches.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				query := randomPoint(smallDim)
+				results, err := index.SearchKNNSingle(query, 10, 1)
+				if err != nil {
+					t.Errorf("SearchKNNSingle failed: %v", err)
+					return
+				}
+				for _, r := range results {
+					if r.Label >= n || !matches(query, r) {
+						t.Errorf("inconsistent result %v", *r)
+						return
+					}
+				}
+				if len(results) > 0 {
+					if _, err := index.GetDataByLabel(results[0].Label); err != nil {
+						t.Errorf("GetDataByLabel failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for start := 0; start < n; start += batch {
+				if err := index.AddPoints(points[w][start:start+batch], labels[start:start+batch], 1, false); err != nil {
+					t.Errorf("AddPoints failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
 
-	idx := newTestIndex(1, true)
-	defer idx.Free()
+	wg.Wait()
+	close(done)
+	searches.Wait()
 
-	if idx.GetMaxElements() != maxElements {
-		t.Fail()
+	if count := index.GetCurrentCount(); count != n {
+		t.Errorf("expected %d elements, got %d", n, count)
 	}
+	if err := index.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
 
-	if idx.GetAllowReplaceDeleted() != true {
-		t.Fail()
+func TestClaimLabels(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, batchSize, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
 	}
+	defer index.Close()
 
-	if idx.GetCurrentCount() != maxElements {
-		t.Fail()
+	if index.claim([]uint64{1, 2, 1}) {
+		t.Error("expected a batch repeating a label not to be claimed")
+	}
+	if !index.claim([]uint64{1, 2}) {
+		t.Fatal("expected labels to be claimed")
+	}
+	if index.claim([]uint64{3, 2}) {
+		t.Error("expected a label claimed by another insertion not to be claimed")
+	}
+	// a failed claim releases the labels it claimed.
+	if !index.claim([]uint64{3}) {
+		t.Error("expected label 3 to be free")
+	}
+	index.unclaim([]uint64{1, 2})
+	if !index.claim([]uint64{1, 2}) {
+		t.Error("expected released labels to be claimed again")
 	}
 
+	// readers of a label being inserted wait for the insertion, the others do not.
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		index.lockLabels(3)
+		index.unlockLabels()
+	}()
+	index.lockLabels(4)
+	index.unlockLabels()
+	select {
+	case <-released:
+		t.Error("expected the reader of a pending label to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	index.unclaim([]uint64{3})
+	<-released
 }
 
-func TestLoadAndSaveIndex(t *testing.T) {
-	var maxElements uint64 = batchSize * 1
+func TestSearchKNNContext(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	index.SetEf(efConstruction)
+	defer index.Close()
 
-	// setup
-	idx := newTestIndex(1, true)
-	idx.Save(testVectorDB)
-	idx.Free()
+	query := genQuery(dim, 10)
 
-	index := Load(testVectorDB, Cosine, dim, uint64(maxElements), true)
-	index.SetEf(efConstruction)
-	defer index.Free()
+	result, err := index.SearchKNNContext(context.Background(), query, 5, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNContext failed: %v", err)
+	}
+	if len(result) != len(query) {
+		t.Errorf("expected %d results, got %d", len(query), len(result))
+	}
 
-	index.Save(testVectorDB)
-	t.Cleanup(func() {
-		deleteDB()
-	})
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := index.SearchKNNContext(ctx, query, 5, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
 
-func TestResizeIndex(t *testing.T) {
-	var maxElements uint64 = batchSize * 1
+	// a flag set before the C call abandons the search.
+	cancelled := int32(1)
+	if _, err := index.searchKNN(query, 5, 0, 1, &cancelled); err == nil {
+		t.Error("expected error for a cancelled search")
+	}
+}
 
-	idx := newTestIndex(1, false)
-	defer idx.Free()
+func TestSearchKNNStream(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
 
-	if idx.GetMaxElements() != maxElements {
-		t.Fail()
+	// more rows than a chunk.
+	query := genQuery(dim, 1100)
+	expected, err := index.SearchKNN(query, 5, 4)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
 	}
 
-	if idx.GetCurrentCount() != maxElements {
-		t.Fail()
+	next := 0
+	err = index.SearchKNNStream(query, 5, 4, func(rowIndex int, results []*SearchResult) error {
+		if rowIndex != next {
+			t.Fatalf("expected row %d, got %d", next, rowIndex)
+		}
+		next++
+		if len(results) != len(expected[rowIndex]) {
+			t.Fatalf("row %d: expected %d results, got %d", rowIndex, len(expected[rowIndex]), len(results))
+		}
+		for i := range results {
+			if results[i].Label != expected[rowIndex][i].Label {
+				t.Fatalf("row %d: expected the results of SearchKNN", rowIndex)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchKNNStream failed: %v", err)
+	}
+	if next != len(query) {
+		t.Errorf("expected %d rows, got %d", len(query), next)
 	}
 
-	if idx.GetAllowReplaceDeleted() != false {
-		t.Fail()
+	stop := errors.New("stop")
+	calls := 0
+	err = index.SearchKNNStream(query, 5, 1, func(rowIndex int, results []*SearchResult) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected the callback error after 1 call, got %v after %d", err, calls)
 	}
 
-	points, labels := randomPoints(dim, 1*batchSize, batchSize)
-	err := idx.AddPoints(points, labels, 1, false)
-	if err == nil {
-		t.Log(err)
-		t.FailNow()
+	if err := index.SearchKNNStream([][]float32{make([]float32, dim-1)}, 5, 1, nil); !errors.Is(err, ErrDimMismatch) {
+		t.Errorf("expected ErrDimMismatch, got %v", err)
 	}
+}
 
-	idx.ResizeIndex(maxElements * 2)
-	if idx.GetMaxElements() != maxElements*2 {
-		t.Fail()
+func TestCosineInvalidInput(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	bf, err := NewBruteForce(dim, batchSize, Cosine)
+	if err != nil {
+		t.Fatalf("NewBruteForce failed: %v", err)
+	}
+	defer bf.Close()
+
+	nan := randomPoint(dim)
+	nan[3] = float32(math.NaN())
+	inf := randomPoint(dim)
+	inf[5] = float32(math.Inf(-1))
+
+	for name, vec := range map[string][]float32{"zero": make([]float32, dim), "NaN": nan, "Inf": inf} {
+		vectors := [][]float32{randomPoint(dim), vec}
+		errs := map[string]error{
+			"AddPoints":   index.AddPoints(vectors, []uint64{batchSize, batchSize + 1}, 1, false),
+			"AddPoint":    index.AddPoint(vec, batchSize, false),
+			"UpdatePoint": index.UpdatePoint(vec, 0, false),
+			"BruteForce":  bf.AddPoints(vectors, []uint64{0, 1}),
+		}
+		_, errs["SearchKNN"] = index.SearchKNN(vectors, 5, 1)
+		_, errs["SearchRange"] = index.SearchRange(vec, 0.5, 5)
+		_, errs["SearchKNNFiltered"] = index.SearchKNNFiltered(vec, 5, nil)
+		_, errs["BruteForceSearch"] = bf.SearchKNN(vectors, 1, 1)
+		for method, err := range errs {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("%s with a %s vector: expected ErrInvalidInput, got %v", method, name, err)
+			}
+		}
+	}
+	if count := index.GetCurrentCount(); count != batchSize {
+		t.Errorf("expected nothing to be added, got count %d", count)
 	}
 
-	if idx.GetCurrentCount() != maxElements {
-		t.Fail()
+	if _, err := index.SearchKNN([][]float32{randomPoint(dim), make([]float32, dim)}, 5, 1); err == nil || !strings.Contains(err.Error(), "row 1") {
+		t.Errorf("expected the error to name row 1, got %v", err)
 	}
 
-	err = idx.AddPoints(points, labels, 1, false)
+	// a zero vector is valid in the other spaces.
+	l2, err := New(dim, M, efConstruction, 55, batchSize, L2, false)
 	if err != nil {
-		t.Log(err)
-		t.Fail()
+		t.Fatalf("New failed: %v", err)
+	}
+	defer l2.Close()
+	if err := l2.AddPoint(make([]float32, dim), 0, false); err != nil {
+		t.Errorf("expected a zero vector to be added in L2 space, got %v", err)
 	}
 }
 
-func TestReplacePoint(t *testing.T) {
-	allowRepaceDeleted := true
-	maxElements := 100
-	index := New(dim, M, efConstruction, 505, uint64(maxElements), Cosine, allowRepaceDeleted)
-	defer index.Free()
-
-	if !index.GetAllowReplaceDeleted() {
-		t.Fail()
+func TestStrictInput(t *testing.T) {
+	index, err := NewWithOptions(Options{Dim: dim, MaxElements: batchSize, SpaceType: IP, StrictInput: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
 	}
+	defer index.Close()
 
-	points, labels := randomPoints(dim, 0, maxElements)
-	index.AddPoints(points, labels, 1, false)
-
-	index.MarkDeleted(labels[len(labels)-1])
+	points, labels := randomPoints(dim, 0, batchSize)
+	if err := index.AddPoints(points[:batchSize-1], labels[:batchSize-1], 2, false); err != nil {
+		t.Fatalf("AddPoints failed: %v", err)
+	}
 
-	err := index.AddPoints([][]float32{randomPoint(dim)}, []uint64{math.MaxUint64 - 1}, 1, false)
-	if err == nil {
-		t.Fail()
+	invalid := slices.Clone(points[batchSize-1])
+	invalid[7] = float32(math.Inf(1))
+	err = index.AddPoints([][]float32{points[0], invalid}, []uint64{0, batchSize - 1}, 1, false)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "row 1") || !strings.Contains(err.Error(), "position 7") {
+		t.Errorf("expected an error naming row 1 and position 7, got %v", err)
 	}
 
-	err = index.AddPoints([][]float32{randomPoint(dim)}, []uint64{math.MaxUint64 - 1}, 1, true)
-	if err != nil {
-		t.Fail()
+	invalid[7] = float32(math.NaN())
+	if err := index.AddPoint(invalid, batchSize-1, false); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput from AddPoint, got %v", err)
+	}
+	if _, err := index.SearchKNN([][]float32{invalid}, 5, 1); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput from SearchKNN, got %v", err)
+	}
+	if count := index.GetCurrentCount(); count != batchSize-1 {
+		t.Errorf("expected nothing to be added, got count %d", count)
 	}
 
+	// a zero vector is finite.
+	if _, err := index.SearchKNN([][]float32{make([]float32, dim)}, 5, 1); err != nil {
+		t.Errorf("expected a zero vector to be searched in IP space, got %v", err)
+	}
 }
 
-func TestVectorSearch(t *testing.T) {
-	// Test 1: Basic search with valid index
-	t.Run("BasicSearch", func(t *testing.T) {
-		index := newTestIndex(1, false)
-		index.SetEf(efConstruction)
-		defer index.Free()
-
-		query := genQuery(dim, 10)
-		topK := 5
-
-		result, err := index.SearchKNN(query, topK, 1)
-		if err != nil {
-			t.Errorf("SearchKNN failed: %v", err)
-			return
-		}
+func TestGetDataByLabelInto(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
 
-		if len(result) != len(query) {
-			t.Errorf("expected %d results, got %d", len(query), len(result))
+	dst := make([]float32, dim+1)
+	dst[dim] = 42
+	for label := uint64(0); label < batchSize; label++ {
+		if err := index.GetDataByLabelInto(label, dst); err != nil {
+			t.Fatalf("GetDataByLabelInto failed: %v", err)
 		}
-
-		for i, rv := range result {
-			if len(rv) != topK {
-				t.Errorf("query %d: expected %d results, got %d", i, topK, len(rv))
-			}
+		expected, _ := index.GetDataByLabel(label)
+		if !slices.Equal(dst[:dim], expected) {
+			t.Fatalf("label %d: expected the vector of GetDataByLabel", label)
 		}
-	})
-
-	// Test 2: Verify distances are in ascending order
-	t.Run("SortedDistances", func(t *testing.T) {
-		index := newTestIndex(1, false)
-		index.SetEf(efConstruction)
-		defer index.Free()
+	}
+	if dst[dim] != 42 {
+		t.Error("expected the floats past the dimension to be left untouched")
+	}
 
-		query := genQuery(dim, 1)
-		result, err := index.SearchKNN(query, 5, 1)
-		if err != nil {
-			t.Errorf("SearchKNN failed: %v", err)
-			return
+	if err := index.GetDataByLabelInto(0, dst[:dim-1]); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a short buffer, got %v", err)
+	}
+	index.MarkDeleted(1)
+	for _, label := range []uint64{1, batchSize} {
+		if err := index.GetDataByLabelInto(label, dst); !errors.Is(err, ErrLabelNotFound) {
+			t.Errorf("label %d: expected ErrLabelNotFound, got %v", label, err)
 		}
+	}
 
-		for i, rv := range result {
-			for j := 1; j < len(rv); j++ {
-				if rv[j].Distance < rv[j-1].Distance {
-					t.Errorf("query %d: distances not sorted at position %d: %f > %f",
-						i, j, rv[j-1].Distance, rv[j].Distance)
-				}
-			}
-		}
+	allocs := testing.AllocsPerRun(100, func() {
+		index.GetDataByLabelInto(0, dst)
 	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation, got %v", allocs)
+	}
+}
 
-	// Test 3: Edge case - k larger than maxElements returns error (expected behavior)
-	t.Run("KExceedsElements", func(t *testing.T) {
-		index := newTestIndex(1, false) // 100 elements
-		index.SetEf(efConstruction)
-		defer index.Free()
-
-		query := genQuery(dim, 1)
-		// Request more than available - library returns error
-		_, err := index.SearchKNN(query, 200, 1)
-		if err == nil {
-			t.Errorf("expected error when k > maxElements, got nil")
-		}
-	})
+func TestSearchKNNVariadic(t *testing.T) {
+	index := newTestIndex(t, 2, false)
+	index.SetDefaultConcurrency(4)
+	defer index.Close()
 
-	// Test 4: Edge case - search with k=1
-	t.Run("SingleK", func(t *testing.T) {
-		index := newTestIndex(1, false)
-		index.SetEf(efConstruction)
-		defer index.Free()
+	vectors := genQuery(dim, 40)
+	queries := make([]Query, len(vectors))
+	for i, vec := range vectors {
+		queries[i] = Query{Vector: vec, TopK: 1 + i%7*5}
+	}
 
-		query := genQuery(dim, 1)
-		result, err := index.SearchKNN(query, 1, 1)
-		if err != nil {
-			t.Errorf("SearchKNN failed: %v", err)
-			return
+	results, err := index.SearchKNNVariadic(queries)
+	if err != nil {
+		t.Fatalf("SearchKNNVariadic failed: %v", err)
+	}
+	if len(results) != len(queries) {
+		t.Fatalf("expected %d rows, got %d", len(queries), len(results))
+	}
+	for i, q := range queries {
+		expected, _ := index.SearchKNN([][]float32{q.Vector}, q.TopK, 1)
+		if len(results[i]) != q.TopK {
+			t.Fatalf("row %d: expected %d results, got %d", i, q.TopK, len(results[i]))
 		}
-
-		if len(result[0]) != 1 {
-			t.Errorf("expected 1 result, got %d", len(result[0]))
+		for j := range results[i] {
+			if *results[i][j] != *expected[0][j] {
+				t.Fatalf("row %d: expected the results of SearchKNN with topK %d", i, q.TopK)
+			}
 		}
-	})
-
-	// Test 5: Verify results are labeled (not empty labels)
-	t.Run("ValidLabels", func(t *testing.T) {
-		index := newTestIndex(1, false)
-		index.SetEf(efConstruction)
-		defer index.Free()
+	}
 
-		query := genQuery(dim, 1)
-		result, err := index.SearchKNN(query, 5, 1)
-		if err != nil {
-			t.Errorf("SearchKNN failed: %v", err)
-			return
+	for _, c := range []struct {
+		name     string
+		query    Query
+		expected error
+	}{
+		{"dimension", Query{Vector: vectors[0][:dim-1], TopK: 5}, ErrDimMismatch},
+		{"topK", Query{Vector: vectors[0], TopK: 0}, ErrInvalidInput},
+		{"empty", Query{TopK: 5}, ErrInvalidInput},
+	} {
+		_, err := index.SearchKNNVariadic([]Query{queries[0], c.query})
+		if !errors.Is(err, c.expected) || !strings.Contains(err.Error(), "row 1") {
+			t.Errorf("%s: expected an error naming row 1 wrapping %v, got %v", c.name, c.expected, err)
 		}
+	}
+}
 
-		for i, rv := range result {
-			for j, r := range rv {
-				if r.Label == 0 {
-					t.Errorf("query %d: result %d has empty label", i, j)
-				}
+func TestKthDistance(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+
+	query := genQuery(dim, 5)
+	results, _ := index.SearchKNN(query, 10, 1)
+	for i, vec := range query {
+		for _, k := range []int{1, 5, 10} {
+			dist, err := index.KthDistance(vec, k)
+			if err != nil {
+				t.Fatalf("KthDistance failed: %v", err)
+			}
+			if dist != results[i][k-1].Distance {
+				t.Errorf("query %d: expected the distance of result %d, %v, got %v", i, k, results[i][k-1].Distance, dist)
 			}
 		}
-	})
-
-	// Test 6: Multiple queries in a single call
-	t.Run("MultipleQueries", func(t *testing.T) {
-		index := newTestIndex(3, false) // 300 elements
-		index.SetEf(efConstruction)
-		defer index.Free()
-
-		query := genQuery(dim, 50)
-		result, err := index.SearchKNN(query, 10, 1)
-		if err != nil {
-			t.Errorf("SearchKNN failed: %v", err)
-			return
-		}
+	}
 
-		if len(result) != 50 {
-			t.Errorf("expected 50 results, got %d", len(result))
-		}
-	})
+	searchAllocs := testing.AllocsPerRun(20, func() { index.SearchKNN(query[:1], 10, 1) })
+	kthAllocs := testing.AllocsPerRun(20, func() { index.KthDistance(query[0], 10) })
+	if kthAllocs >= searchAllocs {
+		t.Errorf("expected fewer allocations than SearchKNN, got %v >= %v", kthAllocs, searchAllocs)
+	}
 
+	index.MarkDeleted(0)
+	if _, err := index.KthDistance(query[0], batchSize); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput when fewer than k elements are found, got %v", err)
+	}
+	if _, err := index.KthDistance(query[0], 0); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for k 0, got %v", err)
+	}
+	if _, err := index.KthDistance(query[0][:dim-1], 1); !errors.Is(err, ErrDimMismatch) {
+		t.Errorf("expected ErrDimMismatch, got %v", err)
+	}
 }
 
 func TestGetVectorData(t *testing.T) {
 	// Test 1: Retrieve a known vector by label
 	t.Run("RetrieveKnownVector", func(t *testing.T) {
-		index := newTestIndex(1, false)
+		index := newTestIndex(t, 1, false)
 		index.SetEf(efConstruction)
 		defer index.Free()
 
 		// Label 0 was added first (see randomPoints starting at 0)
 		label := uint64(0)
-		vec := index.GetDataByLabel(label)
+		vec, err := index.GetDataByLabel(label)
+		if err != nil {
+			t.Fatalf("GetDataByLabel failed: %v", err)
+		}
 
 		if len(vec) != dim {
 			t.Errorf("expected vector dimension %d, got %d", dim, len(vec))
@@ -279,7 +2897,10 @@ func TestGetVectorData(t *testing.T) {
 
 	// Test 2: Verify retrieved vector matches original via search
 	t.Run("VerifyRetrievedMatchesOriginal", func(t *testing.T) {
-		index := New(dim, M, efConstruction, 55, uint64(400), Cosine, false)
+		index, err := New(dim, M, efConstruction, 55, uint64(400), Cosine, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
 		index.SetEf(efConstruction)
 		defer index.Free()
 
@@ -294,7 +2915,10 @@ func TestGetVectorData(t *testing.T) {
 		index.AddPoints(query, []uint64{testLabel}, 1, false)
 
 		// Retrieve it back
-		retrieved := index.GetDataByLabel(testLabel)
+		retrieved, err := index.GetDataByLabel(testLabel)
+		if err != nil {
+			t.Fatalf("GetDataByLabel failed: %v", err)
+		}
 
 		// Verify dimension matches
 		if len(retrieved) != dim {
@@ -311,48 +2935,117 @@ func TestGetVectorData(t *testing.T) {
 		}
 	})
 
-	// Test 3: Get dimension of returned vector
+	// Test 3: Round-trip a known vector through AddPoint. L2 space stores vectors as is.
+	t.Run("RoundTrip", func(t *testing.T) {
+		index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer index.Free()
+
+		vec := randomPoint(dim)
+		if err := index.AddPoint(vec, 7, false); err != nil {
+			t.Fatalf("AddPoint failed: %v", err)
+		}
+
+		retrieved, err := index.GetDataByLabel(7)
+		if err != nil {
+			t.Fatalf("GetDataByLabel failed: %v", err)
+		}
+		if !slices.Equal(vec, retrieved) {
+			t.Error("retrieved vector does not match the inserted one")
+		}
+	})
+
+	// Test 4: Get dimension of returned vector
 	t.Run("GetDimension", func(t *testing.T) {
-		index := newTestIndex(1, false)
+		index := newTestIndex(t, 1, false)
 		index.SetEf(efConstruction)
 		defer index.Free()
 
-		vec := index.GetDataByLabel(0)
+		vec, err := index.GetDataByLabel(0)
+		if err != nil {
+			t.Fatalf("GetDataByLabel failed: %v", err)
+		}
 		// Verify we got a vector of correct dimension
 		if len(vec) != dim {
 			t.Errorf("expected dimension %d, got %d", dim, len(vec))
 		}
 	})
 
-	// Test 4: Retrieve multiple vectors in sequence
+	// Test 5: Retrieve multiple vectors in sequence
 	t.Run("MultipleRetrievals", func(t *testing.T) {
-		index := newTestIndex(1, false)
+		index := newTestIndex(t, 1, false)
 		index.SetEf(efConstruction)
 		defer index.Free()
 
 		// Retrieve multiple existing vectors
 		for label := uint64(0); label < 5; label++ {
-			vec := index.GetDataByLabel(label)
+			vec, err := index.GetDataByLabel(label)
+			if err != nil {
+				t.Fatalf("label %d: GetDataByLabel failed: %v", label, err)
+			}
 			if len(vec) != dim {
 				t.Errorf("label %d: expected dimension %d, got %d", label, dim, len(vec))
 			}
 		}
 	})
 
-	// Test 5: Non-existent label should return zero valued vector.
+	// Test 6: Non-existent label should return an error.
 	t.Run("NonExistentLabelThrows", func(t *testing.T) {
-		index := newTestIndex(1, false)
+		index := newTestIndex(t, 1, false)
 		index.SetEf(efConstruction)
 		defer index.Free()
-		vec := index.GetDataByLabel(99999)
 
-		zeorVal := make([]float32, dim)
-		t.Log(vec)
-		if len(vec) != dim || !slices.Equal(zeorVal, vec) {
-			t.Error("should return zero value for label")
+		vec, err := index.GetDataByLabel(99999)
+		if err == nil || vec != nil {
+			t.Error("expected error for never inserted label")
+		}
+	})
+
+	// Test 7: Deleted label should return an error.
+	t.Run("DeletedLabel", func(t *testing.T) {
+		index := newTestIndex(t, 1, false)
+		index.SetEf(efConstruction)
+		defer index.Close()
+
+		index.MarkDeleted(1)
+		if _, err := index.GetDataByLabel(1); err == nil {
+			t.Error("expected error for deleted label")
 		}
 	})
 
+	// Test 8: Batch retrieval matches single retrievals and names the first missing label.
+	t.Run("BatchRetrieval", func(t *testing.T) {
+		index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer index.Close()
+
+		points, labels := randomPoints(dim, 0, 10)
+		index.AddPoints(points, labels, 1, false)
+
+		vectors, err := index.GetDataByLabels([]uint64{7, 2, 5})
+		if err != nil {
+			t.Fatalf("GetDataByLabels failed: %v", err)
+		}
+		for i, label := range []uint64{7, 2, 5} {
+			if !slices.Equal(vectors[i], points[label]) {
+				t.Errorf("row %d does not match the vector of label %d", i, label)
+			}
+		}
+
+		index.MarkDeleted(3)
+		_, err = index.GetDataByLabels([]uint64{1, 42, 3})
+		if err == nil || !strings.Contains(err.Error(), "label 42") {
+			t.Errorf("expected error naming label 42, got %v", err)
+		}
+		_, err = index.GetDataByLabels([]uint64{1, 3})
+		if err == nil || !strings.Contains(err.Error(), "label 3") {
+			t.Errorf("expected error naming deleted label 3, got %v", err)
+		}
+	})
 }
 
 func randomPoints(dim int, startLabel int, batchSize int) ([][]float32, []uint64) {
@@ -399,6 +3092,7 @@ func pathExists(path string) bool {
 }
 
 func deleteDB() error {
+	os.Remove(metadataPath(testVectorDB))
 	if pathExists(testVectorDB) {
 		return os.Remove(testVectorDB)
 	}
@@ -5,9 +5,38 @@
 #include <thread>
 #include <atomic>
 #include <vector>
+#include <cmath>
+#include <cstdio>
+#include <string>
+#include <new>
 
 
 static std::vector<std::vector<float>> convertTo2DVector(const float* flat_vectors, int rows, int cols);
+static SearchResult *newSearchResult(int rows, int k);
+
+// implemented in Go, see filter.go and progress.go.
+extern "C" int goFilterLabel(uintptr_t handle, size_t label);
+extern "C" void goAddProgress(uintptr_t handle, int done, int total);
+
+// message of the last exception caught by the wrapper on the calling thread, see hnswLastError.
+static thread_local std::string last_error;
+
+// log and keep the message of an exception caught in func before returning an error code to Go.
+static void setLastError(const char *func, const std::exception &e)
+{
+    std::cerr << "[hnsw] " << func << " exception: " << e.what() << std::endl;
+    last_error = e.what();
+}
+
+const char *hnswLastError()
+{
+    return last_error.empty() ? nullptr : last_error.c_str();
+}
+
+void hnswClearLastError()
+{
+    last_error.clear();
+}
 
 /*
  * replacement for the openmp '#pragma omp parallel for' directive
@@ -81,48 +110,170 @@ inline void ParallelFor(size_t start, size_t end, size_t numThreads, Function fn
 
 class CustomFilterFunctor : public hnswlib::BaseFilterFunctor
 {
-    std::function<bool(hnswlib::labeltype)> filter;
+    uintptr_t filter;
 
 public:
-    explicit CustomFilterFunctor(const std::function<bool(hnswlib::labeltype)> &f)
+    explicit CustomFilterFunctor(uintptr_t f)
     {
         filter = f;
     }
 
     bool operator()(hnswlib::labeltype id)
     {
-        return filter(id);
+        return goFilterLabel(filter, id) != 0;
     }
 };
 
-HnswIndex *newIndex(spaceType space_type, const int dim, size_t max_elements, int M, int ef_construction, int rand_seed, int allow_replace_deleted)
+// Manhattan distance.
+static float L1Distance(const void *pVect1v, const void *pVect2v, const void *qty_ptr)
 {
-    HnswIndex *index = new HnswIndex;
-    bool normalize = false;
-    hnswlib::SpaceInterface<float> *space;
-    if (space_type == l2)
+    const float *pVect1 = (const float *)pVect1v;
+    const float *pVect2 = (const float *)pVect2v;
+    size_t qty = *((const size_t *)qty_ptr);
+
+    float res = 0;
+    for (size_t i = 0; i < qty; i++) {
+        res += std::fabs(pVect1[i] - pVect2[i]);
+    }
+    return res;
+}
+
+// Chebyshev distance.
+static float LinfDistance(const void *pVect1v, const void *pVect2v, const void *qty_ptr)
+{
+    const float *pVect1 = (const float *)pVect1v;
+    const float *pVect2 = (const float *)pVect2v;
+    size_t qty = *((const size_t *)qty_ptr);
+
+    float res = 0;
+    for (size_t i = 0; i < qty; i++) {
+        res = std::max(res, std::fabs(pVect1[i] - pVect2[i]));
+    }
+    return res;
+}
+
+// A float space using a plain distance function, used for the spaces not shipped by hnswlib.
+class FuncSpace : public hnswlib::SpaceInterface<float>
+{
+    hnswlib::DISTFUNC<float> fstdistfunc_;
+    size_t data_size_;
+    size_t dim_;
+
+public:
+    FuncSpace(size_t dim, hnswlib::DISTFUNC<float> distfunc)
+    {
+        fstdistfunc_ = distfunc;
+        dim_ = dim;
+        data_size_ = dim * sizeof(float);
+    }
+
+    size_t get_data_size()
     {
-        space = new hnswlib::L2Space(dim);
+        return data_size_;
     }
-    else if (space_type == ip)
+
+    hnswlib::DISTFUNC<float> get_dist_func()
     {
-        space = new hnswlib::InnerProductSpace(dim);
+        return fstdistfunc_;
     }
-    else if (space_type == cosine)
+
+    void *get_dist_func_param()
     {
-        space = new hnswlib::InnerProductSpace(dim);
-        normalize = true;
+        return &dim_;
     }
-    else
+
+    ~FuncSpace() {}
+};
+
+// create the space of the space type, returns nullptr for unknown space types.
+static hnswlib::SpaceInterface<float> *newSpace(spaceType space_type, int dim)
+{
+    switch (space_type)
+    {
+    case l2:
+        return new hnswlib::L2Space(dim);
+    case ip:
+    case cosine:
+        return new hnswlib::InnerProductSpace(dim);
+    case l1:
+        return new FuncSpace(dim, L1Distance);
+    case linf:
+        return new FuncSpace(dim, LinfDistance);
+    default:
+        return nullptr;
+    }
+}
+
+const char *missingCpuFeature()
+{
+#if defined(__GNUC__) && (defined(__x86_64__) || defined(__i386__))
+    __builtin_cpu_init();
+#if defined(__SSE3__)
+    if (!__builtin_cpu_supports("sse3"))
+        return "sse3";
+#endif
+#if defined(__SSSE3__)
+    if (!__builtin_cpu_supports("ssse3"))
+        return "ssse3";
+#endif
+#if defined(__SSE4_1__)
+    if (!__builtin_cpu_supports("sse4.1"))
+        return "sse4.1";
+#endif
+#if defined(__SSE4_2__)
+    if (!__builtin_cpu_supports("sse4.2"))
+        return "sse4.2";
+#endif
+#if defined(__POPCNT__)
+    if (!__builtin_cpu_supports("popcnt"))
+        return "popcnt";
+#endif
+#if defined(__AVX__)
+    if (!__builtin_cpu_supports("avx"))
+        return "avx";
+#endif
+#if defined(__AVX2__)
+    if (!__builtin_cpu_supports("avx2"))
+        return "avx2";
+#endif
+#if defined(__FMA__)
+    if (!__builtin_cpu_supports("fma"))
+        return "fma";
+#endif
+#if defined(__AVX512F__)
+    if (!__builtin_cpu_supports("avx512f"))
+        return "avx512f";
+#endif
+#endif
+    return nullptr;
+}
+
+HnswIndex *newIndex(spaceType space_type, const int dim, size_t max_elements, int M, int ef_construction, int rand_seed, int allow_replace_deleted)
+{
+    bool normalize = space_type == cosine;
+    hnswlib::SpaceInterface<float> *space = newSpace(space_type, dim);
+    if (space == nullptr)
     {
-        throw std::runtime_error("Space name must be one of l2, ip, or cosine.");
+        std::cerr << "[hnsw] Space name must be one of l2, ip, cosine, l1 or linf." << std::endl;
+        return nullptr;
     }
 
-    hnswlib::HierarchicalNSW<float> *appr_alg = new hnswlib::HierarchicalNSW<float>(space, max_elements, M, ef_construction, rand_seed, static_cast<bool>(allow_replace_deleted));
+    hnswlib::HierarchicalNSW<float> *appr_alg;
+    try {
+        appr_alg = new hnswlib::HierarchicalNSW<float>(space, max_elements, M, ef_construction, rand_seed, static_cast<bool>(allow_replace_deleted));
+    } catch (const std::exception& e) {
+        setLastError("newIndex", e);
+        delete space;
+        return nullptr;
+    }
 
+    HnswIndex *index = new HnswIndex;
     index->hnsw = (void *)appr_alg;
     index->dim = dim;
     index->normalize = normalize;
+    index->collect_metrics = 0;
+    index->read_only = 0;
+    index->visited_list_pool_size = 1;
     index->space = (void *)space;
     index->space_type = space_type;
     return index;
@@ -134,6 +285,12 @@ void setEf(HnswIndex *index, size_t ef)
     ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->ef_ = ef;
 }
 
+// get the query time ef value.
+size_t getEf(HnswIndex *index)
+{
+    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->ef_;
+}
+
 // Returns index file size in size_t.
 size_t indexFileSize(HnswIndex *index)
 {
@@ -141,39 +298,43 @@ size_t indexFileSize(HnswIndex *index)
 }
 
 // Save index to a file.
-void saveIndex(HnswIndex *index, char *location)
+int saveIndex(HnswIndex *index, char *location)
 {
-    ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->saveIndex(location);
+    try {
+        ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->saveIndex(location);
+    } catch (const std::exception& e) {
+        setLastError("saveIndex", e);
+        return 1;
+    }
+    return 0;
 }
 
 HnswIndex *loadIndex(char *location, spaceType space_type, int dim, size_t max_elements, int allow_replace_deleted)
 {
-    HnswIndex *index = new HnswIndex;
-    bool normalize = false;
-    hnswlib::SpaceInterface<float> *space;
-    if (space_type == l2)
-    {
-        space = new hnswlib::L2Space(dim);
-    }
-    else if (space_type == ip)
+    bool normalize = space_type == cosine;
+    hnswlib::SpaceInterface<float> *space = newSpace(space_type, dim);
+    if (space == nullptr)
     {
-        space = new hnswlib::InnerProductSpace(dim);
-    }
-    else if (space_type == cosine)
-    {
-        space = new hnswlib::InnerProductSpace(dim);
-        normalize = true;
-    }
-    else
-    {
-        throw std::runtime_error("Space name must be one of l2, ip, or cosine.");
+        std::cerr << "[hnsw] Space name must be one of l2, ip, cosine, l1 or linf." << std::endl;
+        return nullptr;
     }
 
-    hnswlib::HierarchicalNSW<float> *appr_alg = new hnswlib::HierarchicalNSW<float>(space, location, false, max_elements, static_cast<bool>(allow_replace_deleted));
+    hnswlib::HierarchicalNSW<float> *appr_alg;
+    try {
+        appr_alg = new hnswlib::HierarchicalNSW<float>(space, location, false, max_elements, static_cast<bool>(allow_replace_deleted));
+    } catch (const std::exception& e) {
+        setLastError("loadIndex", e);
+        delete space;
+        return nullptr;
+    }
 
+    HnswIndex *index = new HnswIndex;
     index->hnsw = (void *)appr_alg;
     index->dim = dim;
     index->normalize = normalize;
+    index->collect_metrics = 0;
+    index->read_only = 0;
+    index->visited_list_pool_size = 1;
     index->space = (void *)space;
     index->space_type = space_type;
     return index;
@@ -189,7 +350,43 @@ void normalize_vector(int dim, float *data, float *norm_array)
         norm_array[i] = data[i] * norm;
 }
 
-int addPoints(HnswIndex *index, const float *flat_vectors, int rows, size_t *labels, int num_threads, int replace_deleted)
+// number of inserted rows between two progress reports.
+static const size_t PROGRESS_INTERVAL = 1000;
+
+/*
+ * Reports insertion progress to a Go callback. Calls to the callback are serialized
+ * and the reported number of inserted rows is increasing.
+ */
+class ProgressReporter
+{
+    uintptr_t handle_;
+    size_t total_;
+    std::atomic<size_t> done_{0};
+    size_t reported_ = 0;
+    std::mutex lock_;
+
+public:
+    ProgressReporter(uintptr_t handle, size_t total) : handle_(handle), total_(total) {}
+
+    void step()
+    {
+        if (!handle_)
+            return;
+
+        size_t done = ++done_;
+        if (done % PROGRESS_INTERVAL != 0 && done != total_)
+            return;
+
+        std::unique_lock<std::mutex> lock(lock_);
+        size_t current = done_.load();
+        if (current > reported_) {
+            reported_ = current;
+            goAddProgress(handle_, (int)current, (int)total_);
+        }
+    }
+};
+
+int addPoints(HnswIndex *index, const float *flat_vectors, int rows, size_t *labels, int num_threads, int replace_deleted, uintptr_t progress)
 {
     // avoid using threads when the number of additions is small:
     if (rows <= num_threads * 4)
@@ -197,13 +394,15 @@ int addPoints(HnswIndex *index, const float *flat_vectors, int rows, size_t *lab
         num_threads = 1;
     }
 
-    std::vector<std::vector<float>> vectors = convertTo2DVector(flat_vectors, rows, index->dim);
+    ProgressReporter reporter(progress, rows);
 
     try {
+        std::vector<std::vector<float>> vectors = convertTo2DVector(flat_vectors, rows, index->dim);
         if (index->normalize == false) {
             ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
                 size_t id = *(labels + row);
                 ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->addPoint(vectors[row].data(), id, static_cast<bool>(replace_deleted));
+                reporter.step();
             });
             return 0;
         }
@@ -216,10 +415,11 @@ int addPoints(HnswIndex *index, const float *flat_vectors, int rows, size_t *lab
 
             size_t id = *(labels + row);
             ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->addPoint((void*)(norm_array.data() + start_idx), id, static_cast<bool>(replace_deleted)); 
+            reporter.step();
             });
 
     } catch (const std::exception& e) {
-        std::cerr << "[hnsw] Exception caught: " << e.what() << std::endl;
+        setLastError("addPoints", e);
         return 1; // Error code for C
     }
 
@@ -227,154 +427,1143 @@ int addPoints(HnswIndex *index, const float *flat_vectors, int rows, size_t *lab
   
 }
 
-void markDeleted(HnswIndex *index, size_t label)
+int updatePoint(HnswIndex *index, const float *vector, size_t label, float update_neighbor_probability)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    std::vector<float> data(vector, vector + index->dim);
+    if (index->normalize) {
+        normalize_vector(index->dim, data.data(), data.data());
+    }
+
+    // serialize with addPoint and markDelete on the same label.
+    std::unique_lock<std::mutex> lock_label(hnsw->getLabelOpMutex(label));
+
+    hnswlib::tableint internal_id;
+    {
+        std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+        auto search = hnsw->label_lookup_.find(label);
+        if (search == hnsw->label_lookup_.end() || hnsw->isMarkedDeleted(search->second)) {
+            return 1;
+        }
+        internal_id = search->second;
+    }
+
+    try {
+        hnsw->updatePoint(data.data(), internal_id, update_neighbor_probability);
+    } catch (const std::exception& e) {
+        setLastError("updatePoint", e);
+        return 2;
+    }
+    return 0;
+}
+
+int isMarkedDeleted(HnswIndex *index, size_t label)
 {
-    ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->markDelete(label);
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    auto search = hnsw->label_lookup_.find(label);
+    if (search == hnsw->label_lookup_.end()) {
+        return -1;
+    }
+
+    return hnsw->isMarkedDeleted(search->second) ? 1 : 0;
 }
 
-void unmarkDeleted(HnswIndex *index, size_t label)
+void getLabelStates(HnswIndex *index, const size_t *labels, int n, int *states)
 {
-    ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->unmarkDelete(label);
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    for (int i = 0; i < n; i++) {
+        auto search = hnsw->label_lookup_.find(labels[i]);
+        if (search == hnsw->label_lookup_.end()) {
+            states[i] = -1;
+        } else {
+            states[i] = hnsw->isMarkedDeleted(search->second) ? 1 : 0;
+        }
+    }
 }
 
-void resizeIndex(HnswIndex *index, size_t new_size)
+int containsLabel(HnswIndex *index, size_t label)
 {
-    ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->resizeIndex(new_size);
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    auto search = hnsw->label_lookup_.find(label);
+    if (search == hnsw->label_lookup_.end()) {
+        return 0;
+    }
+
+    return hnsw->isMarkedDeleted(search->second) ? 0 : 1;
 }
 
-size_t getMaxElements(HnswIndex *index)
+int getInternalId(HnswIndex *index, size_t label, unsigned int *internal_id)
 {
-    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->max_elements_;
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    auto search = hnsw->label_lookup_.find(label);
+    if (search == hnsw->label_lookup_.end()) {
+        return 0;
+    }
+
+    *internal_id = search->second;
+    return 1;
 }
 
-size_t getCurrentCount(HnswIndex *index)
+int getMaxLevel(HnswIndex *index)
 {
-    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->cur_element_count;
+    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->maxlevel_;
 }
 
-SearchResult *searchKnn(HnswIndex *index, const float *flat_vectors, int rows, int k, int num_threads)
+int getLevel(HnswIndex *index, size_t label, int *level)
 {
-    //CustomFilterFunctor idFilter(filter);
-    //CustomFilterFunctor *p_idFilter = filter ? &idFilter : nullptr;
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
 
-    // avoid using threads when the number of searches is small:
-    if (rows <= num_threads * 4)
-    {
-        num_threads = 1;
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    auto search = hnsw->label_lookup_.find(label);
+    if (search == hnsw->label_lookup_.end()) {
+        return 0;
     }
 
-    std::vector<std::vector<float>> vectors = convertTo2DVector(flat_vectors, rows, index->dim);
+    *level = hnsw->element_levels_[search->second];
+    return 1;
+}
 
-    SearchResult *searchResult = new SearchResult;
-    if (!searchResult) {
-        return nullptr; // Allocation failure
+int getNeighbors(HnswIndex *index, size_t label, int level, size_t *labels, int capacity)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    hnswlib::tableint id;
+    {
+        std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+        auto search = hnsw->label_lookup_.find(label);
+        if (search == hnsw->label_lookup_.end()) {
+            return -1;
+        }
+        id = search->second;
     }
-    searchResult->label = new hnswlib::labeltype[rows * k];
-    searchResult->dist = new float[rows * k];
-    if (!searchResult->label || !searchResult->dist) {
-        delete[] searchResult->label;
-        delete[] searchResult->dist;
-        delete searchResult;
-        return nullptr; // Allocation failure
+
+    // read-only indexes have no link list locks, and their links never change.
+    std::unique_lock<std::mutex> lock;
+    if (!index->read_only)
+        lock = std::unique_lock<std::mutex>(hnsw->link_list_locks_[id]);
+    if (level < 0 || level > hnsw->element_levels_[id]) {
+        return -2;
     }
 
+    unsigned int *data = (unsigned int *)hnsw->get_linklist_at_level(id, level);
+    int size = std::min((int)hnsw->getListCount(data), capacity);
+    hnswlib::tableint *links = (hnswlib::tableint *)(data + 1);
+    for (int i = 0; i < size; i++) {
+        labels[i] = hnsw->getExternalLabel(links[i]);
+    }
+    return size;
+}
 
-    try {
-        if (index->normalize == false) {
-            ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
-                std::priority_queue<std::pair<float, hnswlib::labeltype>> result =
-                    ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->searchKnn(vectors[row].data(), k, nullptr);
+int getLabelByInternalId(HnswIndex *index, unsigned int internal_id, size_t *label)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    if (internal_id >= hnsw->cur_element_count) {
+        return 0;
+    }
 
-                if (result.size() != (size_t)k)
-                    throw std::runtime_error("Cannot return the results in a contiguous 2D array. Probably ef or M is too small");
+    *label = hnsw->getExternalLabel(internal_id);
+    return 1;
+}
 
-                for (int i = k - 1; i >= 0; i--) {
-                    auto& result_tuple = result.top();
-                    *(searchResult->dist + row * k + i) = result_tuple.first;
-                    *(searchResult->label + row * k + i) = result_tuple.second;
-                    result.pop();
-                }
-            });
+// returns the position of the first label not found in the index, or -1 if all labels are found.
+static int findMissingLabel(hnswlib::HierarchicalNSW<float> *hnsw, const size_t *labels, int n)
+{
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    for (int i = 0; i < n; i++) {
+        if (hnsw->label_lookup_.find(labels[i]) == hnsw->label_lookup_.end()) {
+            return i;
+        }
+    }
+    return -1;
+}
 
-        } else {
-            std::vector<float> norm_array(num_threads * (index->dim));
-            ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
-                size_t start_idx = threadId * (index->dim);
-                normalize_vector((index->dim), vectors[row].data(), (norm_array.data() + start_idx));
+int markDeleted(HnswIndex *index, size_t label)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    if (findMissingLabel(hnsw, &label, 1) >= 0) {
+        return 1;
+    }
 
-                std::priority_queue<std::pair<float, hnswlib::labeltype>> result =
-                    ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->searchKnn((void*)(norm_array.data() + start_idx), k, nullptr);
+    try {
+        hnsw->markDelete(label);
+    } catch (const std::exception& e) {
+        return 2;
+    }
+    return 0;
+}
 
-                if (result.size() != (size_t)k)
-                    throw std::runtime_error("Cannot return the results in a contiguous 2D array. Probably ef or M is too small");
+int unmarkDeleted(HnswIndex *index, size_t label)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    if (findMissingLabel(hnsw, &label, 1) >= 0) {
+        return 1;
+    }
 
-                for (int i = k - 1; i >= 0; i--) {
-                    auto& result_tuple = result.top();
-                    *(searchResult->dist + row * k + i) = result_tuple.first;
-                    *(searchResult->label + row * k + i) = result_tuple.second;
-                    result.pop();
-                }
-            });
-        }
+    try {
+        hnsw->unmarkDelete(label);
     } catch (const std::exception& e) {
-        std::cerr << "[hnsw] searchKnn exception: " << e.what() << std::endl;
-        delete[] searchResult->label;
-        delete[] searchResult->dist;
-        delete searchResult;
-        return nullptr;
+        return 2;
     }
-
-    return searchResult;
+    return 0;
 }
 
-int getAllowReplaceDeleted(HnswIndex *index) {
-   return ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->allow_replace_deleted_;
-}
+int markDeletedBatch(HnswIndex *index, const size_t *labels, int n)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    int missing = findMissingLabel(hnsw, labels, n);
+    if (missing >= 0) {
+        return missing;
+    }
 
-void getDataByLabel(HnswIndex *index, const size_t label, float* data) {
-    try {
-        auto vec = ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->getDataByLabel<float>(label);
-        // Copy data to output buffer
-        for (size_t i = 0; i < vec.size(); i++) {
-            data[i] = vec[i];
+    for (int i = 0; i < n; i++) {
+        try {
+            hnsw->markDelete(labels[i]);
+        } catch (const std::exception& e) {
+            // already marked deleted, skip it.
         }
-    } catch (const std::exception& e) {
-        // Label not found or other error - return zeros
-        size_t dim = index->dim;
-        for (size_t i = 0; i < dim; i++) {
-            data[i] = 0.0f;
+    }
+    return -1;
+}
+
+int unmarkDeletedBatch(HnswIndex *index, const size_t *labels, int n)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    int missing = findMissingLabel(hnsw, labels, n);
+    if (missing >= 0) {
+        return missing;
+    }
+
+    for (int i = 0; i < n; i++) {
+        try {
+            hnsw->unmarkDelete(labels[i]);
+        } catch (const std::exception& e) {
+            // not marked deleted, skip it.
         }
     }
+    return -1;
 }
 
-void freeHNSW(HnswIndex *index)
+void clearIndex(HnswIndex *index)
 {
-    hnswlib::HierarchicalNSW<float> *ptr = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
-    delete ptr;
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
 
-    if (index->space_type == l2)
-    {
-        hnswlib::L2Space *space = (hnswlib::L2Space *)(index->space);
-        delete space;
+    // level 0 storage is reset by addPoint when a slot is reused, only the upper layer links are allocated per element.
+    size_t count = hnsw->cur_element_count;
+    for (size_t i = 0; i < count; i++) {
+        if (hnsw->element_levels_[i] > 0) {
+            free(hnsw->linkLists_[i]);
+            hnsw->linkLists_[i] = nullptr;
+        }
+        hnsw->element_levels_[i] = 0;
     }
-    else if (index->space_type == ip || index->space_type == cosine)
+
     {
-        hnswlib::InnerProductSpace *space = (hnswlib::InnerProductSpace *)(index->space);
-        delete space;
+        std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+        hnsw->label_lookup_.clear();
     }
-    else
     {
-        throw std::runtime_error("Space name must be one of l2, ip, or cosine.");
+        std::unique_lock<std::mutex> lock_deleted(hnsw->deleted_elements_lock);
+        hnsw->deleted_elements.clear();
     }
 
-    delete index;
+    hnsw->cur_element_count = 0;
+    hnsw->num_deleted_ = 0;
+    hnsw->enterpoint_node_ = -1;
+    hnsw->maxlevel_ = -1;
+    hnsw->metric_distance_computations = 0;
+    hnsw->metric_hops = 0;
 }
 
-void freeResult(SearchResult *result)
+int resizeIndex(HnswIndex *index, size_t new_size)
 {
-    delete[] result->label;
-    delete[] result->dist;
-    delete result;
+    try {
+        hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+        hnsw->resizeIndex(new_size);
+        // hnswlib replaces the pool by one with a single list sized for the new capacity.
+        if (index->visited_list_pool_size > 1) {
+            hnsw->visited_list_pool_.reset(new hnswlib::VisitedListPool(index->visited_list_pool_size, new_size));
+        }
+    } catch (const std::exception& e) {
+        setLastError("resizeIndex", e);
+        return 1;
+    }
+    return 0;
+}
+
+int setVisitedListPoolSize(HnswIndex *index, int size)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    try {
+        hnsw->visited_list_pool_.reset(new hnswlib::VisitedListPool(size, hnsw->max_elements_));
+    } catch (const std::exception& e) {
+        setLastError("setVisitedListPoolSize", e);
+        return 1;
+    }
+    index->visited_list_pool_size = size;
+    return 0;
+}
+
+int getVisitedListPoolSize(HnswIndex *index)
+{
+    return index->visited_list_pool_size;
+}
+
+void setCollectMetrics(HnswIndex *index, int enable)
+{
+    index->collect_metrics = enable;
+}
+
+void getSearchMetrics(HnswIndex *index, uint64_t *distance_computations, uint64_t *hops)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    *distance_computations = hnsw->metric_distance_computations;
+    *hops = hnsw->metric_hops;
+}
+
+size_t getMaxElements(HnswIndex *index)
+{
+    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->max_elements_;
+}
+
+size_t getCurrentCount(HnswIndex *index)
+{
+    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->cur_element_count;
+}
+
+size_t getDeletedCount(HnswIndex *index)
+{
+    return ((hnswlib::HierarchicalNSW<float> *)(index->hnsw))->num_deleted_;
+}
+
+int verifyIndex(HnswIndex *index, char *msg, size_t msg_size)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    size_t count = hnsw->cur_element_count;
+
+    if (count > hnsw->max_elements_) {
+        snprintf(msg, msg_size, "element count %zu exceeds max elements %zu", count, hnsw->max_elements_);
+        return 1;
+    }
+    if (hnsw->label_lookup_.size() != count) {
+        snprintf(msg, msg_size, "label map holds %zu labels for %zu elements", hnsw->label_lookup_.size(), count);
+        return 1;
+    }
+    if (count == 0) {
+        return 0;
+    }
+
+    if (hnsw->maxlevel_ < 0 || (size_t)hnsw->enterpoint_node_ >= count) {
+        snprintf(msg, msg_size, "invalid entry point %u at level %d", hnsw->enterpoint_node_, hnsw->maxlevel_);
+        return 1;
+    }
+    if (hnsw->element_levels_[hnsw->enterpoint_node_] != hnsw->maxlevel_) {
+        snprintf(msg, msg_size, "entry point %u is at level %d, not at the top level %d",
+                 hnsw->enterpoint_node_, hnsw->element_levels_[hnsw->enterpoint_node_], hnsw->maxlevel_);
+        return 1;
+    }
+
+    size_t deleted = 0;
+    for (hnswlib::tableint id = 0; id < count; id++) {
+        hnswlib::labeltype label = hnsw->getExternalLabel(id);
+        auto search = hnsw->label_lookup_.find(label);
+        if (search == hnsw->label_lookup_.end() || search->second != id) {
+            snprintf(msg, msg_size, "label %zu of element %u is not mapped to it", (size_t)label, id);
+            return 1;
+        }
+        if (hnsw->isMarkedDeleted(id)) {
+            deleted++;
+        }
+
+        int level = hnsw->element_levels_[id];
+        if (level < 0 || level > hnsw->maxlevel_) {
+            snprintf(msg, msg_size, "element %u has invalid level %d", id, level);
+            return 1;
+        }
+
+        for (int l = 0; l <= level; l++) {
+            unsigned int *data = (unsigned int *)hnsw->get_linklist_at_level(id, l);
+            size_t size = hnsw->getListCount(data);
+            size_t max_size = l == 0 ? hnsw->maxM0_ : hnsw->maxM_;
+            if (size > max_size) {
+                snprintf(msg, msg_size, "element %u has %zu links at level %d, at most %zu are allowed", id, size, l, max_size);
+                return 1;
+            }
+
+            hnswlib::tableint *links = (hnswlib::tableint *)(data + 1);
+            for (size_t j = 0; j < size; j++) {
+                if ((size_t)links[j] >= count) {
+                    snprintf(msg, msg_size, "element %u links to unknown element %u at level %d", id, links[j], l);
+                    return 1;
+                }
+                if (hnsw->element_levels_[links[j]] < l) {
+                    snprintf(msg, msg_size, "element %u links to element %u at level %d, above its level %d",
+                             id, links[j], l, hnsw->element_levels_[links[j]]);
+                    return 1;
+                }
+            }
+        }
+    }
+
+    if (deleted != hnsw->num_deleted_) {
+        snprintf(msg, msg_size, "%zu elements are marked deleted, but the deleted count is %zu", deleted, (size_t)hnsw->num_deleted_);
+        return 1;
+    }
+
+    return 0;
+}
+
+int prewarmIndex(HnswIndex *index, size_t count, int num_threads, int searches)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    if (count == 0) {
+        return 0;
+    }
+
+    try {
+        // read a byte of every page of the level 0 storage in use and of the upper layer links, the sum is only
+        // kept so that the reads are not optimized out.
+        const size_t page_size = 4096;
+        size_t level0_size = count * hnsw->size_data_per_element_;
+        size_t pages = (level0_size + page_size - 1) / page_size;
+        std::atomic<size_t> sum(0);
+        ParallelFor(0, pages, num_threads, [&](size_t page, size_t threadId) {
+            sum.fetch_add(hnsw->data_level0_memory_[page * page_size], std::memory_order_relaxed);
+        });
+        ParallelFor(0, count, num_threads, [&](size_t i, size_t threadId) {
+            size_t links_size = hnsw->size_links_per_element_ * hnsw->element_levels_[i];
+            for (size_t offset = 0; offset < links_size; offset += page_size) {
+                sum.fetch_add(hnsw->linkLists_[i][offset], std::memory_order_relaxed);
+            }
+        });
+        volatile size_t sink = sum.load();
+        (void)sink;
+
+        // search the vectors of elements spread over the storage, which also fills the visited list pool with a
+        // list per thread.
+        size_t step = std::max<size_t>(count / std::max(searches, 1), 1);
+        ParallelFor(0, std::min<size_t>(searches, count), num_threads, [&](size_t i, size_t threadId) {
+            hnsw->searchKnn(hnsw->getDataByInternalId(i * step), 1);
+        });
+    } catch (const std::exception& e) {
+        setLastError("prewarmIndex", e);
+        return 1;
+    }
+    return 0;
+}
+
+size_t memoryUsage(HnswIndex *index)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+    size_t max_elements = hnsw->max_elements_;
+
+    // level 0 links, vectors and labels are preallocated for max_elements_.
+    size_t total = max_elements * hnsw->size_data_per_element_;
+    // per element pointers to the upper layer links, levels and locks.
+    total += max_elements * (sizeof(char *) + sizeof(int));
+    total += hnsw->link_list_locks_.size() * sizeof(std::mutex);
+    total += hnsw->label_op_locks_.size() * sizeof(std::mutex);
+    // upper layer links are allocated on insertion according to the level of each element.
+    size_t count = hnsw->cur_element_count;
+    for (size_t i = 0; i < count; i++) {
+        if (hnsw->element_levels_[i] > 0)
+            total += hnsw->size_links_per_element_ * hnsw->element_levels_[i];
+    }
+
+    // hash map nodes (key, value and next pointer) plus the bucket array. The actual overhead depends on the
+    // standard library implementation.
+    {
+        std::unique_lock<std::mutex> lock(hnsw->label_lookup_lock);
+        total += hnsw->label_lookup_.size() * (sizeof(std::pair<hnswlib::labeltype, hnswlib::tableint>) + sizeof(void *));
+        total += hnsw->label_lookup_.bucket_count() * sizeof(void *);
+    }
+    {
+        std::unique_lock<std::mutex> lock(hnsw->deleted_elements_lock);
+        total += hnsw->deleted_elements.size() * (sizeof(hnswlib::tableint) + sizeof(void *));
+        total += hnsw->deleted_elements.bucket_count() * sizeof(void *);
+    }
+
+    // the pool holds at least one visited list, one more is allocated for each concurrent search.
+    total += max_elements * sizeof(hnswlib::vl_type);
+
+    return total + sizeof(hnswlib::HierarchicalNSW<float>);
+}
+
+size_t getLabels(HnswIndex *index, size_t *cursor, size_t end, size_t *labels, size_t capacity)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)(index->hnsw);
+
+    // elements at or after end may be being inserted, hnswlib counts them before writing their label.
+    end = std::min(end, (size_t)hnsw->cur_element_count);
+    size_t n = 0;
+    size_t id = *cursor;
+    for (; id < end && n < capacity; id++) {
+        if (!hnsw->isMarkedDeleted(id)) {
+            labels[n++] = hnsw->getExternalLabel(id);
+        }
+    }
+
+    *cursor = id;
+    return n;
+}
+
+// same as HierarchicalNSW::searchKnn, except that the base layer search collects metrics, which hnswlib
+// only does for the upper layers.
+static std::priority_queue<std::pair<float, hnswlib::labeltype>> searchKnnWithMetrics(hnswlib::HierarchicalNSW<float> *hnsw, const void *query, size_t k)
+{
+    std::priority_queue<std::pair<float, hnswlib::labeltype>> result;
+    if (hnsw->cur_element_count == 0)
+        return result;
+
+    hnswlib::tableint currObj = hnsw->enterpoint_node_;
+    float curdist = hnsw->fstdistfunc_(query, hnsw->getDataByInternalId(currObj), hnsw->dist_func_param_);
+
+    for (int level = hnsw->maxlevel_; level > 0; level--) {
+        bool changed = true;
+        while (changed) {
+            changed = false;
+            unsigned int *data = (unsigned int *)hnsw->get_linklist(currObj, level);
+            int size = hnsw->getListCount(data);
+            hnsw->metric_hops++;
+            hnsw->metric_distance_computations += size;
+
+            hnswlib::tableint *datal = (hnswlib::tableint *)(data + 1);
+            for (int i = 0; i < size; i++) {
+                hnswlib::tableint cand = datal[i];
+                float d = hnsw->fstdistfunc_(query, hnsw->getDataByInternalId(cand), hnsw->dist_func_param_);
+                if (d < curdist) {
+                    curdist = d;
+                    currObj = cand;
+                    changed = true;
+                }
+            }
+        }
+    }
+
+    auto top_candidates = hnsw->searchBaseLayerST<false, true>(currObj, query, std::max(hnsw->ef_, k));
+    while (top_candidates.size() > k)
+        top_candidates.pop();
+    while (top_candidates.size() > 0) {
+        auto rez = top_candidates.top();
+        result.push(std::pair<float, hnswlib::labeltype>(rez.first, hnsw->getExternalLabel(rez.second)));
+        top_candidates.pop();
+    }
+    return result;
+}
+
+static inline std::priority_queue<std::pair<float, hnswlib::labeltype>> searchKnnQuery(HnswIndex *index, const void *query, size_t k)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    if (index->collect_metrics)
+        return searchKnnWithMetrics(hnsw, query, k);
+    return hnsw->searchKnn(query, k, nullptr);
+}
+
+static inline bool isCancelled(const int *cancel)
+{
+    return cancel != nullptr && __atomic_load_n(cancel, __ATOMIC_RELAXED) != 0;
+}
+
+SearchResult *searchKnn(HnswIndex *index, const float *flat_vectors, int rows, int k, int ef, int num_threads, const int *cancel)
+{
+    // hnswlib searches max(ef_, k) candidates, so asking for ef neighbors and keeping the k closest ones
+    // raises ef for this search only, without touching the ef_ field shared with concurrent searches.
+    size_t search_k = std::max(k, ef);
+
+    // avoid using threads when the number of searches is small:
+    if (rows <= num_threads * 4)
+    {
+        num_threads = 1;
+    }
+
+    std::vector<std::vector<float>> vectors = convertTo2DVector(flat_vectors, rows, index->dim);
+
+    SearchResult *searchResult = newSearchResult(rows, k);
+    if (!searchResult) {
+        return nullptr; // Allocation failure
+    }
+
+    try {
+        if (index->normalize == false) {
+            ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
+                if (isCancelled(cancel))
+                    return;
+
+                std::priority_queue<std::pair<float, hnswlib::labeltype>> result =
+                    searchKnnQuery(index, vectors[row].data(), search_k);
+                while (result.size() > (size_t)k)
+                    result.pop();
+
+                // hnswlib returns fewer than k results when the index holds fewer live elements.
+                int n = (int)result.size();
+                *(searchResult->count + row) = n;
+                for (int i = n - 1; i >= 0; i--) {
+                    auto& result_tuple = result.top();
+                    *(searchResult->dist + row * k + i) = result_tuple.first;
+                    *(searchResult->label + row * k + i) = result_tuple.second;
+                    result.pop();
+                }
+            });
+
+        } else {
+            std::vector<float> norm_array(num_threads * (index->dim));
+            ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
+                if (isCancelled(cancel))
+                    return;
+
+                size_t start_idx = threadId * (index->dim);
+                normalize_vector((index->dim), vectors[row].data(), (norm_array.data() + start_idx));
+
+                std::priority_queue<std::pair<float, hnswlib::labeltype>> result =
+                    searchKnnQuery(index, (void*)(norm_array.data() + start_idx), search_k);
+                while (result.size() > (size_t)k)
+                    result.pop();
+
+                // hnswlib returns fewer than k results when the index holds fewer live elements.
+                int n = (int)result.size();
+                *(searchResult->count + row) = n;
+                for (int i = n - 1; i >= 0; i--) {
+                    auto& result_tuple = result.top();
+                    *(searchResult->dist + row * k + i) = result_tuple.first;
+                    *(searchResult->label + row * k + i) = result_tuple.second;
+                    result.pop();
+                }
+            });
+        }
+    } catch (const std::exception& e) {
+        setLastError("searchKnn", e);
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    if (isCancelled(cancel)) {
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    return searchResult;
+}
+
+SearchResult *searchKnnVariadic(HnswIndex *index, const float *flat_vectors, int rows, const int *ks, int max_k, int num_threads)
+{
+    if (rows <= num_threads * 4)
+    {
+        num_threads = 1;
+    }
+
+    SearchResult *searchResult = newSearchResult(rows, max_k);
+    if (!searchResult) {
+        return nullptr;
+    }
+
+    try {
+        std::vector<float> norm_array(index->normalize ? num_threads * (index->dim) : 0);
+        ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
+            const float *query = flat_vectors + row * index->dim;
+            if (index->normalize) {
+                float *normalized = norm_array.data() + threadId * (index->dim);
+                normalize_vector((index->dim), (float *)query, normalized);
+                query = normalized;
+            }
+
+            std::priority_queue<std::pair<float, hnswlib::labeltype>> result = searchKnnQuery(index, query, ks[row]);
+            int n = (int)result.size();
+            *(searchResult->count + row) = n;
+            for (int i = n - 1; i >= 0; i--) {
+                auto& result_tuple = result.top();
+                *(searchResult->dist + row * max_k + i) = result_tuple.first;
+                *(searchResult->label + row * max_k + i) = result_tuple.second;
+                result.pop();
+            }
+        });
+    } catch (const std::exception& e) {
+        setLastError("searchKnnVariadic", e);
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    return searchResult;
+}
+
+SearchResult *searchKnnWithVectors(HnswIndex *index, const float *vector, int k, int num_threads, float *vectors)
+{
+    SearchResult *searchResult = searchKnn(index, vector, 1, k, 0, num_threads, nullptr);
+    if (!searchResult) {
+        return nullptr;
+    }
+
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    for (int i = 0; i < searchResult->count[0]; i++) {
+        auto search = hnsw->label_lookup_.find(searchResult->label[i]);
+        if (search == hnsw->label_lookup_.end()) {
+            continue;
+        }
+        memcpy(vectors + (size_t)i * index->dim, hnsw->getDataByInternalId(search->second), index->dim * sizeof(float));
+    }
+
+    return searchResult;
+}
+
+// distance of the space type computed in double precision, the hnswlib kernels accumulate in float.
+static float exactDistance(spaceType space_type, const float *a, const float *b, size_t dim)
+{
+    double res = 0;
+    for (size_t i = 0; i < dim; i++) {
+        double diff = (double)a[i] - (double)b[i];
+        switch (space_type) {
+        case l2:
+            res += diff * diff;
+            break;
+        case ip:
+        case cosine:
+            res += (double)a[i] * (double)b[i];
+            break;
+        case l1:
+            res += std::fabs(diff);
+            break;
+        case linf:
+            res = std::max(res, std::fabs(diff));
+            break;
+        }
+    }
+
+    if (space_type == ip || space_type == cosine) {
+        return (float)(1.0 - res);
+    }
+    return (float)res;
+}
+
+SearchResult *searchKnnRescore(HnswIndex *index, const float *vector, int k, int candidate_k, int num_threads)
+{
+    SearchResult *candidates = searchKnn(index, vector, 1, candidate_k, 0, 1, nullptr);
+    if (!candidates) {
+        return nullptr;
+    }
+
+    SearchResult *searchResult = newSearchResult(1, k);
+    if (!searchResult) {
+        freeResult(candidates);
+        return nullptr;
+    }
+
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    int found = candidates->count[0];
+    if (found <= num_threads * 4) {
+        num_threads = 1;
+    }
+
+    try {
+        std::vector<float> query(vector, vector + index->dim);
+        if (index->normalize) {
+            normalize_vector(index->dim, query.data(), query.data());
+        }
+
+        std::vector<std::pair<float, hnswlib::labeltype>> rescored(found);
+        ParallelFor(0, found, num_threads, [&](size_t i, size_t threadId) {
+            hnswlib::labeltype label = candidates->label[i];
+            const float *data = nullptr;
+            {
+                std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+                auto search = hnsw->label_lookup_.find(label);
+                if (search != hnsw->label_lookup_.end()) {
+                    data = (const float *)hnsw->getDataByInternalId(search->second);
+                }
+            }
+            // keep the approximate distance of an element removed since the search.
+            float dist = data ? exactDistance(index->space_type, query.data(), data, index->dim) : candidates->dist[i];
+            rescored[i] = std::make_pair(dist, label);
+        });
+
+        std::sort(rescored.begin(), rescored.end());
+        int n = std::min(k, found);
+        *(searchResult->count) = n;
+        for (int i = 0; i < n; i++) {
+            *(searchResult->dist + i) = rescored[i].first;
+            *(searchResult->label + i) = rescored[i].second;
+        }
+    } catch (const std::exception& e) {
+        setLastError("searchKnnRescore", e);
+        freeResult(candidates);
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    freeResult(candidates);
+    return searchResult;
+}
+
+SearchResult *searchKnnFiltered(HnswIndex *index, const float *vector, int k, uintptr_t filter)
+{
+    CustomFilterFunctor idFilter(filter);
+    CustomFilterFunctor *p_idFilter = filter ? &idFilter : nullptr;
+
+    SearchResult *searchResult = newSearchResult(1, k);
+    if (!searchResult) {
+        return nullptr; // Allocation failure
+    }
+
+    std::vector<float> query(vector, vector + index->dim);
+    if (index->normalize) {
+        normalize_vector(index->dim, query.data(), query.data());
+    }
+
+    try {
+        std::priority_queue<std::pair<float, hnswlib::labeltype>> result =
+            ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->searchKnn(query.data(), k, p_idFilter);
+
+        // the filter may reject enough labels that fewer than k neighbors are found.
+        int found = (int)result.size();
+        *(searchResult->count) = found;
+        for (int i = found - 1; i >= 0; i--) {
+            auto& result_tuple = result.top();
+            *(searchResult->dist + i) = result_tuple.first;
+            *(searchResult->label + i) = result_tuple.second;
+            result.pop();
+        }
+    } catch (const std::exception& e) {
+        setLastError("searchKnnFiltered", e);
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    return searchResult;
+}
+
+SearchResult *searchRange(HnswIndex *index, const float *vector, float radius, int max_results)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+
+    SearchResult *searchResult = newSearchResult(1, max_results);
+    if (!searchResult) {
+        return nullptr; // Allocation failure
+    }
+
+    std::vector<float> query(vector, vector + index->dim);
+    if (index->normalize) {
+        normalize_vector(index->dim, query.data(), query.data());
+    }
+
+    try {
+        // explore at least ef candidates before stopping at the radius boundary.
+        size_t min_candidates = std::min(hnsw->ef_, (size_t)max_results);
+        hnswlib::EpsilonSearchStopCondition<float> stop_condition(radius, min_candidates, max_results);
+        std::vector<std::pair<float, hnswlib::labeltype>> result = hnsw->searchStopConditionClosest(query.data(), stop_condition);
+
+        // result is already ordered closest first, and holds internal ids despite its labeltype.
+        *(searchResult->count) = (int)result.size();
+        for (size_t i = 0; i < result.size(); i++) {
+            *(searchResult->dist + i) = result[i].first;
+            *(searchResult->label + i) = hnsw->getExternalLabel((hnswlib::tableint)result[i].second);
+        }
+    } catch (const std::exception& e) {
+        setLastError("searchRange", e);
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    return searchResult;
+}
+
+int getAllowReplaceDeleted(HnswIndex *index) {
+   return ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->allow_replace_deleted_;
+}
+
+void makeReadOnly(HnswIndex *index)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+
+    // the per element link list locks are only taken by insertions and updates.
+    std::vector<std::mutex>().swap(hnsw->link_list_locks_);
+    {
+        std::unique_lock<std::mutex> lock_deleted_elements(hnsw->deleted_elements_lock);
+        std::unordered_set<hnswlib::tableint>().swap(hnsw->deleted_elements);
+    }
+    hnsw->allow_replace_deleted_ = false;
+    index->read_only = 1;
+}
+
+void setAllowReplaceDeleted(HnswIndex *index, int allow_replace_deleted)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    std::unique_lock<std::mutex> lock_deleted_elements(hnsw->deleted_elements_lock);
+
+    // hnswlib only tracks the deleted elements available for replacement while replacement is allowed,
+    // so rebuild them from the deletion marks.
+    hnsw->deleted_elements.clear();
+    if (allow_replace_deleted) {
+        for (hnswlib::tableint id = 0; id < hnsw->cur_element_count; id++) {
+            if (hnsw->isMarkedDeleted(id))
+                hnsw->deleted_elements.insert(id);
+        }
+    }
+    hnsw->allow_replace_deleted_ = allow_replace_deleted != 0;
+}
+
+size_t getM(HnswIndex *index)
+{
+    return ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->M_;
+}
+
+size_t getEfConstruction(HnswIndex *index)
+{
+    return ((hnswlib::HierarchicalNSW<float> *)index->hnsw)->ef_construction_;
+}
+
+void setEfConstruction(HnswIndex *index, size_t ef_construction)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    // same as the hnswlib constructor.
+    hnsw->ef_construction_ = std::max(ef_construction, hnsw->M_);
+}
+
+int getDataByLabel(HnswIndex *index, const size_t label, float* data) {
+    // copy the vector directly, hnswlib getDataByLabel returns it in a new std::vector.
+    return getDataByLabels(index, &label, 1, data) == -1 ? 0 : 1;
+}
+
+int getDataByLabels(HnswIndex *index, const size_t *labels, int n, float *data)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    for (int i = 0; i < n; i++) {
+        auto search = hnsw->label_lookup_.find(labels[i]);
+        if (search == hnsw->label_lookup_.end() || hnsw->isMarkedDeleted(search->second)) {
+            return i;
+        }
+
+        memcpy(data + (size_t)i * index->dim, hnsw->getDataByInternalId(search->second), index->dim * sizeof(float));
+    }
+
+    return -1;
+}
+
+// look up the internal id of a label which is not marked deleted. Returns false if no such element.
+static bool lookupInternalId(hnswlib::HierarchicalNSW<float> *hnsw, size_t label, hnswlib::tableint *internal_id)
+{
+    std::unique_lock<std::mutex> lock_table(hnsw->label_lookup_lock);
+    auto search = hnsw->label_lookup_.find(label);
+    if (search == hnsw->label_lookup_.end() || hnsw->isMarkedDeleted(search->second)) {
+        return false;
+    }
+
+    *internal_id = search->second;
+    return true;
+}
+
+int distanceBetween(HnswIndex *index, const size_t label_a, const size_t label_b, float *dist)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+
+    hnswlib::tableint id_a, id_b;
+    if (!lookupInternalId(hnsw, label_a, &id_a) || !lookupInternalId(hnsw, label_b, &id_b)) {
+        return 1;
+    }
+
+    *dist = hnsw->fstdistfunc_(hnsw->getDataByInternalId(id_a), hnsw->getDataByInternalId(id_b), hnsw->dist_func_param_);
+    return 0;
+}
+
+int distanceToLabel(HnswIndex *index, const float *vector, const size_t label, float *dist)
+{
+    hnswlib::HierarchicalNSW<float> *hnsw = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+
+    hnswlib::tableint internal_id;
+    if (!lookupInternalId(hnsw, label, &internal_id)) {
+        return 1;
+    }
+
+    std::vector<float> query(vector, vector + index->dim);
+    if (index->normalize) {
+        normalize_vector(index->dim, query.data(), query.data());
+    }
+
+    *dist = hnsw->fstdistfunc_(query.data(), hnsw->getDataByInternalId(internal_id), hnsw->dist_func_param_);
+    return 0;
+}
+
+// free a space created by newSpace.
+static void freeSpace(spaceType space_type, HnswSpace ptr)
+{
+    if (space_type == l2)
+    {
+        hnswlib::L2Space *space = (hnswlib::L2Space *)ptr;
+        delete space;
+    }
+    else if (space_type == ip || space_type == cosine)
+    {
+        hnswlib::InnerProductSpace *space = (hnswlib::InnerProductSpace *)ptr;
+        delete space;
+    }
+    else if (space_type == l1 || space_type == linf)
+    {
+        FuncSpace *space = (FuncSpace *)ptr;
+        delete space;
+    }
+    else
+    {
+        throw std::runtime_error("Space name must be one of l2, ip, cosine, l1 or linf.");
+    }
+}
+
+void freeHNSW(HnswIndex *index)
+{
+    hnswlib::HierarchicalNSW<float> *ptr = (hnswlib::HierarchicalNSW<float> *)index->hnsw;
+    delete ptr;
+
+    freeSpace(index->space_type, index->space);
+    delete index;
+}
+
+BruteForceIndex *newBruteForce(spaceType space_type, const int dim, size_t max_elements)
+{
+    hnswlib::SpaceInterface<float> *space = newSpace(space_type, dim);
+    if (space == nullptr)
+    {
+        std::cerr << "[hnsw] Space name must be one of l2, ip, cosine, l1 or linf." << std::endl;
+        return nullptr;
+    }
+
+    hnswlib::BruteforceSearch<float> *alg;
+    try {
+        alg = new hnswlib::BruteforceSearch<float>(space, max_elements);
+    } catch (const std::exception& e) {
+        setLastError("newBruteForce", e);
+        delete space;
+        return nullptr;
+    }
+
+    BruteForceIndex *index = new BruteForceIndex;
+    index->bf = (void *)alg;
+    index->dim = dim;
+    index->normalize = space_type == cosine;
+    index->space = (void *)space;
+    index->space_type = space_type;
+    return index;
+}
+
+int bruteForceAddPoints(BruteForceIndex *index, const float *flat_vectors, int rows, size_t *labels)
+{
+    hnswlib::BruteforceSearch<float> *alg = (hnswlib::BruteforceSearch<float> *)index->bf;
+    std::vector<float> vector(index->dim);
+
+    try {
+        for (int row = 0; row < rows; row++) {
+            const float *data = flat_vectors + (size_t)row * index->dim;
+            if (index->normalize) {
+                normalize_vector(index->dim, (float *)data, vector.data());
+                data = vector.data();
+            }
+            alg->addPoint(data, labels[row]);
+        }
+    } catch (const std::exception& e) {
+        setLastError("bruteForceAddPoints", e);
+        return 1;
+    }
+    return 0;
+}
+
+SearchResult *bruteForceSearchKnn(BruteForceIndex *index, const float *flat_vectors, int rows, int k, int num_threads)
+{
+    hnswlib::BruteforceSearch<float> *alg = (hnswlib::BruteforceSearch<float> *)index->bf;
+
+    if (rows <= num_threads * 4)
+    {
+        num_threads = 1;
+    }
+
+    std::vector<std::vector<float>> vectors = convertTo2DVector(flat_vectors, rows, index->dim);
+
+    SearchResult *searchResult = newSearchResult(rows, k);
+    if (!searchResult) {
+        return nullptr;
+    }
+
+    // BruteforceSearch asserts that k does not exceed the element count.
+    size_t n = std::min((size_t)k, alg->cur_element_count);
+    try {
+        ParallelFor(0, rows, num_threads, [&](size_t row, size_t threadId) {
+            if (index->normalize) {
+                normalize_vector(index->dim, vectors[row].data(), vectors[row].data());
+            }
+
+            std::priority_queue<std::pair<float, hnswlib::labeltype>> result = alg->searchKnn(vectors[row].data(), n);
+            int found = (int)result.size();
+            *(searchResult->count + row) = found;
+            for (int i = found - 1; i >= 0; i--) {
+                auto& result_tuple = result.top();
+                *(searchResult->dist + row * k + i) = result_tuple.first;
+                *(searchResult->label + row * k + i) = result_tuple.second;
+                result.pop();
+            }
+        });
+    } catch (const std::exception& e) {
+        setLastError("bruteForceSearchKnn", e);
+        freeResult(searchResult);
+        return nullptr;
+    }
+
+    return searchResult;
+}
+
+size_t bruteForceGetMaxElements(BruteForceIndex *index)
+{
+    return ((hnswlib::BruteforceSearch<float> *)index->bf)->maxelements_;
+}
+
+size_t bruteForceGetCurrentCount(BruteForceIndex *index)
+{
+    return ((hnswlib::BruteforceSearch<float> *)index->bf)->cur_element_count;
+}
+
+void freeBruteForce(BruteForceIndex *index)
+{
+    hnswlib::BruteforceSearch<float> *ptr = (hnswlib::BruteforceSearch<float> *)index->bf;
+    delete ptr;
+
+    freeSpace(index->space_type, index->space);
+    delete index;
+}
+
+void freeResult(SearchResult *result)
+{
+    delete[] result->label;
+    delete[] result->dist;
+    delete[] result->count;
+    delete result;
+}
+
+static SearchResult *newSearchResult(int rows, int k)
+{
+    if (rows < 0 || k < 0) {
+        return nullptr;
+    }
+
+    SearchResult *searchResult = new (std::nothrow) SearchResult();
+    if (!searchResult) {
+        return nullptr; // Allocation failure
+    }
+    // computed in size_t, rows * k may not fit in an int.
+    size_t size = (size_t)rows * (size_t)k;
+    searchResult->rows = rows;
+    searchResult->k = k;
+    searchResult->label = new (std::nothrow) hnswlib::labeltype[size];
+    searchResult->dist = new (std::nothrow) float[size];
+    searchResult->count = new (std::nothrow) int[rows]();
+    if (!searchResult->label || !searchResult->dist || !searchResult->count) {
+        freeResult(searchResult);
+        return nullptr; // Allocation failure
+    }
+    return searchResult;
 }
 
 static std::vector<std::vector<float>> convertTo2DVector(const float* flat_vectors, int rows, int cols) {
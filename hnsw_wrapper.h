@@ -1,4 +1,10 @@
 // hnsw_wrapper.h
+#ifndef HNSW_WRAPPER_H
+#define HNSW_WRAPPER_H
+
+#include <stddef.h>
+#include <stdint.h>
+
 #ifdef __cplusplus
 extern "C"
 {
@@ -7,7 +13,7 @@ extern "C"
     typedef void *HNSW;
     typedef void *HnswSpace;
     typedef enum {
-        l2, ip, cosine
+        l2, ip, cosine, l1, linf
     } spaceType;
 
     // The index wrapper with some needed properties if initialized index.
@@ -18,39 +24,162 @@ extern "C"
         spaceType space_type;
         int dim;
         int normalize;
+        // non-zero if searches count distance computations and hops, see setCollectMetrics.
+        int collect_metrics;
+        // non-zero if the write side structures were freed by makeReadOnly.
+        int read_only;
+        // number of visited lists preallocated in the pool of hnswlib, see setVisitedListPoolSize.
+        int visited_list_pool_size;
     } HnswIndex;
 
-    //typedef bool (*filter_func)(int label);
-
-    // SearchResult holds the multi-vector search result. label and dist are flatted 2d vectors.
+    // SearchResult holds the multi-vector search result. label and dist are flatted 2d vectors of rows*k entries,
+    // count holds the number of neighbors found for each row, at most k.
     typedef struct
     {
         size_t *label;
         float *dist;
+        int *count;
+        int rows;
+        int k;
     } SearchResult;
 
+    // The brute force index wrapper, doing exact search with hnswlib::BruteforceSearch.
+    typedef struct
+    {
+        void *bf;
+        HnswSpace space;
+        spaceType space_type;
+        int dim;
+        int normalize;
+    } BruteForceIndex;
+
+    // returns the name of the first instruction set extension the wrapper was compiled for that the CPU does not
+    // support, or NULL if the CPU supports all of them or they cannot be detected.
+    const char *missingCpuFeature();
+
+    // returns the message of the last exception caught by a wrapper function on the calling thread, or NULL if none
+    // was caught since hnswClearLastError. Functions reporting failures by an error code or a NULL result store it.
+    const char *hnswLastError();
+    void hnswClearLastError();
+
     HnswIndex *newIndex(spaceType space_type, const int dim, size_t max_elements, int M, int ef_construction, int rand_seed, int allow_replace_deleted);
     void setEf(HnswIndex *index, size_t ef);
+    size_t getEf(HnswIndex *index);
     size_t indexFileSize(HnswIndex *index);
-    void saveIndex(HnswIndex *index, char *location);
+    // returns 0 on success, 1 if hnswlib failed to write the index.
+    int saveIndex(HnswIndex *index, char *location);
     HnswIndex *loadIndex(char *location, spaceType space_type, int dim, size_t max_elements, int allow_replace_deleted);
 
     // add multi-vectors and conresponding labels to index. Returning error codes to indicate error;
-    int addPoints(HnswIndex *index, const float *vectors, int rows, size_t *labels, int num_threads, int replace_deleted);
-    void markDeleted(HnswIndex *index, size_t label);
-    void unmarkDeleted(HnswIndex *index, size_t label);
-    void resizeIndex(HnswIndex *index, size_t new_size);
+    // progress is an optional handle of a Go progress callback, 0 means no progress is reported.
+    int addPoints(HnswIndex *index, const float *vectors, int rows, size_t *labels, int num_threads, int replace_deleted, uintptr_t progress);
+    // update the vector of an existing element in place, see hnswlib updatePoint. Returns 1 if the label is not
+    // found or is marked deleted, 2 if hnswlib failed to update, and 0 on success.
+    int updatePoint(HnswIndex *index, const float *vector, size_t label, float update_neighbor_probability);
+    // returns 1 if the label is marked deleted, 0 if it is not, and -1 if the label is not found.
+    int isMarkedDeleted(HnswIndex *index, size_t label);
+    // batch version of isMarkedDeleted, setting states[i] to the result for labels[i].
+    void getLabelStates(HnswIndex *index, const size_t *labels, int n, int *states);
+    // returns 1 if the label is stored in the index and not marked deleted.
+    int containsLabel(HnswIndex *index, size_t label);
+    // mark or unmark the element as deleted. Returns 1 if the label is not found, 2 if the element
+    // is already marked (or not marked) deleted, and 0 on success.
+    int markDeleted(HnswIndex *index, size_t label);
+    int unmarkDeleted(HnswIndex *index, size_t label);
+    // batch version of markDeleted and unmarkDeleted. Nothing is changed if any of the labels is not found,
+    // in which case the position of the first missing label is returned, otherwise -1 is returned.
+    int markDeletedBatch(HnswIndex *index, const size_t *labels, int n);
+    int unmarkDeletedBatch(HnswIndex *index, const size_t *labels, int n);
+    // remove all the elements, keeping the allocated capacity.
+    void clearIndex(HnswIndex *index);
+    // returns 0 on success, 1 if hnswlib failed to resize, e.g. new_size is less than the element count.
+    int resizeIndex(HnswIndex *index, size_t new_size);
+    // replace the visited list pool of hnswlib by one with size preallocated lists, which is kept across resizes.
+    // Returns 0 on success, 1 if the lists could not be allocated, in which case the pool is left unchanged.
+    int setVisitedListPoolSize(HnswIndex *index, int size);
+    int getVisitedListPoolSize(HnswIndex *index);
+    // make searchKnn count the distance computations and hops of the base layer too, which hnswlib skips by default.
+    void setCollectMetrics(HnswIndex *index, int enable);
+    // read the distance computations and hops counted by hnswlib since the index was created or loaded.
+    void getSearchMetrics(HnswIndex *index, uint64_t *distance_computations, uint64_t *hops);
     size_t getMaxElements(HnswIndex *index);
     size_t getCurrentCount(HnswIndex *index);
+    size_t getDeletedCount(HnswIndex *index);
+    // translate between external labels and hnswlib internal ids, deleted elements included.
+    // Return 1 if found, 0 otherwise.
+    int getInternalId(HnswIndex *index, size_t label, unsigned int *internal_id);
+    int getLabelByInternalId(HnswIndex *index, unsigned int internal_id, size_t *label);
+    // copy to labels the labels of at most capacity neighbors of the element with the label at the given level.
+    // Returns the number of neighbors copied, -1 if the label is not found and -2 if the element is not in that level.
+    int getNeighbors(HnswIndex *index, size_t label, int level, size_t *labels, int capacity);
+    // the top layer of the graph, -1 if the index is empty.
+    int getMaxLevel(HnswIndex *index);
+    // set level to the top layer of the element with the label, deleted elements included. Returns 1 if found, 0 otherwise.
+    int getLevel(HnswIndex *index, size_t label, int *level);
+    // check the consistency of the element count, label map and link lists. Returns 0 if no problem is found,
+    // otherwise 1 with the first problem described in msg.
+    int verifyIndex(HnswIndex *index, char *msg, size_t msg_size);
+    // estimate of the bytes of memory allocated by hnswlib for the index.
+    size_t memoryUsage(HnswIndex *index);
+    // read the memory of the vectors and links of all the elements, then run searches for the vectors of that many
+    // elements. Returns 0 on success, 1 if hnswlib failed.
+    int prewarmIndex(HnswIndex *index, size_t count, int num_threads, int searches);
+    // copy at most capacity labels of the elements not marked deleted to labels, starting from the internal id cursor
+    // and stopping before the id end. cursor is advanced past the last visited element. Returns the number of labels
+    // copied.
+    size_t getLabels(HnswIndex *index, size_t *cursor, size_t end, size_t *labels, size_t capacity);
     int getAllowReplaceDeleted(HnswIndex *index);
-    // SearchResult *searchKnn(HnswIndex *index, float **vectors, int rows, int k, filter_func filter, int num_threads);
-    SearchResult *searchKnn(HnswIndex *index, const float *flat_vectors, int rows, int k, int num_threads);
+    // enable or disable the replacement of deleted elements. All the elements marked deleted become available
+    // for replacement when it is enabled.
+    void setAllowReplaceDeleted(HnswIndex *index, int allow_replace_deleted);
+    // free the structures only needed to modify the index. Nothing but searches and getters must be called after.
+    void makeReadOnly(HnswIndex *index);
+    size_t getM(HnswIndex *index);
+    size_t getEfConstruction(HnswIndex *index);
+    // set the ef used when inserting elements, raised to at least M as done by hnswlib on construction.
+    void setEfConstruction(HnswIndex *index, size_t ef_construction);
+    // cancel is an optional flag checked before searching each row. The search is abandoned and NULL is returned
+    // once it is set to a non-zero value. ef is the minimum size of the candidate list for this search, 0 means
+    // the ef of the index is used.
+    SearchResult *searchKnn(HnswIndex *index, const float *flat_vectors, int rows, int k, int ef, int num_threads, const int *cancel);
+    // search the rows of flat_vectors for ks[row] neighbors each, max_k being the largest of ks and the number of
+    // columns of the result.
+    SearchResult *searchKnnVariadic(HnswIndex *index, const float *flat_vectors, int rows, const int *ks, int max_k, int num_threads);
+    // search a single vector and copy the stored vectors of the found neighbors to vectors, which must hold k*dim floats.
+    SearchResult *searchKnnWithVectors(HnswIndex *index, const float *vector, int k, int num_threads, float *vectors);
+    // search a single vector for candidate_k neighbors and return the k closest of them, with their distances to the
+    // stored vectors recomputed in double precision using num_threads.
+    SearchResult *searchKnnRescore(HnswIndex *index, const float *vector, int k, int candidate_k, int num_threads);
+    // search a single vector, only labels accepted by the Go filter referenced by the filter handle are returned.
+    SearchResult *searchKnnFiltered(HnswIndex *index, const float *vector, int k, uintptr_t filter);
+    // search a single vector for all neighbors within radius, at most max_results are returned.
+    SearchResult *searchRange(HnswIndex *index, const float *vector, float radius, int max_results);
 
     // Get the vector value mapped to label and return it by putting its value in data.
-    void getDataByLabel(HnswIndex *index, const size_t label, float *data);
+    // Returns a non-zero error code if the label is not found.
+    int getDataByLabel(HnswIndex *index, const size_t label, float *data);
+    // batch version of getDataByLabel, filling data with n rows of dim floats. Returns the position of the first
+    // label not found or marked deleted, or -1 if all the vectors are copied.
+    int getDataByLabels(HnswIndex *index, const size_t *labels, int n, float *data);
+    // Compute the distance between two stored elements using the space of the index.
+    // Returns a non-zero error code if any of the labels is not found.
+    int distanceBetween(HnswIndex *index, const size_t label_a, const size_t label_b, float *dist);
+    // Compute the distance between the vector and a stored element using the space of the index.
+    // Returns a non-zero error code if the label is not found.
+    int distanceToLabel(HnswIndex *index, const float *vector, const size_t label, float *dist);
     void freeHNSW(HnswIndex *index);
+
+    BruteForceIndex *newBruteForce(spaceType space_type, const int dim, size_t max_elements);
+    // add or update rows of vectors with the labels. Returns 0 on success, 1 on error.
+    int bruteForceAddPoints(BruteForceIndex *index, const float *vectors, int rows, size_t *labels);
+    SearchResult *bruteForceSearchKnn(BruteForceIndex *index, const float *flat_vectors, int rows, int k, int num_threads);
+    size_t bruteForceGetMaxElements(BruteForceIndex *index);
+    size_t bruteForceGetCurrentCount(BruteForceIndex *index);
+    void freeBruteForce(BruteForceIndex *index);
     void freeResult(SearchResult *result);
 
 #ifdef __cplusplus
 }
 #endif
+
+#endif // HNSW_WRAPPER_H
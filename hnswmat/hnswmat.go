@@ -0,0 +1,51 @@
+// Package hnswmat adds the rows of gonum matrices to a hnswgo index. It lives in its own package so that hnswgo
+// does not depend on gonum.
+package hnswmat
+
+import (
+	"fmt"
+
+	"github.com/oligo/hnswgo"
+	"gonum.org/v1/gonum/mat"
+)
+
+// AddPointsMatrix adds the rows of m to idx with the labels, converting the float64 values to float32, like
+// HnswIndex.AddPoints with one vector per row. m must have len(labels) rows and as many columns as the
+// dimension of idx. The rows are copied into a single buffer passed to HnswIndex.AddPointsFlat, read directly
+// from the backing array of matrices implementing mat.RawMatrixer such as *mat.Dense.
+func AddPointsMatrix(idx *hnswgo.HnswIndex, m mat.Matrix, labels []uint64, concurrency int, replaceDeleted bool) error {
+	rows, cols := m.Dims()
+	if rows != len(labels) {
+		return fmt.Errorf("%w: matrix has %d rows for %d labels", hnswgo.ErrInvalidInput, rows, len(labels))
+	}
+
+	// a closed index has no dimension, its error is returned by AddPointsFlat.
+	if dim := idx.Dim(); dim != 0 && cols != dim {
+		return fmt.Errorf("%w: got %d columns, want %d", hnswgo.ErrDimMismatch, cols, dim)
+	}
+
+	return idx.AddPointsFlat(flatten(m), labels, concurrency, replaceDeleted)
+}
+
+// flatten returns the values of m as float32, row after row.
+func flatten(m mat.Matrix) []float32 {
+	rows, cols := m.Dims()
+	flat := make([]float32, 0, rows*cols)
+
+	if raw, ok := m.(mat.RawMatrixer); ok {
+		general := raw.RawMatrix()
+		for i := 0; i < rows; i++ {
+			for _, v := range general.Data[i*general.Stride : i*general.Stride+cols] {
+				flat = append(flat, float32(v))
+			}
+		}
+		return flat
+	}
+
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			flat = append(flat, float32(m.At(i, j)))
+		}
+	}
+	return flat
+}
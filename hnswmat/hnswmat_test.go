@@ -0,0 +1,62 @@
+package hnswmat
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/oligo/hnswgo"
+	"gonum.org/v1/gonum/mat"
+)
+
+const dim = 16
+
+func TestAddPointsMatrix(t *testing.T) {
+	index, err := hnswgo.New(dim, 16, 100, 55, 100, hnswgo.L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	data := make([]float64, 20*(dim+4))
+	for i := range data {
+		data[i] = rand.Float64()
+	}
+	m := mat.NewDense(20, dim+4, data)
+
+	labels := make([]uint64, 10)
+	for i := range labels {
+		labels[i] = uint64(i)
+	}
+	// the view of the first columns has a stride larger than its columns.
+	if err := AddPointsMatrix(index, m.Slice(0, 10, 0, dim), labels, 2, false); err != nil {
+		t.Fatalf("AddPointsMatrix failed: %v", err)
+	}
+	// a transposed transpose does not expose its backing array and is read with At.
+	transposed := mat.Transpose{Matrix: m.Slice(10, 20, 0, dim).T()}
+	for i := range labels {
+		labels[i] += 10
+	}
+	if err := AddPointsMatrix(index, transposed, labels, 2, false); err != nil {
+		t.Fatalf("AddPointsMatrix failed: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		vec, err := index.GetDataByLabel(uint64(i))
+		if err != nil {
+			t.Fatalf("GetDataByLabel failed: %v", err)
+		}
+		for j, v := range vec {
+			if v != float32(m.At(i, j)) {
+				t.Fatalf("label %d: expected row %d of the matrix, got %v at column %d", i, i, v, j)
+			}
+		}
+	}
+
+	if err := AddPointsMatrix(index, m.Slice(0, 5, 0, dim), labels, 1, false); !errors.Is(err, hnswgo.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for unmatched rows and labels, got %v", err)
+	}
+	if err := AddPointsMatrix(index, mat.NewDense(1, dim+1, nil), labels[:1], 1, false); !errors.Is(err, hnswgo.ErrDimMismatch) {
+		t.Errorf("expected ErrDimMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package hnswgo
+
+import (
+	"fmt"
+)
+
+// MergePolicy tells Merge what to do with the labels of the merged index that are already in the index.
+type MergePolicy int
+
+const (
+	// MergeError makes Merge fail without adding any point if a label is in both indexes.
+	MergeError MergePolicy = iota
+	// MergeSkip keeps the vectors of the labels already in the index.
+	MergeSkip
+	// MergeOverwrite replaces the vectors of the labels already in the index with the merged ones.
+	MergeOverwrite
+)
+
+// Merge adds all the elements of other not marked deleted to the index, resolving the labels found in both
+// indexes, except for the ones marked deleted in the index, with policy. hnswlib has no way to merge two graphs,
+// so the vectors of other are inserted one by one as with AddPoints, which is as costly as building the index
+// from them. The index is resized first if needed so that all the vectors fit.
+//
+// An error is returned if the indexes differ in dimension or space type, or if idx is read-only. other is not
+// modified, and neither must be modified while they are merged.
+func (idx *HnswIndex) Merge(other *HnswIndex, policy MergePolicy) error {
+	if idx.index == nil || other.index == nil {
+		return ErrIndexClosed
+	}
+	if idx.readOnly() {
+		return ErrReadOnly
+	}
+	if idx == other {
+		return fmt.Errorf("%w: cannot merge an index into itself", ErrInvalidInput)
+	}
+	if idx.Dim() != other.Dim() {
+		return fmt.Errorf("%w: cannot merge index of dimension %d into index of dimension %d", ErrDimMismatch, other.Dim(), idx.Dim())
+	}
+	if idx.SpaceType() != other.SpaceType() {
+		return fmt.Errorf("%w: cannot merge index of space type %s into index of space type %s", ErrInvalidInput, other.SpaceType(), idx.SpaceType())
+	}
+	if policy < MergeError || policy > MergeOverwrite {
+		return fmt.Errorf("%w: unknown merge policy %d", ErrInvalidInput, policy)
+	}
+
+	if policy == MergeError {
+		var collision error
+		err := other.ForEachLabel(func(label uint64) bool {
+			if idx.ContainsLabel(label) {
+				collision = fmt.Errorf("%w: label %d is in both indexes", ErrInvalidInput, label)
+			}
+			return collision == nil
+		})
+		if err != nil {
+			return err
+		}
+		if collision != nil {
+			return collision
+		}
+	}
+
+	if required := idx.GetCurrentCount() + other.GetLiveCount(); required > idx.GetMaxElements() {
+		if err := idx.ResizeIndex(required); err != nil {
+			return err
+		}
+	}
+
+	var keep func(label uint64) bool
+	if policy == MergeSkip {
+		keep = func(label uint64) bool { return !idx.ContainsLabel(label) }
+	}
+	err := other.forEachLiveChunk(keep, func(labels []uint64, vectors [][]float32) error {
+		if err := idx.unmarkDeletedLabels(labels); err != nil {
+			return err
+		}
+		return idx.addFlat(flatten2DArray(vectors), labels, 0, false, 0)
+	})
+	if err != nil {
+		return fmt.Errorf("merge failed: %w", err)
+	}
+
+	return nil
+}
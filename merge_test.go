@@ -0,0 +1,90 @@
+package hnswgo
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	newShard := func(start, n int) (*HnswIndex, [][]float32) {
+		index, err := New(dim, M, efConstruction, 55, uint64(n), L2, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		points, labels := randomPoints(dim, start, n)
+		index.AddPoints(points, labels, 1, false)
+		return index, points
+	}
+
+	t.Run("disjoint", func(t *testing.T) {
+		a, _ := newShard(0, batchSize)
+		defer a.Close()
+		b, pointsB := newShard(batchSize, batchSize)
+		defer b.Close()
+		b.MarkDeleted(batchSize)
+
+		if err := a.Merge(b, MergeError); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+		if a.GetLiveCount() != 2*batchSize-1 {
+			t.Errorf("expected %d live elements, got %d", 2*batchSize-1, a.GetLiveCount())
+		}
+		if a.ContainsLabel(batchSize) {
+			t.Error("deleted element was merged")
+		}
+		if v, _ := a.GetDataByLabel(batchSize + 7); !slices.Equal(v, pointsB[7]) {
+			t.Error("merged vector does not match")
+		}
+		if b.GetCurrentCount() != batchSize {
+			t.Error("merged index was modified")
+		}
+	})
+
+	t.Run("collisions", func(t *testing.T) {
+		a, pointsA := newShard(0, batchSize)
+		defer a.Close()
+		// labels batchSize/2 to batchSize-1 are in both indexes.
+		b, pointsB := newShard(batchSize/2, batchSize)
+		defer b.Close()
+
+		if err := a.Merge(b, MergeError); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("expected ErrInvalidInput for colliding labels, got %v", err)
+		}
+		if a.GetCurrentCount() != batchSize {
+			t.Errorf("expected no point to be added on error, got %d elements", a.GetCurrentCount())
+		}
+
+		if err := a.Merge(b, MergeSkip); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+		if v, _ := a.GetDataByLabel(batchSize / 2); !slices.Equal(v, pointsA[batchSize/2]) {
+			t.Error("MergeSkip overwrote an existing vector")
+		}
+
+		if err := a.Merge(b, MergeOverwrite); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+		if v, _ := a.GetDataByLabel(batchSize / 2); !slices.Equal(v, pointsB[0]) {
+			t.Error("MergeOverwrite kept an existing vector")
+		}
+		if a.GetCurrentCount() != batchSize*3/2 {
+			t.Errorf("expected %d elements, got %d", batchSize*3/2, a.GetCurrentCount())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		a, _ := newShard(0, 10)
+		defer a.Close()
+		other, _ := New(dim, M, efConstruction, 55, 10, Cosine, false)
+		defer other.Close()
+		small, _ := New(dim/2, M, efConstruction, 55, 10, L2, false)
+		defer small.Close()
+
+		for name, b := range map[string]*HnswIndex{"self": a, "space type": other, "dimension": small} {
+			if err := a.Merge(b, MergeOverwrite); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package hnswgo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// metadataVersion is the version of the metadata file format written by Save.
+const metadataVersion = 1
+
+// indexMetadata holds the parameters of Load that are not stored in the hnswlib index file.
+type indexMetadata struct {
+	Version int `json:"version"`
+	// stored as the number of the SpaceType constant, which older versions expect.
+	SpaceType           int    `json:"space_type"`
+	Dim                 int    `json:"dim"`
+	MaxElements         uint64 `json:"max_elements"`
+	AllowReplaceDeleted bool   `json:"allow_replace_deleted"`
+	// random id of the save, checked by LoadWithDelta. Missing in metadata written by older versions.
+	SaveID uint64 `json:"save_id,omitempty"`
+}
+
+// metadataPath returns the path of the metadata file of the index saved at location.
+func metadataPath(location string) string {
+	return location + ".meta"
+}
+
+func (idx *HnswIndex) saveMetadata(path string, saveID uint64) error {
+	data, err := json.Marshal(indexMetadata{
+		Version:             metadataVersion,
+		SpaceType:           int(idx.SpaceType()),
+		Dim:                 idx.Dim(),
+		MaxElements:         idx.GetMaxElements(),
+		AllowReplaceDeleted: idx.GetAllowReplaceDeleted(),
+		SaveID:              saveID,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmpName := path + ".tmp"
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+func loadMetadata(path string) (indexMetadata, error) {
+	var meta indexMetadata
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("invalid index metadata %s: %w", path, err)
+	}
+	if meta.Version != metadataVersion {
+		return meta, fmt.Errorf("unsupported index metadata version %d in %s", meta.Version, path)
+	}
+	if meta.Dim <= 0 {
+		return meta, fmt.Errorf("invalid dimension %d in index metadata %s", meta.Dim, path)
+	}
+
+	return meta, nil
+}
+
+// LoadAuto loads an index saved by Save, reading the space type, dimension, capacity and allowReplaceDeleted
+// setting from the metadata file written next to it, so they do not have to be specified again as with Load.
+//
+// If the metadata file does not exist, e.g. for an index saved by an older version, the returned error wraps
+// fs.ErrNotExist and the index has to be loaded with Load.
+func LoadAuto(location string) (*HnswIndex, error) {
+	meta, err := loadMetadata(metadataPath(location))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("index metadata not found for %s, use Load instead: %w", location, err)
+		}
+		return nil, err
+	}
+
+	return Load(location, SpaceType(meta.SpaceType), meta.Dim, meta.MaxElements, meta.AllowReplaceDeleted)
+}
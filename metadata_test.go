@@ -0,0 +1,74 @@
+package hnswgo
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAuto(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "index.bin")
+
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), Linf, true)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+	points, labels := randomPoints(dim, 0, batchSize/2)
+	index.AddPoints(points, labels, 1, false)
+
+	if err := index.Save(location); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if !pathExists(metadataPath(location)) {
+		t.Fatal("expected a metadata file next to the index")
+	}
+
+	loaded, err := LoadAuto(location)
+	if err != nil {
+		t.Fatalf("LoadAuto failed: %v", err)
+	}
+	defer loaded.Close()
+
+	if loaded.Stats() != index.Stats() {
+		t.Errorf("expected %+v, got %+v", index.Stats(), loaded.Stats())
+	}
+	vec, err := loaded.GetDataByLabel(3)
+	if err != nil || vec[0] != points[3][0] {
+		t.Errorf("expected the stored vectors to be loaded, got %v", err)
+	}
+
+	t.Run("missing metadata", func(t *testing.T) {
+		os.Remove(metadataPath(location))
+		if _, err := LoadAuto(location); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected a not exist error, got %v", err)
+		}
+
+		// the explicit path still works.
+		explicit, err := Load(location, Linf, dim, batchSize, true)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		explicit.Close()
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		os.WriteFile(metadataPath(location), []byte("{"), 0644)
+		if _, err := LoadAuto(location); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected a parse error, got %v", err)
+		}
+	})
+
+	t.Run("WriteTo leaves no metadata", func(t *testing.T) {
+		before, _ := filepath.Glob(filepath.Join(os.TempDir(), "hnswgo-*.meta"))
+		if _, err := index.MarshalBinary(); err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		after, _ := filepath.Glob(filepath.Join(os.TempDir(), "hnswgo-*.meta"))
+		if len(after) > len(before) {
+			t.Error("expected the temporary metadata file not to be written")
+		}
+	})
+}
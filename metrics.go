@@ -0,0 +1,78 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import "time"
+
+// SearchStats reports the work done by a search call, summed over all the queried vectors.
+type SearchStats struct {
+	// number of distances computed between the query and the visited elements.
+	DistanceComputations uint64
+	// number of graph nodes whose neighbor lists were expanded.
+	Hops uint64
+	// wall time of the search call.
+	Duration time.Duration
+}
+
+// metricsSnapshot holds the hnswlib counters read before a search.
+type metricsSnapshot struct {
+	enabled              bool
+	distanceComputations uint64
+	hops                 uint64
+	start                time.Time
+}
+
+// EnableSearchMetrics makes the KNN searches of the index count their distance computations and graph hops,
+// which can then be read with LastSearchStats to tune ef. Collecting metrics adds a small overhead to each
+// query, so it is disabled by default. Filtered and range searches are not measured.
+func (idx *HnswIndex) EnableSearchMetrics(enable bool) {
+	if idx.index == nil {
+		return
+	}
+
+	var flag C.int
+	if enable {
+		flag = 1
+	}
+	C.setCollectMetrics(idx.index, flag)
+}
+
+// LastSearchStats returns the statistics of the last KNN search done while metrics were enabled, or the zero
+// SearchStats if there was none. hnswlib keeps a single set of counters per index, so the statistics of searches
+// running concurrently on the same index are mixed together.
+func (idx *HnswIndex) LastSearchStats() SearchStats {
+	if stats := idx.lastStats.Load(); stats != nil {
+		return *stats
+	}
+	return SearchStats{}
+}
+
+func (idx *HnswIndex) snapshotMetrics() metricsSnapshot {
+	if idx.index.collect_metrics == 0 {
+		return metricsSnapshot{}
+	}
+
+	var distanceComputations, hops C.uint64_t
+	C.getSearchMetrics(idx.index, &distanceComputations, &hops)
+	return metricsSnapshot{
+		enabled:              true,
+		distanceComputations: uint64(distanceComputations),
+		hops:                 uint64(hops),
+		start:                time.Now(),
+	}
+}
+
+// recordMetrics stores the statistics of the search started at the snapshot.
+func (idx *HnswIndex) recordMetrics(before metricsSnapshot) {
+	if !before.enabled {
+		return
+	}
+
+	var distanceComputations, hops C.uint64_t
+	C.getSearchMetrics(idx.index, &distanceComputations, &hops)
+	idx.lastStats.Store(&SearchStats{
+		DistanceComputations: uint64(distanceComputations) - before.distanceComputations,
+		Hops:                 uint64(hops) - before.hops,
+		Duration:             time.Since(before.start),
+	})
+}
@@ -0,0 +1,45 @@
+package hnswgo
+
+import "testing"
+
+func TestSearchMetrics(t *testing.T) {
+	index := newTestIndex(t, 1, false)
+	defer index.Close()
+	query := randomPoint(dim)
+
+	t.Run("disabled by default", func(t *testing.T) {
+		index.SearchKNN([][]float32{query}, 5, 1)
+		if stats := index.LastSearchStats(); stats != (SearchStats{}) {
+			t.Errorf("expected no statistics, got %+v", stats)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		index.SetEf(10)
+		plain, _ := index.SearchKNN([][]float32{query}, 5, 1)
+
+		index.EnableSearchMetrics(true)
+		defer index.EnableSearchMetrics(false)
+		results, err := index.SearchKNN([][]float32{query}, 5, 1)
+		if err != nil {
+			t.Fatalf("SearchKNN failed: %v", err)
+		}
+		for i := range results[0] {
+			if *results[0][i] != *plain[0][i] {
+				t.Errorf("result %d differs with metrics enabled: %v != %v", i, *results[0][i], *plain[0][i])
+			}
+		}
+
+		low := index.LastSearchStats()
+		if low.DistanceComputations == 0 || low.Hops == 0 || low.Duration <= 0 {
+			t.Fatalf("expected statistics to be recorded, got %+v", low)
+		}
+
+		index.SetEf(batchSize)
+		index.SearchKNN([][]float32{query}, 5, 1)
+		high := index.LastSearchStats()
+		if high.DistanceComputations <= low.DistanceComputations || high.Hops <= low.Hops {
+			t.Errorf("expected a larger ef to do more work: ef=10 %+v, ef=%d %+v", low, batchSize, high)
+		}
+	})
+}
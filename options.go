@@ -0,0 +1,116 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"fmt"
+)
+
+// Default values used by NewWithOptions for the zero fields of Options.
+const (
+	DefaultM              = 16
+	DefaultEfConstruction = 200
+	DefaultRandSeed       = 100
+)
+
+// Options holds the parameters of a new index, see New for their meaning. Zero values of M, EfConstruction and
+// RandSeed are replaced by DefaultM, DefaultEfConstruction and DefaultRandSeed, and the zero SpaceType is L2.
+// As a consequence a random seed of 0 cannot be used with NewWithOptions.
+//
+// Deterministic calls SetDeterministic on the new index, so that builds with the same RandSeed are reproducible.
+// StrictInput calls SetStrictInput, rejecting the vectors holding NaN or infinite values.
+type Options struct {
+	Dim                 int
+	MaxElements         uint64
+	M                   int
+	EfConstruction      int
+	RandSeed            int
+	SpaceType           SpaceType
+	AllowReplaceDeleted bool
+	Deterministic       bool
+	StrictInput         bool
+}
+
+// withDefaults returns a copy of opts with the zero fields replaced by their defaults.
+func (opts Options) withDefaults() Options {
+	if opts.M == 0 {
+		opts.M = DefaultM
+	}
+	if opts.EfConstruction == 0 {
+		opts.EfConstruction = DefaultEfConstruction
+	}
+	if opts.RandSeed == 0 {
+		opts.RandSeed = DefaultRandSeed
+	}
+	return opts
+}
+
+// NewWithOptions creates a new HnswIndex like New, with the parameters given as named fields. Dim and MaxElements
+// are required, an error is returned if they are not positive.
+func NewWithOptions(opts Options) (*HnswIndex, error) {
+	if opts.Dim <= 0 {
+		return nil, fmt.Errorf("%w: options: Dim must be positive", ErrInvalidInput)
+	}
+	if opts.MaxElements == 0 {
+		return nil, fmt.Errorf("%w: options: MaxElements must be positive", ErrInvalidInput)
+	}
+
+	opts = opts.withDefaults()
+	idx, err := New(opts.Dim, opts.M, opts.EfConstruction, opts.RandSeed, opts.MaxElements, opts.SpaceType, opts.AllowReplaceDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.SetDeterministic(opts.Deterministic)
+	idx.SetStrictInput(opts.StrictInput)
+	return idx, nil
+}
+
+// Config returns the parameters of the index as Options, so that NewWithOptions(idx.Config()) creates an empty
+// index with the same parameters and settings. MaxElements is the current capacity. hnswlib does not save the
+// random seed, so RandSeed is 0 for an index loaded from a file, which NewWithOptions replaces with
+// DefaultRandSeed. The zero Options is returned if the index is closed.
+func (idx *HnswIndex) Config() Options {
+	if idx.index == nil {
+		return Options{}
+	}
+
+	return Options{
+		Dim:                 idx.Dim(),
+		MaxElements:         idx.GetMaxElements(),
+		M:                   idx.M(),
+		EfConstruction:      idx.EfConstruction(),
+		RandSeed:            idx.randSeed,
+		SpaceType:           idx.SpaceType(),
+		AllowReplaceDeleted: idx.GetAllowReplaceDeleted(),
+		Deterministic:       idx.deterministic,
+		StrictInput:         idx.strictInput,
+	}
+}
+
+// AddPointsOptions holds the parameters of AddPointsWithOptions. Concurrency and ReplaceDeleted have the same
+// meaning as in AddPoints. A zero EfConstruction keeps the efConstruction of the index.
+//
+// hnswlib fixes M when the index is created, so the connectivity cannot be changed per batch.
+type AddPointsOptions struct {
+	EfConstruction int
+	Concurrency    int
+	ReplaceDeleted bool
+}
+
+// AddPointsWithOptions is like AddPoints, with the parameters given as named fields. If opts.EfConstruction is set,
+// the points are inserted with it instead of the efConstruction of the index, which is restored once they are
+// inserted, so batches can trade insertion speed for graph quality without calling SetEfConstruction. hnswlib reads
+// efConstruction from the index while inserting, so such a batch is inserted under the exclusive lock of the index,
+// waiting for the running searches and insertions, and other insertions keep their efConstruction.
+func (idx *HnswIndex) AddPointsWithOptions(vectors [][]float32, labels []uint64, opts AddPointsOptions) error {
+	if idx.index == nil {
+		return ErrIndexClosed
+	}
+
+	if opts.EfConstruction < 0 {
+		return fmt.Errorf("%w: options: invalid EfConstruction %d, must not be negative", ErrInvalidInput, opts.EfConstruction)
+	}
+
+	return idx.addPoints(vectors, labels, opts.Concurrency, opts.ReplaceDeleted, 0, opts.EfConstruction)
+}
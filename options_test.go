@@ -0,0 +1,208 @@
+package hnswgo
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	index, err := NewWithOptions(Options{Dim: dim, MaxElements: batchSize})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	defer index.Close()
+
+	if index.Dim() != dim || index.GetMaxElements() != batchSize {
+		t.Errorf("unexpected dim %d or maxElements %d", index.Dim(), index.GetMaxElements())
+	}
+	if index.M() != DefaultM || index.EfConstruction() != DefaultEfConstruction {
+		t.Errorf("expected default M and efConstruction, got %d and %d", index.M(), index.EfConstruction())
+	}
+	if index.SpaceType() != L2 || index.GetAllowReplaceDeleted() {
+		t.Errorf("unexpected space type %v or allowReplaceDeleted", index.SpaceType())
+	}
+
+	custom, err := NewWithOptions(Options{
+		Dim:                 dim,
+		MaxElements:         batchSize,
+		M:                   8,
+		EfConstruction:      50,
+		RandSeed:            7,
+		SpaceType:           Cosine,
+		AllowReplaceDeleted: true,
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	defer custom.Close()
+
+	if custom.M() != 8 || custom.EfConstruction() != 50 || custom.SpaceType() != Cosine || !custom.GetAllowReplaceDeleted() {
+		t.Errorf("options were not applied: %+v", custom.Stats())
+	}
+
+	for _, opts := range []Options{{MaxElements: batchSize}, {Dim: dim}} {
+		if _, err := NewWithOptions(opts); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestDeterministicBuild(t *testing.T) {
+	points, labels := randomPoints(dim, 0, 5*batchSize)
+	queries := genQuery(dim, 20)
+
+	build := func() *HnswIndex {
+		index, err := NewWithOptions(Options{
+			Dim:            dim,
+			MaxElements:    5 * batchSize,
+			M:              M,
+			EfConstruction: efConstruction,
+			RandSeed:       42,
+			Deterministic:  true,
+		})
+		if err != nil {
+			t.Fatalf("NewWithOptions failed: %v", err)
+		}
+
+		// concurrency is ignored by deterministic indexes.
+		if err := index.AddPoints(points, labels, 4, false); err != nil {
+			t.Fatalf("AddPoints failed: %v", err)
+		}
+		return index
+	}
+
+	a, b := build(), build()
+	defer a.Close()
+	defer b.Close()
+
+	resultsA, err := a.SearchKNN(queries, 10, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	resultsB, _ := b.SearchKNN(queries, 10, 1)
+
+	for i := range resultsA {
+		if len(resultsA[i]) != len(resultsB[i]) {
+			t.Fatalf("row %d: got %d and %d results", i, len(resultsA[i]), len(resultsB[i]))
+		}
+		for j := range resultsA[i] {
+			if *resultsA[i][j] != *resultsB[i][j] {
+				t.Errorf("row %d, result %d: %v != %v", i, j, *resultsA[i][j], *resultsB[i][j])
+			}
+		}
+	}
+}
+
+func TestAddPointsWithOptions(t *testing.T) {
+	index, err := NewWithOptions(Options{Dim: dim, MaxElements: 2 * batchSize, EfConstruction: 50})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	defer index.Close()
+
+	points, labels := randomPoints(dim, 0, batchSize)
+	err = index.AddPointsWithOptions(points, labels, AddPointsOptions{EfConstruction: 300, Concurrency: 2})
+	if err != nil {
+		t.Fatalf("AddPointsWithOptions failed: %v", err)
+	}
+	if index.GetCurrentCount() != batchSize {
+		t.Errorf("expected %d elements, got %d", batchSize, index.GetCurrentCount())
+	}
+	if index.EfConstruction() != 50 {
+		t.Errorf("expected efConstruction to be restored to 50, got %d", index.EfConstruction())
+	}
+
+	// overlapping batches with their own efConstruction restore it in turn.
+	var wg sync.WaitGroup
+	for i, ef := range []int{100, 400, 200, 300} {
+		wg.Add(1)
+		go func(i, ef int) {
+			defer wg.Done()
+			more, moreLabels := randomPoints(dim, batchSize+i*10, 10)
+			if err := index.AddPointsWithOptions(more, moreLabels, AddPointsOptions{EfConstruction: ef}); err != nil {
+				t.Errorf("AddPointsWithOptions failed: %v", err)
+			}
+		}(i, ef)
+	}
+	wg.Wait()
+	if index.EfConstruction() != 50 {
+		t.Errorf("expected efConstruction to be restored to 50 after concurrent batches, got %d", index.EfConstruction())
+	}
+
+	if err := index.AddPointsWithOptions(points, labels, AddPointsOptions{EfConstruction: -1}); err == nil {
+		t.Error("expected error for a negative efConstruction")
+	}
+	if err := index.AddPointsWithOptions(points[:1], labels[:1], AddPointsOptions{ReplaceDeleted: true}); err == nil {
+		t.Error("expected error for ReplaceDeleted on an index not allowing it")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	opts := Options{
+		Dim:                 dim,
+		MaxElements:         batchSize,
+		M:                   8,
+		EfConstruction:      50,
+		RandSeed:            7,
+		SpaceType:           IP,
+		AllowReplaceDeleted: true,
+		Deterministic:       true,
+		StrictInput:         true,
+	}
+	index, err := NewWithOptions(opts)
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	defer index.Close()
+
+	if config := index.Config(); config != opts {
+		t.Errorf("expected the options of the index, got %+v", config)
+	}
+
+	recreated, err := NewWithOptions(index.Config())
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	defer recreated.Close()
+	if config := recreated.Config(); config != opts {
+		t.Errorf("expected the recreated index to have the same options, got %+v", config)
+	}
+
+	// the seed is not saved.
+	location := filepath.Join(t.TempDir(), "index.bin")
+	points, labels := randomPoints(dim, 0, 10)
+	index.AddPoints(points, labels, 1, false)
+	if err := index.Save(location); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(location, IP, dim, batchSize, true)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer loaded.Close()
+	expected := opts
+	expected.RandSeed, expected.Deterministic, expected.StrictInput = 0, false, false
+	if config := loaded.Config(); config != expected {
+		t.Errorf("expected %+v for the loaded index, got %+v", expected, config)
+	}
+
+	// Compact keeps the seed of New, and uses DefaultRandSeed when it is not known.
+	if err := index.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if config := index.Config(); config.RandSeed != opts.RandSeed {
+		t.Errorf("expected seed %d after Compact, got %d", opts.RandSeed, config.RandSeed)
+	}
+	if err := loaded.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if config := loaded.Config(); config.RandSeed != DefaultRandSeed {
+		t.Errorf("expected seed %d after Compact of the loaded index, got %d", DefaultRandSeed, config.RandSeed)
+	}
+
+	index.Close()
+	if config := index.Config(); config != (Options{}) {
+		t.Errorf("expected the zero Options for a closed index, got %+v", config)
+	}
+}
@@ -0,0 +1,28 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import "runtime/cgo"
+
+// goAddProgress is called by C++ to report the insertion progress. handle refers to the func(done, total int)
+// registered by AddPointsProgress.
+//
+//export goAddProgress
+func goAddProgress(handle C.uintptr_t, done C.int, total C.int) {
+	progress := cgo.Handle(handle).Value().(func(done, total int))
+	progress(int(done), int(total))
+}
+
+// AddPointsProgress is like AddPoints but calls progress with the number of inserted points every 1000 points and
+// once all the points are inserted. Calls to progress are serialized even when concurrency is greater than 1, but
+// they may come from threads other than the calling goroutine's, so progress must be safe to call from anywhere
+// and should return quickly as it blocks the insertion. A nil progress is ignored.
+func (idx *HnswIndex) AddPointsProgress(vectors [][]float32, labels []uint64, concurrency int, replaceDeleted bool, progress func(done, total int)) error {
+	var handle cgo.Handle
+	if progress != nil {
+		handle = cgo.NewHandle(progress)
+		defer handle.Delete()
+	}
+
+	return idx.addPoints(vectors, labels, concurrency, replaceDeleted, handle, 0)
+}
@@ -0,0 +1,39 @@
+package hnswgo
+
+import (
+	"testing"
+)
+
+func TestAddPointsProgress(t *testing.T) {
+	const total = 2500
+
+	for _, concurrency := range []int{1, 4} {
+		index, err := New(dim, M, efConstruction, 55, total, Cosine, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		points, labels := randomPoints(dim, 0, total)
+		var reported []int
+		err = index.AddPointsProgress(points, labels, concurrency, false, func(done, n int) {
+			if n != total {
+				t.Errorf("expected total %d, got %d", total, n)
+			}
+			reported = append(reported, done)
+		})
+		index.Close()
+		if err != nil {
+			t.Fatalf("AddPointsProgress failed: %v", err)
+		}
+
+		if len(reported) == 0 || reported[len(reported)-1] != total {
+			t.Fatalf("concurrency %d: expected final progress %d, got %v", concurrency, total, reported)
+		}
+
+		for i := 1; i < len(reported); i++ {
+			if reported[i] <= reported[i-1] {
+				t.Errorf("concurrency %d: progress is not increasing: %v", concurrency, reported)
+			}
+		}
+	}
+}
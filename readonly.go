@@ -0,0 +1,89 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// offsets of the fields read from the header of the hnswlib file format, which starts with the size_t fields
+// offsetLevel0_, max_elements_, cur_element_count, size_data_per_element_, label_offset_ and offsetData_.
+const (
+	elementCountOffset = 16
+	labelOffsetOffset  = 32
+	dataOffsetOffset   = 40
+	headerSize         = 48
+)
+
+// LoadReadOnly loads the index saved at location for searching only, e.g. on read replicas. Compared to Load,
+// the capacity is set to the number of saved elements, at least 1, instead of the saved maximum, and the per
+// element locks and bookkeeping only used to modify the index are freed, which reduces memory. Methods modifying
+// the index, such as AddPoints, MarkDeleted or ResizeIndex, return an error on a read-only index.
+//
+// The parameters have the same meaning as in Load.
+func LoadReadOnly(location string, spaceType SpaceType, dim int) (*HnswIndex, error) {
+	header, err := readHeader(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// hnswlib needs a positive capacity, and searches of topK 1 must keep working on an empty index.
+	idx, err := Load(location, spaceType, dim, max(header.count, 1), false)
+	if err != nil {
+		return nil, err
+	}
+
+	C.makeReadOnly(idx.index)
+	return idx, nil
+}
+
+// indexHeader holds the fields read from the header of an index file by readHeader.
+type indexHeader struct {
+	// number of saved elements, deleted ones included.
+	count uint64
+	// dimension of the float vectors, which hnswlib stores between the level 0 links and the label of each element.
+	dim int
+}
+
+// readHeader reads the header of the index file saved at location.
+func readHeader(location string) (indexHeader, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return indexHeader{}, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, headerSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		if err == io.EOF {
+			return indexHeader{}, fmt.Errorf("invalid index file %s: truncated header", location)
+		}
+		return indexHeader{}, err
+	}
+
+	// hnswlib writes the header in native byte order, which is little-endian on all supported platforms.
+	field := func(offset int) uint64 {
+		return binary.LittleEndian.Uint64(buf[offset : offset+8])
+	}
+	labelOffset, dataOffset := field(labelOffsetOffset), field(dataOffsetOffset)
+	if labelOffset < dataOffset {
+		return indexHeader{}, fmt.Errorf("invalid index file %s: label offset %d before data offset %d", location, labelOffset, dataOffset)
+	}
+
+	return indexHeader{
+		count: field(elementCountOffset),
+		dim:   int((labelOffset - dataOffset) / 4),
+	}, nil
+}
+
+// ReadOnly reports whether the index was loaded by LoadReadOnly. Returns false if the index is closed.
+func (idx *HnswIndex) ReadOnly() bool {
+	return idx.index != nil && idx.readOnly()
+}
+
+func (idx *HnswIndex) readOnly() bool {
+	return idx.index.read_only != 0
+}
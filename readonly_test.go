@@ -0,0 +1,109 @@
+package hnswgo
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadReadOnly(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "index.bin")
+
+	index, err := New(dim, M, efConstruction, 55, 1000, L2, true)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+	points, labels := randomPoints(dim, 0, batchSize)
+	index.AddPoints(points, labels, 1, false)
+	index.MarkDeleted(2)
+	index.Save(location)
+
+	loaded, err := LoadReadOnly(location, L2, dim)
+	if err != nil {
+		t.Fatalf("LoadReadOnly failed: %v", err)
+	}
+	defer loaded.Close()
+
+	if !loaded.ReadOnly() || index.ReadOnly() {
+		t.Error("expected only the loaded index to be read-only")
+	}
+	if loaded.GetMaxElements() != batchSize {
+		t.Errorf("expected the capacity to shrink to %d, got %d", batchSize, loaded.GetMaxElements())
+	}
+	if loaded.MemoryUsageBytes() >= index.MemoryUsageBytes() {
+		t.Errorf("expected less memory than %d bytes, got %d", index.MemoryUsageBytes(), loaded.MemoryUsageBytes())
+	}
+
+	queries := genQuery(dim, 10)
+	expected, _ := index.SearchKNN(queries, 5, 1)
+	results, err := loaded.SearchKNN(queries, 5, 2)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if *results[i][j] != *expected[i][j] {
+				t.Errorf("row %d, result %d: expected %v, got %v", i, j, *expected[i][j], *results[i][j])
+			}
+		}
+	}
+	if _, err := loaded.Neighbors(0, 0); err != nil {
+		t.Errorf("Neighbors failed: %v", err)
+	}
+	if err := loaded.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	writes := map[string]func() error{
+		"AddPoints":     func() error { return loaded.AddPoints([][]float32{randomPoint(dim)}, []uint64{1000}, 1, false) },
+		"AddPoint":      func() error { return loaded.AddPoint(randomPoint(dim), 1000, false) },
+		"UpdatePoint":   func() error { return loaded.UpdatePoint(randomPoint(dim), 1, false) },
+		"MarkDeleted":   func() error { return loaded.MarkDeleted(1) },
+		"UnmarkDeleted": func() error { return loaded.UnmarkDeleted(2) },
+		"ResizeIndex":   func() error { return loaded.ResizeIndex(1000) },
+		"Clear":         loaded.Clear,
+		"Compact":       loaded.Compact,
+		"UnmarshalIndex": func() error {
+			data, err := index.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			return loaded.UnmarshalIndex(data, L2, dim, 1000)
+		},
+	}
+	for name, write := range writes {
+		if err := write(); err != ErrReadOnly {
+			t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+		}
+	}
+	if loaded.GetCurrentCount() != batchSize || loaded.GetDeletedCount() != 1 {
+		t.Errorf("read-only index was modified: %+v", loaded.Stats())
+	}
+}
+
+func TestLoadReadOnlyEmpty(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "empty.bin")
+
+	index, err := New(dim, M, efConstruction, 55, 10, L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+	if err := index.Save(location); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadReadOnly(location, L2, dim)
+	if err != nil {
+		t.Fatalf("LoadReadOnly failed: %v", err)
+	}
+	defer loaded.Close()
+
+	results, err := loaded.SearchKNN([][]float32{randomPoint(dim)}, 1, 1)
+	if err != nil {
+		t.Fatalf("SearchKNN failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0]) != 0 {
+		t.Errorf("expected no hits, got %v", results)
+	}
+}
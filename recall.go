@@ -0,0 +1,156 @@
+package hnswgo
+
+import "fmt"
+
+// Recall returns the average recall@k of approx against the exact results of the same queries, e.g. as returned
+// by BruteForceIndex.SearchKNN. For each query, the recall is the fraction of the first k exact labels that are
+// found in the first k approximate labels.
+//
+// Rows with fewer than k exact results are compared against the results they have, so that an index holding
+// fewer than k elements can still reach a recall of 1. Rows without any exact result are skipped, and 0 is
+// returned if all the rows are skipped. Recall panics if approx and exact have a different number of rows.
+func Recall(approx, exact [][]*SearchResult, k int) float64 {
+	if len(approx) != len(exact) {
+		panic("hnswgo: Recall called with different number of rows")
+	}
+
+	var total float64
+	var rows int
+	for i := range exact {
+		expected := exact[i][:min(k, len(exact[i]))]
+		if len(expected) == 0 {
+			continue
+		}
+
+		found := make(map[uint64]bool, len(expected))
+		for _, r := range approx[i][:min(k, len(approx[i]))] {
+			found[r.Label] = true
+		}
+
+		hits := 0
+		for _, r := range expected {
+			if found[r.Label] {
+				hits++
+			}
+		}
+
+		total += float64(hits) / float64(len(expected))
+		rows++
+	}
+
+	if rows == 0 {
+		return 0
+	}
+	return total / float64(rows)
+}
+
+// TuneEf returns the smallest ef for which the searches of queries for topK neighbors reach targetRecall, as
+// computed by Recall against groundTruth, which holds the exact labels of the nearest neighbors of each query in
+// any order. If groundTruth is nil, it is computed by searching a BruteForceIndex filled with the vectors of the
+// index, which takes as much memory again as the vectors.
+//
+// ef is binary searched between topK and the number of live elements, at which the search is close to exact, so
+// it takes a small number of batch searches with the default concurrency, see SetDefaultConcurrency. The recall
+// is not strictly monotonic in ef, so the result is the smallest ef found rather than the smallest possible.
+// An error is returned if targetRecall is not reached even with the largest ef.
+//
+// TuneEf sets the ef of the index while it searches and restores it before returning, so it must not be called
+// concurrently with other searches. Pass the result to SetEf to use it.
+func (idx *HnswIndex) TuneEf(queries [][]float32, groundTruth [][]uint64, targetRecall float64, topK int) (int, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	if len(queries) == 0 {
+		return 0, errNoVectorData
+	}
+
+	if groundTruth != nil && len(groundTruth) != len(queries) {
+		return 0, fmt.Errorf("%w: got %d ground truth rows for %d queries", ErrInvalidInput, len(groundTruth), len(queries))
+	}
+
+	if !(targetRecall > 0 && targetRecall <= 1) {
+		return 0, fmt.Errorf("%w: invalid target recall %v, must be in (0, 1]", ErrInvalidInput, targetRecall)
+	}
+
+	if topK <= 0 {
+		return 0, fmt.Errorf("%w: topK must be positive", ErrInvalidInput)
+	}
+
+	live := int(idx.GetLiveCount())
+	if live == 0 {
+		return 0, fmt.Errorf("%w: cannot tune ef of an empty index", ErrInvalidInput)
+	}
+
+	var exact [][]*SearchResult
+	if groundTruth != nil {
+		exact = make([][]*SearchResult, len(groundTruth))
+		for i, labels := range groundTruth {
+			exact[i] = make([]*SearchResult, len(labels))
+			for j, label := range labels {
+				exact[i][j] = &SearchResult{Label: label}
+			}
+		}
+	} else {
+		var err error
+		if exact, err = idx.exactSearch(queries, topK); err != nil {
+			return 0, err
+		}
+	}
+
+	ef := idx.GetEf()
+	defer idx.SetEf(ef)
+
+	recall := func(ef int) (float64, error) {
+		idx.SetEf(ef)
+		approx, err := idx.SearchKNN(queries, topK, 0)
+		if err != nil {
+			return 0, err
+		}
+		return Recall(approx, exact, topK), nil
+	}
+
+	lo, hi := topK, max(topK, live)
+	best, err := recall(hi)
+	if err != nil {
+		return 0, err
+	}
+	if best < targetRecall {
+		return 0, fmt.Errorf("target recall %v not reached, got %v with ef %d", targetRecall, best, hi)
+	}
+
+	// hi always reaches the target.
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		r, err := recall(mid)
+		if err != nil {
+			return 0, err
+		}
+		if r >= targetRecall {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return hi, nil
+}
+
+// exactSearch returns the exact topK neighbors of queries among the live elements of the index, searched with a
+// BruteForceIndex holding a copy of their vectors.
+func (idx *HnswIndex) exactSearch(queries [][]float32, topK int) ([][]*SearchResult, error) {
+	bf, err := NewBruteForce(idx.Dim(), idx.GetLiveCount(), idx.SpaceType())
+	if err != nil {
+		return nil, err
+	}
+	defer bf.Close()
+
+	err = idx.forEachLiveChunk(nil, func(labels []uint64, vectors [][]float32) error {
+		return bf.AddPoints(vectors, labels)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bf.SearchKNN(queries, min(topK, int(idx.GetLiveCount())), idx.threads(0))
+}
@@ -0,0 +1,136 @@
+package hnswgo
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func resultRow(labels ...uint64) []*SearchResult {
+	row := make([]*SearchResult, len(labels))
+	for i, label := range labels {
+		row[i] = &SearchResult{Label: label, Distance: float32(i)}
+	}
+	return row
+}
+
+func TestRecall(t *testing.T) {
+	cases := []struct {
+		name   string
+		approx [][]*SearchResult
+		exact  [][]*SearchResult
+		k      int
+		want   float64
+	}{
+		{"identical", [][]*SearchResult{resultRow(1, 2, 3)}, [][]*SearchResult{resultRow(1, 2, 3)}, 3, 1},
+		{"order does not matter", [][]*SearchResult{resultRow(3, 1, 2)}, [][]*SearchResult{resultRow(1, 2, 3)}, 3, 1},
+		{"partial", [][]*SearchResult{resultRow(1, 4, 5, 6)}, [][]*SearchResult{resultRow(1, 2, 3, 4)}, 4, 0.5},
+		{"only first k counted", [][]*SearchResult{resultRow(1, 9, 2)}, [][]*SearchResult{resultRow(1, 2, 9)}, 2, 0.5},
+		{"averaged over rows", [][]*SearchResult{resultRow(1, 2), resultRow(7, 8)}, [][]*SearchResult{resultRow(1, 2), resultRow(3, 4)}, 2, 0.5},
+		{"fewer exact than k", [][]*SearchResult{resultRow(1, 2)}, [][]*SearchResult{resultRow(1, 2)}, 10, 1},
+		{"fewer approx than k", [][]*SearchResult{resultRow(1)}, [][]*SearchResult{resultRow(1, 2)}, 2, 0.5},
+		{"empty exact row skipped", [][]*SearchResult{resultRow(1), resultRow(5)}, [][]*SearchResult{resultRow(1), resultRow()}, 1, 1},
+		{"no rows", nil, nil, 5, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Recall(c.approx, c.exact, c.k); math.Abs(got-c.want) > 1e-9 {
+				t.Errorf("expected recall %v, got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("hnsw against brute force", func(t *testing.T) {
+		index, err := New(dim, M, efConstruction, 55, uint64(batchSize), Cosine, false)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer index.Close()
+		index.SetEf(100)
+
+		bf, err := NewBruteForce(dim, uint64(batchSize), Cosine)
+		if err != nil {
+			t.Fatalf("NewBruteForce failed: %v", err)
+		}
+		defer bf.Close()
+
+		points, labels := randomPoints(dim, 0, batchSize)
+		index.AddPoints(points, labels, 1, false)
+		bf.AddPoints(points, labels)
+
+		queries := genQuery(dim, 10)
+		approx, _ := index.SearchKNN(queries, 10, 1)
+		exact, _ := bf.SearchKNN(queries, 10, 1)
+		if recall := Recall(approx, exact, 10); recall < 0.9 {
+			t.Errorf("expected a recall of at least 0.9, got %v", recall)
+		}
+	})
+}
+
+func TestTuneEf(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(5*batchSize), Cosine, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+	index.SetEf(7)
+
+	points, labels := randomPoints(dim, 0, 5*batchSize)
+	index.AddPoints(points, labels, 4, false)
+	queries := genQuery(dim, 20)
+
+	ef, err := index.TuneEf(queries, nil, 0.95, 10)
+	if err != nil {
+		t.Fatalf("TuneEf failed: %v", err)
+	}
+	if ef < 10 || ef > 5*batchSize {
+		t.Fatalf("expected ef between topK and the element count, got %d", ef)
+	}
+	if index.GetEf() != 7 {
+		t.Errorf("expected the ef of the index to be restored, got %d", index.GetEf())
+	}
+
+	bf, _ := NewBruteForce(dim, uint64(5*batchSize), Cosine)
+	defer bf.Close()
+	bf.AddPoints(points, labels)
+	exact, _ := bf.SearchKNN(queries, 10, 1)
+	groundTruth := make([][]uint64, len(exact))
+	for i, row := range exact {
+		for _, r := range row {
+			groundTruth[i] = append(groundTruth[i], r.Label)
+		}
+	}
+
+	// the ground truth of the brute force index gives the same result.
+	if got, err := index.TuneEf(queries, groundTruth, 0.95, 10); err != nil || got != ef {
+		t.Errorf("expected ef %d with the ground truth, got %d, %v", ef, got, err)
+	}
+
+	index.SetEf(ef)
+	approx, _ := index.SearchKNN(queries, 10, 1)
+	if recall := Recall(approx, exact, 10); recall < 0.95 {
+		t.Errorf("expected a recall of at least 0.95 with ef %d, got %v", ef, recall)
+	}
+
+	for _, c := range []struct {
+		name         string
+		groundTruth  [][]uint64
+		targetRecall float64
+		topK         int
+	}{
+		{"ground truth rows", groundTruth[:1], 0.9, 10},
+		{"target recall", nil, 1.5, 10},
+		{"topK", nil, 0.9, 0},
+	} {
+		if _, err := index.TuneEf(queries, c.groundTruth, c.targetRecall, c.topK); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", c.name, err)
+		}
+	}
+
+	// labels which are not in the index cannot be found.
+	unreachable := [][]uint64{{uint64(10 * batchSize)}}
+	if _, err := index.TuneEf(queries[:1], unreachable, 0.5, 10); err == nil {
+		t.Error("expected an error for an unreachable target recall")
+	}
+}
@@ -0,0 +1,106 @@
+package hnswgo
+
+// #include "hnsw_wrapper.h"
+import "C"
+import (
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+)
+
+// WriteTo serializes the index into w using the same format as Save, and returns the number of bytes written.
+// hnswlib can only write the index to a file path, so the index is first saved to a temporary file which
+// is then copied into w and removed.
+func (idx *HnswIndex) WriteTo(w io.Writer) (int64, error) {
+	if idx.index == nil {
+		return 0, ErrIndexClosed
+	}
+
+	tmp, err := os.CreateTemp("", "hnswgo-*.bin")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpName)
+
+	// the metadata is not part of the hnswlib format.
+	if err := idx.save(tmpName); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(tmpName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(w, f)
+}
+
+// LoadFrom loads an index serialized by WriteTo or Save from r. The parameters have the same meaning as in Load.
+// As with WriteTo, the data is buffered in a temporary file before being loaded by hnswlib.
+func LoadFrom(r io.Reader, spaceType SpaceType, dim int, maxElements uint64, allowReplaceDeleted bool) (*HnswIndex, error) {
+	tmp, err := os.CreateTemp("", "hnswgo-*.bin")
+	if err != nil {
+		return nil, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return Load(tmpName, spaceType, dim, maxElements, allowReplaceDeleted)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The returned bytes are identical to the file written by Save.
+func (idx *HnswIndex) MarshalBinary() ([]byte, error) {
+	if idx.index == nil {
+		return nil, ErrIndexClosed
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, idx.IndexFileSize()))
+	if _, err := idx.WriteTo(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalIndex replaces the index with the one serialized in data by MarshalBinary or Save. The previous
+// index, if any, is freed. The setting of allowReplaceDeleted of the previous index is kept. ErrReadOnly is
+// returned for an index loaded by LoadReadOnly.
+//
+// The hnswlib format does not store the space type and dimension, so they must be provided and HnswIndex does not
+// implement encoding.BinaryUnmarshaler. Unlike an index created by New or Load, no finalizer is
+// registered for an index that was not previously created by them, so Close must be called explicitly.
+func (idx *HnswIndex) UnmarshalIndex(data []byte, spaceType SpaceType, dim int, maxElements uint64) error {
+	if idx.index != nil && idx.readOnly() {
+		return ErrReadOnly
+	}
+
+	loaded, err := LoadFrom(bytes.NewReader(data), spaceType, dim, maxElements, idx.GetAllowReplaceDeleted())
+	if err != nil {
+		return err
+	}
+
+	if idx.index != nil {
+		C.freeHNSW(idx.index)
+	}
+	// move the C index over to the receiver.
+	idx.index = loaded.index
+	// the loaded index has no full save to track changes from.
+	idx.changes = &changeLog{}
+	// the seed, like for Load, is not stored. See Config.
+	idx.randSeed = 0
+	loaded.index = nil
+	runtime.SetFinalizer(loaded, nil)
+	return nil
+}
@@ -0,0 +1,98 @@
+package hnswgo
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestWriteToAndLoadFrom(t *testing.T) {
+	idx := newTestIndex(t, 1, false)
+	defer idx.Close()
+
+	var buf bytes.Buffer
+	n, err := idx.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	if n != int64(buf.Len()) || uint64(n) != idx.IndexFileSize() {
+		t.Errorf("expected %d bytes written, got %d", idx.IndexFileSize(), n)
+	}
+
+	loaded, err := LoadFrom(&buf, Cosine, dim, batchSize, false)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	defer loaded.Close()
+
+	if loaded.GetCurrentCount() != idx.GetCurrentCount() {
+		t.Errorf("expected %d elements, got %d", idx.GetCurrentCount(), loaded.GetCurrentCount())
+	}
+
+	want, _ := idx.GetDataByLabel(3)
+	got, err := loaded.GetDataByLabel(3)
+	if err != nil || !slices.Equal(want, got) {
+		t.Error("loaded vector does not match the original one")
+	}
+}
+
+func TestLoadFromInvalidData(t *testing.T) {
+	index, err := LoadFrom(bytes.NewReader([]byte("not an index")), Cosine, dim, batchSize, false)
+	if err == nil {
+		index.Close()
+		t.Fatal("expected error when loading invalid data")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	idx := newTestIndex(t, 1, true)
+	defer idx.Close()
+
+	data, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	idx.Save(testVectorDB)
+	t.Cleanup(func() {
+		deleteDB()
+	})
+	saved, err := os.ReadFile(testVectorDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, saved) {
+		t.Error("marshaled data differs from the saved file")
+	}
+
+	// unmarshal into an existing index, replacing its content.
+	other, err := New(dim, M, efConstruction, 55, 10, Cosine, true)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer other.Close()
+
+	if err := other.UnmarshalIndex(data, Cosine, dim, batchSize); err != nil {
+		t.Fatalf("UnmarshalIndex failed: %v", err)
+	}
+
+	if other.GetCurrentCount() != idx.GetCurrentCount() || !other.GetAllowReplaceDeleted() {
+		t.Error("unmarshaled index does not match the original one")
+	}
+	if seed := other.Config().RandSeed; seed != 0 {
+		t.Errorf("expected an unknown seed for the unmarshaled index, got %d", seed)
+	}
+
+	// unmarshal into a zero value index.
+	var empty HnswIndex
+	if err := empty.UnmarshalIndex(data, Cosine, dim, batchSize); err != nil {
+		t.Fatalf("UnmarshalIndex failed: %v", err)
+	}
+	defer empty.Close()
+
+	if empty.GetCurrentCount() != idx.GetCurrentCount() {
+		t.Error("unmarshaled index does not match the original one")
+	}
+}
@@ -0,0 +1,34 @@
+package hnswgo
+
+// IndexStats bundles the metadata of an index, as returned by the individual getters of HnswIndex.
+type IndexStats struct {
+	Dim                 int       `json:"dim"`
+	M                   int       `json:"m"`
+	EfConstruction      int       `json:"ef_construction"`
+	Ef                  int       `json:"ef"`
+	MaxElements         uint64    `json:"max_elements"`
+	CurrentCount        uint64    `json:"current_count"`
+	DeletedCount        uint64    `json:"deleted_count"`
+	SpaceType           SpaceType `json:"space_type"`
+	AllowReplaceDeleted bool      `json:"allow_replace_deleted"`
+}
+
+// Stats returns the metadata of the index in a single struct, e.g. for logging. The zero IndexStats is returned
+// if the index is closed.
+func (idx *HnswIndex) Stats() IndexStats {
+	if idx.index == nil {
+		return IndexStats{}
+	}
+
+	return IndexStats{
+		Dim:                 idx.Dim(),
+		M:                   idx.M(),
+		EfConstruction:      idx.EfConstruction(),
+		Ef:                  idx.GetEf(),
+		MaxElements:         idx.GetMaxElements(),
+		CurrentCount:        idx.GetCurrentCount(),
+		DeletedCount:        idx.GetDeletedCount(),
+		SpaceType:           idx.SpaceType(),
+		AllowReplaceDeleted: idx.GetAllowReplaceDeleted(),
+	}
+}
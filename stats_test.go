@@ -0,0 +1,46 @@
+package hnswgo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	index := newTestIndex(t, 1, true)
+	defer index.Close()
+	index.SetEf(50)
+	index.MarkDeleted(3)
+
+	stats := index.Stats()
+	expected := IndexStats{
+		Dim:                 dim,
+		M:                   M,
+		EfConstruction:      max(efConstruction, M),
+		Ef:                  50,
+		MaxElements:         batchSize,
+		CurrentCount:        batchSize,
+		DeletedCount:        1,
+		SpaceType:           Cosine,
+		AllowReplaceDeleted: true,
+	}
+	if stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var decoded IndexStats
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if decoded != stats {
+		t.Errorf("expected %+v after a JSON round trip, got %+v", stats, decoded)
+	}
+
+	index.Close()
+	if index.Stats() != (IndexStats{}) {
+		t.Error("expected zero stats for a closed index")
+	}
+}
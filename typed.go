@@ -0,0 +1,54 @@
+package hnswgo
+
+import (
+	"fmt"
+	"math"
+)
+
+// Label is the set of label types accepted by the typed helpers. Labels are stored as size_t by hnswlib.
+type Label interface {
+	~uint32 | ~uint64
+}
+
+// TypedSearchResult is a SearchResult with the label converted to the label type L.
+type TypedSearchResult[L Label] struct {
+	Label    L
+	Distance float32
+}
+
+// AddPointsT is like HnswIndex.AddPoints but accepts any label type satisfying Label. An error is returned
+// if a label does not fit in the size_t used by hnswlib, which can only happen for uint64 labels on 32-bit platforms.
+func AddPointsT[L Label](idx *HnswIndex, vectors [][]float32, labels []L, concurrency int, replaceDeleted bool) error {
+	converted := make([]uint64, len(labels))
+	for i, label := range labels {
+		if uint64(label) > math.MaxUint {
+			return fmt.Errorf("%w: label %d at row %d overflows size_t", ErrInvalidInput, uint64(label), i)
+		}
+		converted[i] = uint64(label)
+	}
+
+	return idx.AddPoints(vectors, converted, concurrency, replaceDeleted)
+}
+
+// SearchKNNT is like HnswIndex.SearchKNN but returns the labels as L. An error is returned if a found label
+// does not fit in L, e.g. when it was added as a uint64 larger than math.MaxUint32 and L is uint32.
+func SearchKNNT[L Label](idx *HnswIndex, vectors [][]float32, topK int, concurrency int) ([][]TypedSearchResult[L], error) {
+	results, err := idx.SearchKNN(vectors, topK, concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := make([][]TypedSearchResult[L], len(results))
+	for i, row := range results {
+		typed[i] = make([]TypedSearchResult[L], len(row))
+		for j, r := range row {
+			label := L(r.Label)
+			if uint64(label) != r.Label {
+				return nil, fmt.Errorf("%w: label %d overflows %T", ErrInvalidInput, r.Label, label)
+			}
+			typed[i][j] = TypedSearchResult[L]{Label: label, Distance: r.Distance}
+		}
+	}
+
+	return typed, nil
+}
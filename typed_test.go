@@ -0,0 +1,42 @@
+package hnswgo
+
+import (
+	"testing"
+)
+
+type docID uint32
+
+func TestTypedLabels(t *testing.T) {
+	index, err := New(dim, M, efConstruction, 55, uint64(batchSize), L2, false)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer index.Close()
+
+	points, _ := randomPoints(dim, 0, 10)
+	labels := make([]docID, len(points))
+	for i := range labels {
+		labels[i] = docID(1000 + i)
+	}
+
+	if err := AddPointsT(index, points, labels, 1, false); err != nil {
+		t.Fatalf("AddPointsT failed: %v", err)
+	}
+	if !index.ContainsLabel(1003) {
+		t.Error("expected label 1003 to be stored")
+	}
+
+	results, err := SearchKNNT[docID](index, points[3:4], 1, 1)
+	if err != nil {
+		t.Fatalf("SearchKNNT failed: %v", err)
+	}
+	if len(results[0]) != 1 || results[0][0].Label != 1003 {
+		t.Errorf("expected label 1003, got %v", results[0])
+	}
+
+	// a label added through the uint64 API does not fit in a uint32.
+	index.AddPoint(points[3], 1<<40, false)
+	if _, err := SearchKNNT[docID](index, points[3:4], 2, 1); err == nil {
+		t.Error("expected overflow error")
+	}
+}